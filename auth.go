@@ -0,0 +1,242 @@
+package stomp
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrAuthenticationFailed is returned by an Authenticator that
+	// rejects a client's credentials. It is the only detail a
+	// rejected client is told.
+	ErrAuthenticationFailed = errors.New("authentication failed")
+
+	// ErrNoCredentials is returned by an Authenticator when the
+	// client presented no credentials of the kind it checks.
+	ErrNoCredentials = fmt.Errorf("%w. no credentials", ErrAuthenticationFailed)
+)
+
+// A Principal is the identity of an authenticated client.
+type Principal struct {
+	// Name identifies the client, such as its login or the common
+	// name of its certificate.
+	Name string
+
+	// Method names the means by which the client was authenticated,
+	// such as "password" or "tls".
+	Method string
+
+	// Groups lists the groups the client belongs to, for use in
+	// authorization decisions.
+	Groups []string
+}
+
+// An AuthRequest holds the credentials a client presented when
+// connecting.
+type AuthRequest struct {
+	// Login and Passcode are the values of the CONNECT frame's
+	// login and passcode headers.
+	Login    string
+	Passcode string
+
+	// Header is the CONNECT frame's header, passcode included.
+	Header Header
+
+	// TLS is the state of the connection when it uses TLS, and nil
+	// otherwise.
+	TLS *tls.ConnectionState
+
+	// RemoteAddr is the client's network address, when known.
+	RemoteAddr net.Addr
+}
+
+// An Authenticator decides whether a client may connect, given the
+// credentials of its CONNECT frame. Authenticate returns the client's
+// principal, or an error wrapping ErrAuthenticationFailed if the
+// credentials are rejected. Other errors are treated alike, but are
+// logged as failures of the authenticator.
+type Authenticator interface {
+	Authenticate(ctx context.Context, req *AuthRequest) (*Principal, error)
+}
+
+// The AuthenticatorFunc type is an adapter to allow the use of an
+// ordinary function as an Authenticator.
+type AuthenticatorFunc func(ctx context.Context, req *AuthRequest) (*Principal, error)
+
+// Authenticate calls fn(ctx, req).
+func (fn AuthenticatorFunc) Authenticate(ctx context.Context, req *AuthRequest) (*Principal, error) {
+	return fn(ctx, req)
+}
+
+// StaticUsers is an Authenticator holding a fixed map of logins to
+// passcodes.
+type StaticUsers map[string]string
+
+// Authenticate accepts a login whose passcode matches.
+func (u StaticUsers) Authenticate(ctx context.Context, req *AuthRequest) (*Principal, error) {
+	if "" == req.Login {
+		return nil, ErrNoCredentials
+	}
+	passcode, ok := u[req.Login]
+
+	if !ok {
+		return nil, fmt.Errorf("%w. unknown login %q", ErrAuthenticationFailed, req.Login)
+	}
+
+	if 1 != subtle.ConstantTimeCompare([]byte(passcode), []byte(req.Passcode)) {
+		return nil, fmt.Errorf("%w. wrong passcode for %q", ErrAuthenticationFailed, req.Login)
+	}
+	return &Principal{Name: req.Login, Method: "password"}, nil
+}
+
+// An Htpasswd is an Authenticator checking passcodes against the
+// bcrypt hashes of an htpasswd file, as written by "htpasswd -B".
+type Htpasswd struct {
+	hashes map[string][]byte
+}
+
+// dummyHash is checked for unknown logins, so that they take as long
+// to reject as known ones.
+var dummyHash = []byte("$2b$10$aOljm4OHNWJaluKi.gYe8u/TUzlo4SGjnvcqIMRdkqTyagpi7hreC")
+
+// ParseHtpasswd reads an htpasswd file. Each line holds a login and
+// its bcrypt hash, separated by a colon; blank lines and lines
+// beginning with # are ignored. Hashes of other kinds are rejected.
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{hashes: make(map[string][]byte)}
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if "" == line || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+
+		if i <= 0 {
+			return nil, fmt.Errorf("htpasswd line %d: missing login", n)
+		}
+		hash := []byte(line[i+1:])
+
+		if _, costErr := bcrypt.Cost(hash); nil != costErr {
+			return nil, fmt.Errorf("htpasswd line %d: not a bcrypt hash", n)
+		}
+		h.hashes[line[:i]] = hash
+	}
+
+	if scanErr := scanner.Err(); nil != scanErr {
+		return nil, scanErr
+	}
+	return h, nil
+}
+
+// LoadHtpasswd reads the htpasswd file at path.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, openErr := os.Open(path)
+
+	if nil != openErr {
+		return nil, openErr
+	}
+	defer f.Close()
+	return ParseHtpasswd(f)
+}
+
+// Authenticate accepts a login whose passcode matches its hash.
+func (h *Htpasswd) Authenticate(ctx context.Context, req *AuthRequest) (*Principal, error) {
+	if "" == req.Login {
+		return nil, ErrNoCredentials
+	}
+	hash, known := h.hashes[req.Login]
+
+	if !known {
+		hash = dummyHash
+	}
+	compareErr := bcrypt.CompareHashAndPassword(hash, []byte(req.Passcode))
+
+	if !known {
+		return nil, fmt.Errorf("%w. unknown login %q", ErrAuthenticationFailed, req.Login)
+	}
+
+	if nil != compareErr {
+		return nil, fmt.Errorf("%w. wrong passcode for %q", ErrAuthenticationFailed, req.Login)
+	}
+	return &Principal{Name: req.Login, Method: "password"}, nil
+}
+
+// ClientCertificate is an Authenticator accepting clients that
+// present a TLS certificate verified by the server. The server's
+// tls.Config must request and verify client certificates, with a
+// ClientAuth of tls.VerifyClientCertIfGiven or stricter.
+type ClientCertificate struct {
+	// Name returns the principal name for a certificate. Nil
+	// selects the certificate's subject common name.
+	Name func(cert *x509.Certificate) string
+}
+
+// Authenticate accepts a client with a verified certificate. The
+// principal's groups are the organizational units of the certificate
+// subject.
+func (c *ClientCertificate) Authenticate(ctx context.Context, req *AuthRequest) (*Principal, error) {
+	if nil == req.TLS || 0 == len(req.TLS.PeerCertificates) {
+		return nil, ErrNoCredentials
+	}
+
+	if 0 == len(req.TLS.VerifiedChains) {
+		return nil, fmt.Errorf("%w. client certificate not verified", ErrAuthenticationFailed)
+	}
+	cert := req.TLS.PeerCertificates[0]
+	name := cert.Subject.CommonName
+
+	if nil != c.Name {
+		name = c.Name(cert)
+	}
+
+	if "" == name {
+		return nil, fmt.Errorf("%w. client certificate names no one", ErrAuthenticationFailed)
+	}
+	groups := append([]string(nil), cert.Subject.OrganizationalUnit...)
+	return &Principal{Name: name, Method: "tls", Groups: groups}, nil
+}
+
+type authChain []Authenticator
+
+// ChainAuthenticators returns an Authenticator that tries each of
+// auths in turn, accepting the first principal returned. If all of
+// them fail, the first error other than ErrNoCredentials is
+// returned.
+func ChainAuthenticators(auths ...Authenticator) Authenticator {
+	return authChain(auths)
+}
+
+func (c authChain) Authenticate(ctx context.Context, req *AuthRequest) (*Principal, error) {
+	var failure error
+
+	for _, a := range c {
+		p, authErr := a.Authenticate(ctx, req)
+
+		if nil == authErr {
+			return p, nil
+		}
+
+		if nil == failure && ErrNoCredentials != authErr {
+			failure = authErr
+		}
+	}
+
+	if nil == failure {
+		return nil, ErrNoCredentials
+	}
+	return nil, failure
+}
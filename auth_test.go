@@ -0,0 +1,118 @@
+package stomp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// htpasswdFile holds a hash of "secret" for alice.
+const htpasswdFile = `# users
+alice:$2b$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW
+
+`
+
+func TestAuthenticators(t *testing.T) {
+	ht, parseErr := ParseHtpasswd(strings.NewReader(htpasswdFile))
+
+	if nil != parseErr {
+		t.Fatal(parseErr)
+	}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "svc-orders", OrganizationalUnit: []string{"shop"}}}
+	verified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+	unverified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	chain := ChainAuthenticators(&ClientCertificate{}, StaticUsers{"bob": "hunter2"}, ht)
+
+	tests := []struct {
+		name string
+		auth Authenticator
+		req  AuthRequest
+		want string
+		err  error
+	}{
+		{"StaticUsers", StaticUsers{"bob": "hunter2"}, AuthRequest{Login: "bob", Passcode: "hunter2"}, "bob", nil},
+		{"StaticUsersWrong", StaticUsers{"bob": "hunter2"}, AuthRequest{Login: "bob", Passcode: "hunter3"}, "", ErrAuthenticationFailed},
+		{"StaticUsersUnknown", StaticUsers{"bob": "hunter2"}, AuthRequest{Login: "eve", Passcode: "hunter2"}, "", ErrAuthenticationFailed},
+		{"StaticUsersNoLogin", StaticUsers{"bob": "hunter2"}, AuthRequest{}, "", ErrNoCredentials},
+		{"Htpasswd", ht, AuthRequest{Login: "alice", Passcode: "secret"}, "alice", nil},
+		{"HtpasswdWrong", ht, AuthRequest{Login: "alice", Passcode: "Secret"}, "", ErrAuthenticationFailed},
+		{"HtpasswdUnknown", ht, AuthRequest{Login: "eve", Passcode: "secret"}, "", ErrAuthenticationFailed},
+		{"Certificate", &ClientCertificate{}, AuthRequest{TLS: verified}, "svc-orders", nil},
+		{"CertificateUnverified", &ClientCertificate{}, AuthRequest{TLS: unverified}, "", ErrAuthenticationFailed},
+		{"CertificateAbsent", &ClientCertificate{}, AuthRequest{Login: "bob"}, "", ErrNoCredentials},
+		{"ChainCertificate", chain, AuthRequest{TLS: verified}, "svc-orders", nil},
+		{"ChainStatic", chain, AuthRequest{Login: "bob", Passcode: "hunter2"}, "bob", nil},
+		{"ChainHtpasswd", chain, AuthRequest{Login: "alice", Passcode: "secret"}, "alice", nil},
+		{"ChainWrong", chain, AuthRequest{Login: "alice", Passcode: "hunter2"}, "", ErrAuthenticationFailed},
+		{"ChainNothing", chain, AuthRequest{}, "", ErrNoCredentials},
+	}
+
+	for _, test := range tests {
+		req := test.req
+		p, authErr := test.auth.Authenticate(context.Background(), &req)
+
+		if nil != test.err {
+			if !errors.Is(authErr, test.err) {
+				t.Errorf("%s: expected %v, got %v", test.name, test.err, authErr)
+			}
+			continue
+		}
+
+		if nil != authErr {
+			t.Errorf("%s: %v", test.name, authErr)
+			continue
+		}
+
+		if test.want != p.Name {
+			t.Errorf("%s: principal %q, want %q", test.name, p.Name, test.want)
+		}
+	}
+	p, _ := chain.Authenticate(context.Background(), &AuthRequest{TLS: verified})
+
+	if nil == p || "tls" != p.Method || 1 != len(p.Groups) || "shop" != p.Groups[0] {
+		t.Errorf("certificate principal = %+v", p)
+	}
+
+	for _, bad := range []string{"alice", "alice:plain", ":$2b$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW"} {
+		if _, parseErr = ParseHtpasswd(strings.NewReader(bad)); nil == parseErr {
+			t.Errorf("parsed htpasswd line %q", bad)
+		}
+	}
+}
+
+func TestServerAuthentication(t *testing.T) {
+	var principal *Principal
+	srv, connect := newTestServer(HandlerFunc(func(s *Session, f *Frame) error {
+		principal = s.Principal()
+		return nil
+	}))
+	defer srv.Close()
+	srv.Authenticator = StaticUsers{"guest": "guest"}
+
+	_, connectErr := connect(t, Header{HdrLogin: {"guest"}, HdrPasscode: {"wrong"}})
+	var serverErr *ServerError
+
+	if !errors.As(connectErr, &serverErr) || ErrAuthenticationFailed.Error() != serverErr.Message {
+		t.Fatalf("expected an ERROR frame saying %q, got %v", ErrAuthenticationFailed, connectErr)
+	}
+	client, connectErr := connect(t, Header{HdrLogin: {"guest"}, HdrPasscode: {"guest"}})
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	f := NewFrame(CmdSend, nil)
+	f.Header.Set(HdrReceipt, "sent")
+
+	if sendErr := client.Send(context.Background(), "/queue/a", f); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+
+	if nil == principal || "guest" != principal.Name {
+		t.Errorf("session principal = %+v", principal)
+	}
+	client.Close()
+}
@@ -0,0 +1,285 @@
+package stomp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrForbidden is returned by an Authorizer that denies a frame.
+var ErrForbidden = errors.New("forbidden")
+
+// An Authorizer decides whether a session may perform the action of a
+// frame. A Server consults its Authorizer for SEND, SUBSCRIBE, ACK,
+// NACK and BEGIN frames; if Authorize returns an error, the session
+// is sent an ERROR frame describing it and closed.
+type Authorizer interface {
+	Authorize(s *Session, f *Frame) error
+}
+
+// The AuthorizerFunc type is an adapter to allow the use of an
+// ordinary function as an Authorizer.
+type AuthorizerFunc func(s *Session, f *Frame) error
+
+// Authorize calls fn(s, f).
+func (fn AuthorizerFunc) Authorize(s *Session, f *Frame) error {
+	return fn(s, f)
+}
+
+// authorized reports whether the server consults its Authorizer for
+// frames with the given command.
+func authorized(cmd Command) bool {
+	switch cmd {
+	case CmdSend, CmdSubscribe, CmdAck, CmdNack, CmdBegin:
+		return true
+	}
+	return false
+}
+
+// An Effect is the outcome of an ACL rule.
+type Effect int
+
+const (
+	// Deny forbids the frames a rule matches.
+	Deny Effect = iota
+
+	// Allow permits the frames a rule matches.
+	Allow
+)
+
+func (e Effect) String() string {
+	if Allow == e {
+		return "allow"
+	}
+	return "deny"
+}
+
+// An ACLRule allows or denies frames. A rule matches a frame when
+// each of its lists is empty or has an entry matching the frame.
+type ACLRule struct {
+	// Effect is the rule's outcome.
+	Effect Effect
+
+	// Principals lists the principals the rule applies to: a
+	// principal's name, a group prefixed with "@", or "*" for any
+	// session, authenticated or not.
+	Principals []string
+
+	// Commands lists the commands the rule applies to.
+	Commands []Command
+
+	// Destinations lists patterns for the destinations the rule
+	// applies to. A "*" in a pattern matches any run of characters
+	// other than "/" and ".", and a pattern ending in ">" matches
+	// any destination beginning with the rest of it. A rule with
+	// destinations never matches frames without one, such as ACK.
+	Destinations []string
+}
+
+// MatchDestination reports whether destination matches pattern, as
+// described for ACLRule.
+func MatchDestination(pattern, destination string) bool {
+	for "" != pattern {
+		switch pattern[0] {
+		case '>':
+			if 1 == len(pattern) {
+				return "" != destination
+			}
+		case '*':
+			pattern = pattern[1:]
+
+			for i := 0; ; i++ {
+				if MatchDestination(pattern, destination[i:]) {
+					return true
+				}
+
+				if i == len(destination) || '/' == destination[i] || '.' == destination[i] {
+					return false
+				}
+			}
+		}
+
+		if "" == destination || pattern[0] != destination[0] {
+			return false
+		}
+		pattern, destination = pattern[1:], destination[1:]
+	}
+	return "" == destination
+}
+
+func (r *ACLRule) matches(p *Principal, f *Frame) bool {
+	if 0 != len(r.Principals) && !matchPrincipal(r.Principals, p) {
+		return false
+	}
+
+	if 0 != len(r.Commands) {
+		found := false
+
+		for _, cmd := range r.Commands {
+			found = found || cmd == f.Command
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if 0 == len(r.Destinations) {
+		return true
+	}
+	destination, ok := f.Header.Get(HdrDestination)
+
+	if !ok {
+		return false
+	}
+
+	for _, pattern := range r.Destinations {
+		if MatchDestination(pattern, destination) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPrincipal(entries []string, p *Principal) bool {
+	for _, entry := range entries {
+		if "*" == entry {
+			return true
+		}
+
+		if nil == p {
+			continue
+		}
+
+		if strings.HasPrefix(entry, "@") {
+			for _, g := range p.Groups {
+				if entry[1:] == g {
+					return true
+				}
+			}
+			continue
+		}
+
+		if entry == p.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// An ACL is an Authorizer applying a list of rules. A frame matched
+// by any Deny rule is forbidden, whatever the order of the rules;
+// otherwise a frame matched by an Allow rule is permitted. A frame
+// matched by no rule is forbidden unless DefaultAllow is set.
+type ACL struct {
+	Rules        []ACLRule
+	DefaultAllow bool
+}
+
+// Authorize applies the ACL's rules to f, for the principal of s.
+func (a *ACL) Authorize(s *Session, f *Frame) error {
+	p := s.Principal()
+	allowed := a.DefaultAllow
+
+	for i := range a.Rules {
+		if !a.Rules[i].matches(p, f) {
+			continue
+		}
+
+		if Deny == a.Rules[i].Effect {
+			return forbidden(f)
+		}
+		allowed = true
+	}
+
+	if !allowed {
+		return forbidden(f)
+	}
+	return nil
+}
+
+func forbidden(f *Frame) error {
+	if destination, ok := f.Header.Get(HdrDestination); ok {
+		return fmt.Errorf("%w. %s to %s", ErrForbidden, f.Command, destination)
+	}
+	return fmt.Errorf("%w. %s", ErrForbidden, f.Command)
+}
+
+// ParseACL reads an ACL from a text file with a rule on each line:
+//
+//	allow alice,@ops SEND,SUBSCRIBE /queue/orders.>
+//	deny * SEND /queue/orders.audit
+//	allow * ACK,NACK,BEGIN *
+//
+// Each line holds an effect, then comma-separated lists of
+// principals, commands and destination patterns, where a list of "*"
+// alone leaves the rule unrestricted. A last line "default allow"
+// sets DefaultAllow. Blank lines and lines beginning with # are
+// ignored.
+func ParseACL(r io.Reader) (*ACL, error) {
+	a := &ACL{}
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if "" == line || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+
+		if 2 == len(fields) && "default" == fields[0] {
+			switch fields[1] {
+			case "allow":
+				a.DefaultAllow = true
+			case "deny":
+				a.DefaultAllow = false
+			default:
+				return nil, fmt.Errorf("acl line %d: unknown effect %q", n, fields[1])
+			}
+			continue
+		}
+
+		if 4 != len(fields) {
+			return nil, fmt.Errorf("acl line %d: expected effect, principals, commands and destinations", n)
+		}
+		var rule ACLRule
+
+		switch fields[0] {
+		case "allow":
+			rule.Effect = Allow
+		case "deny":
+			rule.Effect = Deny
+		default:
+			return nil, fmt.Errorf("acl line %d: unknown effect %q", n, fields[0])
+		}
+		rule.Principals = aclList(fields[1])
+
+		for _, cmd := range aclList(fields[2]) {
+			command := Command(strings.ToUpper(cmd))
+
+			if !authorized(command) {
+				return nil, fmt.Errorf("acl line %d: %s frames are not authorized", n, command)
+			}
+			rule.Commands = append(rule.Commands, command)
+		}
+		rule.Destinations = aclList(fields[3])
+		a.Rules = append(a.Rules, rule)
+	}
+
+	if scanErr := scanner.Err(); nil != scanErr {
+		return nil, scanErr
+	}
+	return a, nil
+}
+
+// aclList splits a comma-separated list, in which "*" alone stands
+// for no restriction.
+func aclList(field string) []string {
+	if "*" == field {
+		return nil
+	}
+	return strings.Split(field, ",")
+}
@@ -0,0 +1,138 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchDestination(t *testing.T) {
+	tests := []struct {
+		pattern, destination string
+		want                 bool
+	}{
+		{"/queue/orders", "/queue/orders", true},
+		{"/queue/orders", "/queue/orders.eu", false},
+		{"/queue/orders.*", "/queue/orders.eu", true},
+		{"/queue/orders.*", "/queue/orders.eu.paris", false},
+		{"/queue/orders.*", "/queue/orders.", true},
+		{"/queue/*.audit", "/queue/orders.audit", true},
+		{"/queue/*.audit", "/queue/orders/x.audit", false},
+		{"/queue/orders.>", "/queue/orders.eu.paris", true},
+		{"/queue/orders.>", "/queue/orders.", false},
+		{"/queue/>", "/topic/news", false},
+		{"*", "/queue/a", false},
+		{">", "/queue/a", true},
+	}
+
+	for _, test := range tests {
+		if got := MatchDestination(test.pattern, test.destination); test.want != got {
+			t.Errorf("MatchDestination(%q, %q) = %v", test.pattern, test.destination, got)
+		}
+	}
+}
+
+func TestACL(t *testing.T) {
+	acl, parseErr := ParseACL(strings.NewReader(`
+# orders
+allow alice,@ops send,subscribe /queue/orders.>
+deny * SEND /queue/orders.audit
+allow * ACK,NACK,BEGIN *
+`))
+
+	if nil != parseErr {
+		t.Fatal(parseErr)
+	}
+	alice := &Session{principal: &Principal{Name: "alice"}}
+	carol := &Session{principal: &Principal{Name: "carol", Groups: []string{"ops"}}}
+	anonymous := &Session{}
+
+	frame := func(cmd Command, destination string) *Frame {
+		f := NewFrame(cmd, nil)
+
+		if "" != destination {
+			f.Header.Set(HdrDestination, destination)
+		}
+		return f
+	}
+	tests := []struct {
+		name string
+		s    *Session
+		f    *Frame
+		want bool
+	}{
+		{"ByName", alice, frame(CmdSend, "/queue/orders.eu"), true},
+		{"ByGroup", carol, frame(CmdSubscribe, "/queue/orders.eu"), true},
+		{"Anonymous", anonymous, frame(CmdSend, "/queue/orders.eu"), false},
+		{"OtherDestination", alice, frame(CmdSend, "/queue/payroll"), false},
+		{"DenyOverrides", alice, frame(CmdSend, "/queue/orders.audit"), false},
+		{"DenyOtherCommand", alice, frame(CmdSubscribe, "/queue/orders.audit"), true},
+		{"Ack", anonymous, frame(CmdAck, ""), true},
+	}
+
+	for _, test := range tests {
+		authErr := acl.Authorize(test.s, test.f)
+
+		if test.want && nil != authErr {
+			t.Errorf("%s: %v", test.name, authErr)
+		}
+
+		if !test.want && !errors.Is(authErr, ErrForbidden) {
+			t.Errorf("%s: expected %v, got %v", test.name, ErrForbidden, authErr)
+		}
+	}
+
+	for _, bad := range []string{"allow * SEND", "permit * SEND /queue/a", "allow * CONNECT /queue/a", "default maybe"} {
+		if _, parseErr = ParseACL(strings.NewReader(bad)); nil == parseErr {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+}
+
+func TestServerAuthorization(t *testing.T) {
+	srv, connect := newTestServer(HandlerFunc(func(s *Session, f *Frame) error {
+		return nil
+	}))
+	defer srv.Close()
+	srv.Authenticator = StaticUsers{"alice": "a"}
+	srv.Authorizer = &ACL{Rules: []ACLRule{
+		{Effect: Allow, Principals: []string{"alice"}, Commands: []Command{CmdSend}, Destinations: []string{"/queue/a.>"}},
+	}}
+	client, connectErr := connect(t, Header{HdrLogin: {"alice"}, HdrPasscode: {"a"}})
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer client.Close()
+	ctx := context.Background()
+	f := NewFrame(CmdSend, nil)
+	f.Header.Set(HdrReceipt, "allowed")
+
+	if sendErr := client.Send(ctx, "/queue/a.b", f); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+	f = NewFrame(CmdSend, nil)
+	f.Header.Set(HdrReceipt, "denied")
+	sendErr := client.Send(ctx, "/queue/b", f)
+	var serverErr *ServerError
+
+	if !errors.As(sendErr, &serverErr) {
+		t.Fatalf("expected an ERROR frame, got %v", sendErr)
+	}
+
+	if id, _ := serverErr.Header.Get(HdrReceiptId); "denied" != id {
+		t.Errorf("receipt-id = %q", id)
+	}
+
+	if !strings.HasPrefix(serverErr.Message, ErrForbidden.Error()) {
+		t.Errorf("message = %q", serverErr.Message)
+	}
+
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("session not closed after ERROR frame")
+	}
+}
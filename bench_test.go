@@ -0,0 +1,100 @@
+package stomp
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+var benchBody = bytes.Repeat([]byte("x"), 1024)
+
+func benchFrame() *Frame {
+	f := NewFrame(CmdSend, bytes.NewReader(benchBody))
+	f.Header.Set(HdrDestination, "/queue/bench")
+	f.Header.Set(HdrContentType, "text/plain")
+	f.Header.Set("x-sequence", "12345")
+	return f
+}
+
+func BenchmarkWriteFrame(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchBody)))
+
+	for i := 0; i < b.N; i++ {
+		if _, writeErr := benchFrame().WriteTo(ioutil.Discard); nil != writeErr {
+			b.Fatal(writeErr)
+		}
+	}
+}
+
+func BenchmarkReadFrame(b *testing.B) {
+	var buf bytes.Buffer
+
+	if _, writeErr := benchFrame().WriteTo(&buf); nil != writeErr {
+		b.Fatal(writeErr)
+	}
+	encoded := buf.Bytes()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchBody)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		f, readErr := ReadFrame(bytes.NewReader(encoded))
+
+		if nil != readErr {
+			b.Fatal(readErr)
+		}
+
+		if _, readErr = ioutil.ReadAll(f.Body); nil != readErr {
+			b.Fatal(readErr)
+		}
+		f.Body.Close()
+	}
+}
+
+func BenchmarkHandle(b *testing.B) {
+	left, right := net.Pipe()
+	sender, receiver := Bind(left), Bind(right)
+	defer sender.Release()
+	defer receiver.Release()
+
+	ctx := context.Background()
+	errc := make(chan error, 1)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchBody)))
+	b.ResetTimer()
+
+	go func() {
+		for i := 0; i < b.N; i++ {
+			if sendErr := sender.Send(ctx, benchFrame()); nil != sendErr {
+				errc <- sendErr
+				return
+			}
+		}
+		errc <- nil
+	}()
+
+	for received := 0; received < b.N; {
+		f, readErr := receiver.Receive(ctx)
+
+		if nil != readErr {
+			b.Fatal(readErr)
+		}
+
+		if nil == f {
+			continue
+		}
+
+		if _, readErr = ioutil.ReadAll(f.Body); nil != readErr {
+			b.Fatal(readErr)
+		}
+		f.Body.Close()
+		received++
+	}
+
+	if sendErr := <-errc; nil != sendErr {
+		b.Fatal(sendErr)
+	}
+}
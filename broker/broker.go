@@ -0,0 +1,1357 @@
+// Package broker implements an in-memory STOMP message broker, for
+// use as a stomp.Server handler in tests, examples and small
+// deployments.
+//
+// Destinations beginning with QueuePrefix are queues: each message
+// sent to a queue is delivered to one of its subscribers in turn, and
+// is kept until a subscriber arrives. Every other destination is a
+// topic, whose messages are delivered to every current subscriber
+// and are otherwise discarded. The broker supports the auto, client
+// and client-individual ack modes, returning the unacknowledged
+// messages of a queue to it when their subscription ends or they are
+// rejected with NACK, and transactions spanning SEND, ACK and NACK
+// frames.
+//
+// A broker opened with a MessageStore records the messages of its
+// queues in it, and recovers them when it is opened again.
+//
+// A message may be given an expiry time, by its expires header or by
+// a default time to live for its destination. An expired message is
+// no longer delivered, and a message that keeps being returned
+// unacknowledged may be taken out of its queue after a maximum number
+// of deliveries. Both are moved to a dead-letter destination, when
+// one is configured, and are otherwise discarded.
+//
+// A queue subscription in client or client-individual ack mode may
+// limit the number of messages it is delivered and has yet to
+// acknowledge with a prefetch-count header, or the ActiveMQ header
+// activemq.prefetchSize. A subscription with a full prefetch window is
+// passed over until it acknowledges messages.
+//
+// A subscription with a selector header, as described by the selector
+// package, receives only the messages whose headers the selector is
+// true of. Queue messages no consumer selects wait in their queue.
+//
+// A message sent with a delay header, in milliseconds, or a
+// deliver-at header, in milliseconds since the Unix epoch, is held by
+// the broker until it is due. Scheduled queue messages are kept in
+// the broker's store, and are scheduled again when recovered from it;
+// scheduled topic messages are lost when the broker closes.
+//
+// Queue messages sharing a message-group header are delivered to one
+// consumer, which owns the group until it unsubscribes or a message
+// with a message-group-seq header of -1 closes the group, so that the
+// messages of a group are processed in order. A group without an owner
+// is given to the ready consumer owning the fewest groups, and the
+// first message delivered to its new owner is marked with a
+// message-group-first header.
+package broker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jjware/stomp"
+	"github.com/jjware/stomp/broker/store"
+	"github.com/jjware/stomp/selector"
+)
+
+// QueuePrefix begins the names of queue destinations.
+const QueuePrefix = "/queue/"
+
+const (
+	// HdrRedelivered is set to "true" on MESSAGE frames carrying a
+	// message that was delivered before without being acknowledged.
+	HdrRedelivered = "redelivered"
+
+	// HdrDeliveryCount is set on MESSAGE frames to the number of
+	// times their message has been delivered, this time included.
+	HdrDeliveryCount = "delivery-count"
+
+	// HdrExpires holds the time at which a message expires, in
+	// milliseconds since the Unix epoch. Zero means never.
+	HdrExpires = "expires"
+
+	// HdrOriginalDestination, HdrOriginalExpires and
+	// HdrDeadLetterReason are set on a dead-lettered message to the
+	// destination it was sent to, its expiry time and the reason it
+	// was dead-lettered.
+	HdrOriginalDestination = "original-destination"
+	HdrOriginalExpires     = "original-expires"
+	HdrDeadLetterReason    = "dead-letter-reason"
+
+	// HdrMessageGroup names the group of a queue message, whose
+	// messages are delivered to the same consumer in order.
+	HdrMessageGroup = "message-group"
+
+	// HdrMessageGroupSeq is set to -1 on the last message of a group,
+	// closing it once the message is delivered.
+	HdrMessageGroupSeq = "message-group-seq"
+
+	// HdrMessageGroupFirst is set to "true" on MESSAGE frames carrying
+	// the first message of a group delivered to its owner.
+	HdrMessageGroupFirst = "message-group-first"
+)
+
+// The reasons a message is dead-lettered for.
+const (
+	ReasonExpired       = "expired"
+	ReasonMaxDeliveries = "max-deliveries"
+)
+
+// HdrActiveMQPrefetchSize is the ActiveMQ header accepted in place of
+// stomp.HdrPrefetchCount.
+const HdrActiveMQPrefetchSize = "activemq.prefetchSize"
+
+// DefaultExpiryInterval is the interval at which a broker looks for
+// expired messages in its queues, unless Options sets another.
+const DefaultExpiryInterval = time.Second
+
+// A Broker routes messages between the sessions of a stomp.Server.
+// It implements stomp.SessionHandler. The methods of a Broker are
+// thread safe.
+type Broker struct {
+	mu       sync.Mutex
+	queues   map[string]*queue
+	topics   map[string][]*subscription
+	sessions map[*stomp.Session]*session
+	nextID   uint64
+	store    store.MessageStore
+	opts     Options
+
+	// batch collects the changes of a transaction being committed.
+	batch *batch
+
+	// sweeping is set once the broker looks for expired messages,
+	// until done is closed.
+	sweeping bool
+	done     chan struct{}
+
+	// closing counts the sessions being closed, whose deliveries
+	// Close waits to settle before closing the store.
+	closing sync.WaitGroup
+
+	// wheel holds scheduled messages until they are due, and
+	// scheduling is set while the broker delivers them.
+	wheel      *wheel
+	scheduling bool
+}
+
+// Options configure a Broker.
+type Options struct {
+	// Store records the messages of the broker's queues. Nil keeps
+	// them in memory alone.
+	Store store.MessageStore
+
+	// TTL maps destination patterns, as accepted by
+	// stomp.MatchDestination, to the time to live of the messages
+	// sent to them without an expires header. Of the patterns
+	// matching a destination, the longest applies.
+	TTL map[string]time.Duration
+
+	// MaxDeliveries is the number of times a queue message may be
+	// delivered and returned unacknowledged before it is
+	// dead-lettered. Zero leaves it unlimited. Delivery counts start
+	// afresh when messages are recovered from a store.
+	MaxDeliveries int
+
+	// DeadLetter is the destination that expired and undeliverable
+	// messages are sent to, with their original headers. Empty
+	// discards them.
+	DeadLetter string
+
+	// ExpiryInterval is the interval at which the broker looks for
+	// expired messages waiting in its queues. Zero selects
+	// DefaultExpiryInterval.
+	ExpiryInterval time.Duration
+
+	// Prefetch is the prefetch window of queue subscriptions in
+	// client or client-individual ack mode that do not set one. Zero
+	// leaves it unlimited.
+	Prefetch int
+
+	// ScheduleTick is the resolution at which scheduled messages are
+	// delivered. Zero selects DefaultScheduleTick.
+	ScheduleTick time.Duration
+}
+
+// New returns an empty broker.
+func New() *Broker {
+	b, _ := Open(Options{})
+	return b
+}
+
+// Open returns a broker configured by opts, holding the queue
+// messages recovered from its store.
+func Open(opts Options) (*Broker, error) {
+	b := &Broker{
+		queues:   make(map[string]*queue),
+		topics:   make(map[string][]*subscription),
+		sessions: make(map[*stomp.Session]*session),
+		store:    opts.Store,
+		opts:     opts,
+		done:     make(chan struct{}),
+	}
+
+	if 0 == b.opts.ExpiryInterval {
+		b.opts.ExpiryInterval = DefaultExpiryInterval
+	}
+
+	if 0 == b.opts.ScheduleTick {
+		b.opts.ScheduleTick = DefaultScheduleTick
+	}
+	b.wheel = newWheel(b.opts.ScheduleTick, time.Now())
+
+	if nil == b.store {
+		return b, nil
+	}
+	recovered, recoverErr := b.store.Recover()
+
+	if nil != recoverErr {
+		return nil, recoverErr
+	}
+
+	// Scheduled and expiring messages start goroutines using the
+	// broker.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+
+	for _, stored := range recovered {
+		m := &message{
+			id:          stored.ID,
+			destination: stored.Destination,
+			header:      stored.Header,
+			body:        stored.Body,
+		}
+		m.expires, _ = parseExpires(m.header)
+		m.due, _ = parseDeliverAt(m.header)
+
+		if m.due.After(now) {
+			b.schedule(m)
+		} else {
+			q := b.queue(stored.Destination)
+			q.messages = append(q.messages, m)
+
+			if !m.expires.IsZero() {
+				b.sweep()
+			}
+		}
+
+		// Message ids must not repeat those of recovered messages.
+		n, parseErr := strconv.ParseUint(strings.TrimPrefix(stored.ID, "message-"), 10, 64)
+
+		if nil == parseErr && n > b.nextID {
+			b.nextID = n
+		}
+	}
+	return b, nil
+}
+
+// Close stops the broker looking for expired messages, ends its
+// sessions and closes its store once the deliveries of the frames
+// already written are settled. The broker must not be used
+// afterwards.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+	sessions := b.sessions
+	b.sessions = make(map[*stomp.Session]*session)
+	b.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.end()
+	}
+	b.closing.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if nil == b.store {
+		return nil
+	}
+	return b.store.Close()
+}
+
+// A message is a message sent to a destination.
+type message struct {
+	id          string
+	destination string
+	header      stomp.Header
+	body        []byte
+	redelivered bool
+	expires     time.Time
+	deliveries  int
+
+	// due is the time a scheduled message is held until.
+	due time.Time
+}
+
+// expired reports whether m expired by now.
+func (m *message) expired(now time.Time) bool {
+	return !m.expires.IsZero() && !now.Before(m.expires)
+}
+
+// parseExpires returns the time of a header's expires header, or the
+// zero time if it has none.
+func parseExpires(header stomp.Header) (time.Time, error) {
+	v, ok := header.Get(HdrExpires)
+
+	if !ok {
+		return time.Time{}, nil
+	}
+	ms, parseErr := strconv.ParseInt(v, 10, 64)
+
+	if nil != parseErr || ms < 0 {
+		return time.Time{}, fmt.Errorf("invalid %s header %q", HdrExpires, v)
+	}
+
+	if 0 == ms {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+// frame builds the MESSAGE frame delivering m to sub. An ackID is
+// given when the delivery must be acknowledged.
+func (m *message) frame(sub *subscription, ackID string, first bool) *stomp.Frame {
+	f := stomp.NewFrame(stomp.CmdMessage, bytes.NewReader(m.body))
+
+	for k, v := range m.header {
+		f.Header[k] = append([]string(nil), v...)
+	}
+	f.Header.Set(stomp.HdrDestination, m.destination)
+	f.Header.Set(stomp.HdrMessageId, m.id)
+	f.Header.Set(stomp.HdrSubscription, sub.id)
+
+	if "" != ackID {
+		f.Header.Set(stomp.HdrAck, ackID)
+	}
+
+	if m.redelivered {
+		f.Header.Set(HdrRedelivered, "true")
+	}
+	f.Header.Set(HdrDeliveryCount, strconv.Itoa(m.deliveries))
+
+	if first {
+		f.Header.Set(HdrMessageGroupFirst, "true")
+	}
+	return f
+}
+
+// A delivery is a message delivered to a subscription and awaiting
+// acknowledgement. A queue message delivered in auto ack mode awaits
+// the writing of its frame instead.
+type delivery struct {
+	ackID string
+	msg   *message
+	sub   *subscription
+}
+
+type subscription struct {
+	sess        *session
+	id          string
+	destination string
+	ack         string
+	unacked     []*delivery
+
+	// prefetch is the number of unacknowledged messages beyond
+	// which the subscription is delivered no more queue messages.
+	// Zero leaves it unlimited.
+	prefetch int
+
+	// selector, if not nil, selects the messages the subscription
+	// receives.
+	selector *selector.Selector
+
+	// groups is the number of message groups the subscription owns.
+	groups int
+}
+
+// selects reports whether sub receives m.
+func (sub *subscription) selects(m *message) bool {
+	return nil == sub.selector || sub.selector.Match(m.header)
+}
+
+// ready reports whether sub may be delivered another queue message.
+func (sub *subscription) ready() bool {
+	return 0 == sub.prefetch || len(sub.unacked) < sub.prefetch
+}
+
+type transaction struct {
+	frames []*stomp.Frame
+}
+
+// A batch holds the changes of a transaction being committed, to be
+// stored as one.
+type batch struct {
+	sent  []*message
+	acked []*message
+}
+
+type session struct {
+	s    *stomp.Session
+	out  *outbox
+	subs map[string]*subscription
+	txs  map[string]*transaction
+}
+
+// end closes the session and waits for its outbox to stop, once the
+// frame being written, if any, is settled.
+func (sess *session) end() {
+	sess.s.Close()
+	sess.out.close()
+	sess.out.wait()
+}
+
+type queue struct {
+	name      string
+	messages  []*message
+	consumers []*subscription
+	next      int
+
+	// groups maps the message groups of the queue to the consumers
+	// owning them.
+	groups map[string]*subscription
+}
+
+func isQueue(destination string) bool {
+	return strings.HasPrefix(destination, QueuePrefix)
+}
+
+// stored returns the store's record of m.
+func (m *message) stored() *store.Message {
+	return &store.Message{ID: m.id, Destination: m.destination, Header: m.header, Body: m.body}
+}
+
+// persist records messages sent to queues and the acknowledgement of
+// others in the broker's store, or in the batch of the transaction
+// being committed.
+func (b *Broker) persist(sent []*message, acked []*message) error {
+	if nil != b.batch {
+		b.batch.sent = append(b.batch.sent, sent...)
+		b.batch.acked = append(b.batch.acked, acked...)
+		return nil
+	}
+
+	if nil == b.store {
+		return nil
+	}
+	var add []*store.Message
+	var remove []string
+
+	for _, m := range sent {
+		if isQueue(m.destination) {
+			add = append(add, m.stored())
+		}
+	}
+
+	for _, m := range acked {
+		if isQueue(m.destination) {
+			remove = append(remove, m.id)
+		}
+	}
+
+	if 0 == len(add) && 0 == len(remove) {
+		return nil
+	}
+	return b.store.Commit(add, remove)
+}
+
+func (b *Broker) newID(prefix string) string {
+	b.nextID++
+	return prefix + strconv.FormatUint(b.nextID, 10)
+}
+
+func (b *Broker) queue(name string) *queue {
+	q, ok := b.queues[name]
+
+	if !ok {
+		q = &queue{name: name, groups: make(map[string]*subscription)}
+		b.queues[name] = q
+	}
+	return q
+}
+
+// OpenSession registers a new session with the broker.
+func (b *Broker) OpenSession(s *stomp.Session) error {
+	sess := &session{
+		s:    s,
+		out:  newOutbox(b.written),
+		subs: make(map[string]*subscription),
+		txs:  make(map[string]*transaction),
+	}
+	go sess.out.run(s)
+
+	b.mu.Lock()
+	b.sessions[s] = sess
+	b.mu.Unlock()
+	return nil
+}
+
+// CloseSession ends a session's subscriptions and transactions,
+// returning its unacknowledged queue messages to their queues.
+func (b *Broker) CloseSession(s *stomp.Session) {
+	b.mu.Lock()
+	sess, ok := b.sessions[s]
+
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.sessions, s)
+	b.closing.Add(1)
+	b.mu.Unlock()
+	defer b.closing.Done()
+
+	// The frames written before the session ended settle their
+	// deliveries before the others return to their queues.
+	sess.end()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, sub := range sess.subs {
+		b.unsubscribe(sub)
+	}
+}
+
+// ServeFrame handles a frame sent by a client.
+func (b *Broker) ServeFrame(s *stomp.Session, f *stomp.Frame) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sess, ok := b.sessions[s]
+
+	if !ok {
+		return stomp.ErrSessionClosed
+	}
+
+	switch f.Command {
+	case stomp.CmdSend, stomp.CmdAck, stomp.CmdNack:
+		if txID, ok := f.Header.Get(stomp.HdrTransaction); ok {
+			return b.enlist(sess, txID, f)
+		}
+		return b.apply(sess, f)
+	case stomp.CmdSubscribe:
+		return b.subscribe(sess, f)
+	case stomp.CmdUnsubscribe:
+		id, _ := f.Header.Get(stomp.HdrId)
+
+		if "" == id {
+			id, _ = f.Header.Get(stomp.HdrDestination)
+		}
+		sub, ok := sess.subs[id]
+
+		if !ok {
+			return fmt.Errorf("no subscription with id %q", id)
+		}
+		b.unsubscribe(sub)
+		return nil
+	case stomp.CmdBegin, stomp.CmdCommit, stomp.CmdAbort:
+		return b.transact(sess, f)
+	}
+	return fmt.Errorf("unsupported frame %s", f.Command)
+}
+
+// apply performs a SEND, ACK or NACK frame outside of, or on the
+// commit of, a transaction.
+func (b *Broker) apply(sess *session, f *stomp.Frame) error {
+	switch f.Command {
+	case stomp.CmdSend:
+		return b.send(f)
+	case stomp.CmdAck:
+		return b.ack(sess, f, false)
+	case stomp.CmdNack:
+		return b.ack(sess, f, true)
+	}
+	return fmt.Errorf("unsupported frame %s", f.Command)
+}
+
+// checkSend returns the error sending a message with the given header
+// would meet.
+func checkSend(header stomp.Header) error {
+	if destination, _ := header.Get(stomp.HdrDestination); "" == destination {
+		return fmt.Errorf("missing %s header", stomp.HdrDestination)
+	}
+
+	if _, expiresErr := parseExpires(header); nil != expiresErr {
+		return expiresErr
+	}
+	_, delayed, delayErr := parseDelay(header)
+
+	// A delay header stands in for the deliver-at header.
+	if delayed || nil != delayErr {
+		return delayErr
+	}
+	_, dueErr := parseDeliverAt(header)
+	return dueErr
+}
+
+func (b *Broker) send(f *stomp.Frame) error {
+	if checkErr := checkSend(f.Header); nil != checkErr {
+		return checkErr
+	}
+	destination, _ := f.Header.Get(stomp.HdrDestination)
+	var body []byte
+
+	if nil != f.Body {
+		var readErr error
+		body, readErr = ioutil.ReadAll(f.Body)
+
+		if nil != readErr {
+			return readErr
+		}
+	}
+	header := make(stomp.Header, len(f.Header))
+
+	for k, v := range f.Header {
+		switch k {
+		case stomp.HdrTransaction, stomp.HdrReceipt, stomp.HdrContentLength:
+			continue
+		}
+		header[k] = append([]string(nil), v...)
+	}
+
+	expires, expiresErr := parseExpires(header)
+
+	if nil != expiresErr {
+		return expiresErr
+	}
+	due, dueErr := scheduleHeader(header, time.Now())
+
+	if nil != dueErr {
+		return dueErr
+	}
+
+	if _, ok := header.Get(HdrExpires); !ok {
+		if ttl := b.ttl(destination); ttl > 0 {
+			expires = time.Now().Add(ttl)
+			header.Set(HdrExpires, strconv.FormatInt(expires.UnixNano()/int64(time.Millisecond), 10))
+		}
+	}
+	m := &message{
+		id:          b.newID("message-"),
+		destination: destination,
+		header:      header,
+		body:        body,
+		expires:     expires,
+		due:         due,
+	}
+
+	if persistErr := b.persist([]*message{m}, nil); nil != persistErr {
+		return persistErr
+	}
+
+	// The messages of a transaction are published once it is stored.
+	if nil == b.batch {
+		b.publish(m)
+	}
+	return nil
+}
+
+// ttl returns the time to live of messages sent to destination
+// without an expires header.
+func (b *Broker) ttl(destination string) time.Duration {
+	var ttl time.Duration
+	longest := -1
+
+	for pattern, d := range b.opts.TTL {
+		if len(pattern) > longest && stomp.MatchDestination(pattern, destination) {
+			ttl, longest = d, len(pattern)
+		}
+	}
+	return ttl
+}
+
+// publish routes m to its destination, or holds it until it is due.
+func (b *Broker) publish(m *message) {
+	now := time.Now()
+
+	if m.expired(now) {
+		b.deadLetter(m, ReasonExpired)
+		return
+	}
+
+	if m.due.After(now) {
+		b.schedule(m)
+		return
+	}
+
+	if isQueue(m.destination) {
+		q := b.queue(m.destination)
+		q.messages = append(q.messages, m)
+
+		if !m.expires.IsZero() {
+			b.sweep()
+		}
+		b.dispatch(q)
+		return
+	}
+
+	for _, sub := range b.topics[m.destination] {
+		if sub.selects(m) {
+			b.deliver(sub, m, false)
+		}
+	}
+}
+
+// dispatch delivers the messages waiting on q to its consumers in
+// turn, passing over those with a full prefetch window and those that
+// do not select a message. Grouped messages go to the owner of their
+// group, and wait while it is not ready for them. Messages no ready
+// consumer selects are kept in order.
+func (b *Broker) dispatch(q *queue) {
+	if 0 == len(q.messages) {
+		return
+	}
+	now := time.Now()
+	pending := q.messages
+	q.messages = nil
+
+	// blocked holds the groups whose messages must wait behind one
+	// kept in the queue.
+	var blocked map[string]bool
+
+	for i, m := range pending {
+		if m.expired(now) {
+			b.deadLetter(m, ReasonExpired)
+			continue
+		}
+		group, grouped := m.header.Get(HdrMessageGroup)
+		grouped = grouped && "" != group
+		owner := q.groups[group]
+		var sub *subscription
+		ready := true
+
+		switch {
+		case !grouped:
+			sub, ready = q.nextConsumer(m)
+		case blocked[group]:
+		case nil != owner && !owner.selects(m):
+			// The owner never selects the message, so the group moves
+			// to a consumer that does.
+			sub, ready = q.groupConsumer(m)
+		case nil != owner:
+			if owner.ready() {
+				sub = owner
+			}
+		default:
+			sub, ready = q.groupConsumer(m)
+		}
+
+		if nil != sub {
+			first := grouped && sub != owner
+
+			if first {
+				q.closeGroup(group)
+				q.groups[group] = sub
+				sub.groups++
+			}
+			b.deliver(sub, m, first)
+
+			if seq, _ := m.header.Get(HdrMessageGroupSeq); grouped && "-1" == seq {
+				q.closeGroup(group)
+			}
+			continue
+		}
+
+		if grouped {
+			if nil == blocked {
+				blocked = make(map[string]bool)
+			}
+			blocked[group] = true
+		}
+		q.messages = append(q.messages, m)
+
+		if !ready {
+			q.messages = append(q.messages, pending[i+1:]...)
+			break
+		}
+	}
+}
+
+// nextConsumer returns the next of q's consumers in turn that is
+// ready for a message and selects m, or nil if none is. It reports
+// whether any consumer is ready.
+func (q *queue) nextConsumer(m *message) (*subscription, bool) {
+	ready := false
+
+	for i := range q.consumers {
+		sub := q.consumers[(q.next+i)%len(q.consumers)]
+
+		if !sub.ready() {
+			continue
+		}
+		ready = true
+
+		if sub.selects(m) {
+			q.next += i + 1
+			return sub, true
+		}
+	}
+	return nil, ready
+}
+
+// groupConsumer returns the consumer of q that is ready for a message
+// and selects m owning the fewest groups, the next in turn among
+// equals, or nil if none is. It reports whether any consumer is ready.
+func (q *queue) groupConsumer(m *message) (*subscription, bool) {
+	var best *subscription
+	ready := false
+	skipped := 0
+
+	for i := range q.consumers {
+		sub := q.consumers[(q.next+i)%len(q.consumers)]
+
+		if !sub.ready() {
+			continue
+		}
+		ready = true
+
+		if sub.selects(m) && (nil == best || sub.groups < best.groups) {
+			best, skipped = sub, i
+		}
+	}
+
+	if nil != best {
+		q.next += skipped + 1
+	}
+	return best, ready
+}
+
+// closeGroup ends the ownership of a group, if it has an owner.
+func (q *queue) closeGroup(group string) {
+	if owner, ok := q.groups[group]; ok {
+		owner.groups--
+		delete(q.groups, group)
+	}
+}
+
+// deliver queues a MESSAGE frame carrying m for sub's session. With
+// first, the frame is marked as the first of its group delivered to
+// sub.
+func (b *Broker) deliver(sub *subscription, m *message, first bool) {
+	d := &delivery{msg: m, sub: sub}
+	var written *delivery
+	m.deliveries++
+
+	if stomp.AckAuto != sub.ack {
+		d.ackID = b.newID("ack-")
+		sub.unacked = append(sub.unacked, d)
+	} else if isQueue(m.destination) {
+		// The message is requeued if the session ends before its
+		// frame is written.
+		sub.unacked = append(sub.unacked, d)
+		written = d
+	}
+	sub.sess.out.push(m.frame(sub, d.ackID, first), written)
+}
+
+// written settles d, a queue message delivered in auto ack mode, once
+// its frame has been written. A delivery requeued in the meantime is
+// left to be delivered again.
+func (b *Broker) written(d *delivery) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, u := range d.sub.unacked {
+		if u == d {
+			d.sub.unacked = append(d.sub.unacked[:i:i], d.sub.unacked[i+1:]...)
+
+			// A failure to record the delivery leaves the message to
+			// be delivered again after a restart.
+			b.persist(nil, []*message{d.msg})
+			return
+		}
+	}
+}
+
+// requeue returns the messages of unacknowledged deliveries to the
+// front of their queue, in their original order, unless they expired
+// or reached the maximum number of deliveries. The messages of topic
+// deliveries are discarded.
+func (b *Broker) requeue(deliveries []*delivery) {
+	touched := make(map[*queue]bool)
+	now := time.Now()
+	var dead []*delivery
+
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		d := deliveries[i]
+
+		if !isQueue(d.msg.destination) {
+			continue
+		}
+
+		if d.msg.expired(now) || (b.opts.MaxDeliveries > 0 && d.msg.deliveries >= b.opts.MaxDeliveries) {
+			dead = append(dead, d)
+			continue
+		}
+		q := b.queue(d.msg.destination)
+		m := *d.msg
+		m.redelivered = true
+		q.messages = append([]*message{&m}, q.messages...)
+		touched[q] = true
+	}
+
+	for i := len(dead) - 1; i >= 0; i-- {
+		if dead[i].msg.expired(now) {
+			b.deadLetter(dead[i].msg, ReasonExpired)
+		} else {
+			b.deadLetter(dead[i].msg, ReasonMaxDeliveries)
+		}
+	}
+
+	for q := range touched {
+		b.dispatch(q)
+	}
+}
+
+// deadLetter takes m out of its queue, sending it to the dead-letter
+// destination with the given reason.
+func (b *Broker) deadLetter(m *message, reason string) {
+	var dead []*message
+
+	// Messages are not dead-lettered twice.
+	if "" != b.opts.DeadLetter && b.opts.DeadLetter != m.destination {
+		header := make(stomp.Header, len(m.header)+3)
+
+		for k, v := range m.header {
+			header[k] = append([]string(nil), v...)
+		}
+
+		if expires, ok := header.Get(HdrExpires); ok {
+			header.Del(HdrExpires)
+			header.Set(HdrOriginalExpires, expires)
+		}
+		header.Set(HdrOriginalDestination, m.destination)
+		header.Set(HdrDeadLetterReason, reason)
+		dead = append(dead, &message{
+			id:          b.newID("message-"),
+			destination: b.opts.DeadLetter,
+			header:      header,
+			body:        m.body,
+		})
+	}
+
+	// A failure to record the move leaves the message in its queue
+	// after a restart.
+	b.persist(dead, []*message{m})
+
+	if nil == b.batch {
+		for _, d := range dead {
+			b.publish(d)
+		}
+	}
+}
+
+// sweep starts looking for expired messages waiting in the broker's
+// queues, unless it already does.
+func (b *Broker) sweep() {
+	if b.sweeping {
+		return
+	}
+	b.sweeping = true
+
+	go func() {
+		ticker := time.NewTicker(b.opts.ExpiryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-b.done:
+				return
+			case <-ticker.C:
+			}
+			b.mu.Lock()
+			b.expire()
+			b.mu.Unlock()
+		}
+	}()
+}
+
+// expire dead-letters the expired messages waiting in the broker's
+// queues.
+func (b *Broker) expire() {
+	now := time.Now()
+	var expired []*message
+
+	for _, q := range b.queues {
+		kept := q.messages[:0]
+
+		for _, m := range q.messages {
+			if m.expired(now) {
+				expired = append(expired, m)
+			} else {
+				kept = append(kept, m)
+			}
+		}
+
+		for i := len(kept); i < len(q.messages); i++ {
+			q.messages[i] = nil
+		}
+		q.messages = kept
+	}
+
+	for _, m := range expired {
+		b.deadLetter(m, ReasonExpired)
+	}
+}
+
+func (b *Broker) subscribe(sess *session, f *stomp.Frame) error {
+	destination, _ := f.Header.Get(stomp.HdrDestination)
+
+	if "" == destination {
+		return fmt.Errorf("missing %s header", stomp.HdrDestination)
+	}
+	id, _ := f.Header.Get(stomp.HdrId)
+
+	if "" == id {
+		if "1.0" != sess.s.Version() {
+			return fmt.Errorf("missing %s header", stomp.HdrId)
+		}
+		id = destination
+	}
+
+	if _, exists := sess.subs[id]; exists {
+		return fmt.Errorf("subscription %q already exists", id)
+	}
+	ack, ok := f.Header.Get(stomp.HdrAck)
+
+	if !ok {
+		ack = stomp.AckAuto
+	}
+
+	switch ack {
+	case stomp.AckAuto, stomp.AckClient:
+	case stomp.AckClientIndividual:
+		if "1.0" == sess.s.Version() {
+			return fmt.Errorf("ack mode %q requires STOMP 1.1", ack)
+		}
+	default:
+		return fmt.Errorf("unknown ack mode %q", ack)
+	}
+	sub := &subscription{sess: sess, id: id, destination: destination, ack: ack}
+
+	if src, ok := f.Header.Get(selector.HdrSelector); ok && "" != src {
+		sel, parseErr := selector.Parse(src)
+
+		if nil != parseErr {
+			return parseErr
+		}
+		sub.selector = sel
+	}
+
+	if stomp.AckAuto != ack {
+		sub.prefetch = b.opts.Prefetch
+
+		for _, name := range []string{stomp.HdrPrefetchCount, HdrActiveMQPrefetchSize} {
+			v, ok := f.Header.Get(name)
+
+			if !ok {
+				continue
+			}
+			prefetch, atoiErr := strconv.Atoi(v)
+
+			if nil != atoiErr || prefetch < 0 {
+				return fmt.Errorf("invalid %s header %q", name, v)
+			}
+			sub.prefetch = prefetch
+			break
+		}
+	}
+	sess.subs[id] = sub
+
+	if isQueue(destination) {
+		q := b.queue(destination)
+		q.consumers = append(q.consumers, sub)
+		b.dispatch(q)
+		return nil
+	}
+	b.topics[destination] = append(b.topics[destination], sub)
+	return nil
+}
+
+// unsubscribe ends sub, returning its unacknowledged messages. The
+// groups sub owned are given to other consumers with their next
+// messages.
+func (b *Broker) unsubscribe(sub *subscription) {
+	delete(sub.sess.subs, sub.id)
+
+	if isQueue(sub.destination) {
+		q := b.queue(sub.destination)
+		q.consumers = removeSubscription(q.consumers, sub)
+
+		for group, owner := range q.groups {
+			if owner == sub {
+				q.closeGroup(group)
+			}
+		}
+	} else {
+		subs := removeSubscription(b.topics[sub.destination], sub)
+
+		if 0 == len(subs) {
+			delete(b.topics, sub.destination)
+		} else {
+			b.topics[sub.destination] = subs
+		}
+	}
+	unacked := sub.unacked
+	sub.unacked = nil
+	b.requeue(unacked)
+}
+
+func removeSubscription(subs []*subscription, sub *subscription) []*subscription {
+	for i, s := range subs {
+		if s == sub {
+			return append(subs[:i:i], subs[i+1:]...)
+		}
+	}
+	return subs
+}
+
+// findDelivery finds the delivery an ACK or NACK frame refers to: by
+// its id header in STOMP 1.2, and otherwise by its message-id and,
+// when given, subscription headers.
+func (sess *session) findDelivery(f *stomp.Frame) (*delivery, int, error) {
+	if id, ok := f.Header.Get(stomp.HdrId); ok {
+		for _, sub := range sess.subs {
+			if stomp.AckAuto == sub.ack {
+				continue
+			}
+
+			for i, d := range sub.unacked {
+				if d.ackID == id {
+					return d, i, nil
+				}
+			}
+		}
+		return nil, 0, fmt.Errorf("no unacknowledged message with ack id %q", id)
+	}
+	messageID, ok := f.Header.Get(stomp.HdrMessageId)
+
+	if !ok {
+		return nil, 0, fmt.Errorf("missing %s header", stomp.HdrId)
+	}
+	subID, bySub := f.Header.Get(stomp.HdrSubscription)
+
+	for _, sub := range sess.subs {
+		if stomp.AckAuto == sub.ack || (bySub && sub.id != subID) {
+			continue
+		}
+
+		for i, d := range sub.unacked {
+			if d.msg.id == messageID {
+				return d, i, nil
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("no unacknowledged message with message-id %q", messageID)
+}
+
+// ack acknowledges, or with nack rejects, the delivery f refers to,
+// and in client ack mode every earlier delivery of its subscription.
+func (b *Broker) ack(sess *session, f *stomp.Frame, nack bool) error {
+	d, ndx, findErr := sess.findDelivery(f)
+
+	if nil != findErr {
+		return findErr
+	}
+	sub := d.sub
+	done := []*delivery{d}
+
+	if stomp.AckClient == sub.ack {
+		done = append(done[:0], sub.unacked[:ndx+1]...)
+	}
+
+	if !nack {
+		acked := make([]*message, len(done))
+
+		for i, d := range done {
+			acked[i] = d.msg
+		}
+
+		if persistErr := b.persist(nil, acked); nil != persistErr {
+			return persistErr
+		}
+	}
+
+	if stomp.AckClient == sub.ack {
+		sub.unacked = append(sub.unacked[:0:0], sub.unacked[ndx+1:]...)
+	} else {
+		sub.unacked = append(sub.unacked[:ndx:ndx], sub.unacked[ndx+1:]...)
+	}
+
+	if nack {
+		b.requeue(done)
+	}
+
+	// The acknowledgement may open the subscription's prefetch window.
+	if isQueue(sub.destination) {
+		b.dispatch(b.queue(sub.destination))
+	}
+	return nil
+}
+
+// enlist adds a SEND, ACK or NACK frame to a transaction.
+func (b *Broker) enlist(sess *session, txID string, f *stomp.Frame) error {
+	tx, ok := sess.txs[txID]
+
+	if !ok {
+		return fmt.Errorf("no transaction with id %q", txID)
+	}
+
+	// A frame that could not be applied is refused now, for a
+	// transaction must commit whole.
+	if stomp.CmdSend == f.Command {
+		if checkErr := checkSend(f.Header); nil != checkErr {
+			return checkErr
+		}
+	} else if _, _, findErr := sess.findDelivery(f); nil != findErr {
+		return findErr
+	}
+	tx.frames = append(tx.frames, f)
+	return nil
+}
+
+func (b *Broker) transact(sess *session, f *stomp.Frame) error {
+	txID, _ := f.Header.Get(stomp.HdrTransaction)
+
+	if "" == txID {
+		return fmt.Errorf("missing %s header", stomp.HdrTransaction)
+	}
+	tx, exists := sess.txs[txID]
+
+	if stomp.CmdBegin == f.Command {
+		if exists {
+			return fmt.Errorf("transaction %q already exists", txID)
+		}
+		sess.txs[txID] = &transaction{}
+		return nil
+	}
+
+	if !exists {
+		return fmt.Errorf("no transaction with id %q", txID)
+	}
+	delete(sess.txs, txID)
+
+	if stomp.CmdAbort == f.Command {
+		return nil
+	}
+
+	b.batch = &batch{}
+
+	for _, enlisted := range tx.frames {
+		applyErr := b.apply(sess, enlisted)
+
+		// A message acknowledged outside of the transaction
+		// since it was enlisted has nothing left to settle.
+		if nil != applyErr && stomp.CmdSend == enlisted.Command {
+			b.batch = nil
+			return applyErr
+		}
+	}
+	committed := b.batch
+	b.batch = nil
+
+	if persistErr := b.persist(committed.sent, committed.acked); nil != persistErr {
+		return persistErr
+	}
+
+	for _, m := range committed.sent {
+		b.publish(m)
+	}
+	return nil
+}
+
+// An outbox delivers frames to a session in order, without blocking
+// the broker on a slow client.
+type outbox struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	frames  []outgoing
+	closed  bool
+	stopped chan struct{}
+
+	// written is called with the delivery of each frame pushed with
+	// one, once the frame is written.
+	written func(d *delivery)
+}
+
+// An outgoing frame is a frame in an outbox, with the delivery to
+// settle once it is written, if any.
+type outgoing struct {
+	frame *stomp.Frame
+	d     *delivery
+}
+
+func newOutbox(written func(d *delivery)) *outbox {
+	o := &outbox{written: written, stopped: make(chan struct{})}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}
+
+func (o *outbox) push(f *stomp.Frame, d *delivery) {
+	o.mu.Lock()
+
+	if !o.closed {
+		o.frames = append(o.frames, outgoing{f, d})
+		o.cond.Signal()
+	}
+	o.mu.Unlock()
+}
+
+func (o *outbox) close() {
+	o.mu.Lock()
+	o.closed = true
+	o.frames = nil
+	o.cond.Signal()
+	o.mu.Unlock()
+}
+
+// wait waits for run to return.
+func (o *outbox) wait() {
+	<-o.stopped
+}
+
+// run sends the outbox's frames to s until the outbox is closed.
+func (o *outbox) run(s *stomp.Session) {
+	defer close(o.stopped)
+
+	for {
+		o.mu.Lock()
+
+		for 0 == len(o.frames) && !o.closed {
+			o.cond.Wait()
+		}
+
+		if o.closed {
+			o.mu.Unlock()
+			return
+		}
+		out := o.frames[0]
+		o.frames[0] = outgoing{}
+		o.frames = o.frames[1:]
+		o.mu.Unlock()
+
+		if sendErr := s.Send(context.Background(), out.frame); nil != sendErr {
+			o.close()
+			return
+		}
+
+		if nil != out.d {
+			o.written(out.d)
+		}
+	}
+}
@@ -0,0 +1,632 @@
+package broker
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjware/stomp"
+	"github.com/jjware/stomp/broker/store"
+	"github.com/jjware/stomp/selector"
+	"github.com/jjware/stomp/stomptest"
+)
+
+func TestConformance(t *testing.T) {
+	srv := &stomp.Server{Handler: New(), HeartBeat: 100 * time.Millisecond}
+	defer srv.Close()
+
+	stomptest.RunConformance(t, func() (io.ReadWriteCloser, error) {
+		client, server := net.Pipe()
+		go srv.ServeConn(server)
+		return client, nil
+	})
+}
+
+func TestTopic(t *testing.T) {
+	srv := &stomp.Server{Handler: New()}
+	defer srv.Close()
+
+	connect := func() *stomptest.Conn {
+		client, server := net.Pipe()
+		go srv.ServeConn(server)
+		c := stomptest.NewConn(t, client, 5*time.Second)
+		f := stomp.NewFrame(stomp.CmdConnect, nil)
+		f.Header.Set(stomp.HdrAcceptVersion, "1.2")
+		c.Send(f)
+		c.Expect(stomp.CmdConnected)
+		return c
+	}
+
+	var subscribers []*stomptest.Conn
+
+	for i := 0; i < 2; i++ {
+		c := connect()
+		defer c.Close()
+		f := stomp.NewFrame(stomp.CmdSubscribe, nil)
+		f.Header.Set(stomp.HdrDestination, "/topic/news")
+		f.Header.Set(stomp.HdrId, "0")
+		f.Header.Set(stomp.HdrReceipt, "subscribed")
+		c.Send(f)
+		c.ExpectReceipt("subscribed")
+		subscribers = append(subscribers, c)
+	}
+	publisher := connect()
+	defer publisher.Close()
+	f := stomp.NewFrame(stomp.CmdSend, nil)
+	f.Header.Set(stomp.HdrDestination, "/topic/news")
+	f.Header.Set(stomp.HdrReceipt, "sent")
+	publisher.Send(f)
+	publisher.ExpectReceipt("sent")
+
+	for _, c := range subscribers {
+		msg := c.Expect(stomp.CmdMessage)
+
+		if v, _ := msg.Header.Get(stomp.HdrDestination); "/topic/news" != v {
+			t.Errorf("destination is %q", v)
+		}
+	}
+
+	// A topic keeps no messages for later subscribers.
+	late := connect()
+	defer late.Close()
+	sub := stomp.NewFrame(stomp.CmdSubscribe, nil)
+	sub.Header.Set(stomp.HdrDestination, "/topic/news")
+	sub.Header.Set(stomp.HdrId, "0")
+	late.Send(sub)
+	late.ExpectNothing(200 * time.Millisecond)
+}
+
+// connect opens a STOMP 1.2 session with srv.
+func connect(t *testing.T, srv *stomp.Server) *stomptest.Conn {
+	client, server := net.Pipe()
+	go srv.ServeConn(server)
+	c := stomptest.NewConn(t, client, 5*time.Second)
+	f := stomp.NewFrame(stomp.CmdConnect, nil)
+	f.Header.Set(stomp.HdrAcceptVersion, "1.2")
+	c.Send(f)
+	c.Expect(stomp.CmdConnected)
+	return c
+}
+
+// send sends a frame with the given headers and body, and waits for
+// its receipt.
+func send(c *stomptest.Conn, cmd stomp.Command, body string, header ...string) {
+	f := stomp.NewFrame(cmd, strings.NewReader(body))
+
+	for i := 0; i < len(header); i += 2 {
+		f.Header.Set(header[i], header[i+1])
+	}
+	f.Header.Set(stomp.HdrReceipt, "r")
+	c.Send(f)
+	c.ExpectReceipt("r")
+}
+
+// expectBodies receives a MESSAGE frame for each of bodies, in order,
+// returning their ack headers.
+func expectBodies(t *testing.T, c *stomptest.Conn, bodies ...string) []string {
+	t.Helper()
+	var acks []string
+
+	for _, want := range bodies {
+		msg := c.Expect(stomp.CmdMessage)
+		body, _ := ioutil.ReadAll(msg.Body)
+
+		if want != string(body) {
+			t.Fatalf("received %q, expected %q", body, want)
+		}
+		ack, _ := msg.Header.Get(stomp.HdrAck)
+		acks = append(acks, ack)
+	}
+	return acks
+}
+
+func TestStore(t *testing.T) {
+	dir, tempErr := ioutil.TempDir("", "broker")
+
+	if nil != tempErr {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(dir)
+
+	start := func() (*Broker, *stomp.Server) {
+		log, openErr := store.OpenLog(dir, store.LogOptions{})
+
+		if nil != openErr {
+			t.Fatal(openErr)
+		}
+		b, openErr := Open(Options{Store: log})
+
+		if nil != openErr {
+			t.Fatal(openErr)
+		}
+		return b, &stomp.Server{Handler: b}
+	}
+	b, srv := start()
+	c := connect(t, srv)
+
+	for _, body := range []string{"1", "2", "3"} {
+		send(c, stomp.CmdSend, body, stomp.HdrDestination, "/queue/jobs")
+	}
+	send(c, stomp.CmdSend, "news", stomp.HdrDestination, "/topic/news")
+	send(c, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/jobs", stomp.HdrId, "0", stomp.HdrAck, stomp.AckClientIndividual)
+	acks := expectBodies(t, c, "1", "2", "3")
+	send(c, stomp.CmdAck, "", stomp.HdrId, acks[0])
+
+	// The transaction is in flight when the broker stops.
+	send(c, stomp.CmdBegin, "", stomp.HdrTransaction, "tx")
+	send(c, stomp.CmdAck, "", stomp.HdrId, acks[1], stomp.HdrTransaction, "tx")
+	send(c, stomp.CmdSend, "4", stomp.HdrDestination, "/queue/jobs", stomp.HdrTransaction, "tx")
+	c.Close()
+	srv.Close()
+	b.Close()
+
+	b, srv = start()
+	c = connect(t, srv)
+	send(c, stomp.CmdBegin, "", stomp.HdrTransaction, "tx")
+	send(c, stomp.CmdSend, "5", stomp.HdrDestination, "/queue/jobs", stomp.HdrTransaction, "tx")
+	send(c, stomp.CmdCommit, "", stomp.HdrTransaction, "tx")
+	send(c, stomp.CmdSend, "6", stomp.HdrDestination, "/queue/other")
+	send(c, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/jobs", stomp.HdrId, "0")
+	expectBodies(t, c, "2", "3", "5")
+	c.Close()
+	srv.Close()
+	b.Close()
+
+	// Messages delivered in auto ack mode are acknowledged.
+	b, srv = start()
+	defer b.Close()
+	defer srv.Close()
+	c = connect(t, srv)
+	defer c.Close()
+	send(c, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/jobs", stomp.HdrId, "0")
+	send(c, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/other", stomp.HdrId, "1")
+	msg := c.Expect(stomp.CmdMessage)
+
+	// Without recovering the last id, the message would reuse that
+	// of a recovered one.
+	if id, _ := msg.Header.Get(stomp.HdrMessageId); "message-2" == id || "message-3" == id {
+		t.Errorf("message id %s reused", id)
+	}
+	c.ExpectNothing(100 * time.Millisecond)
+}
+
+func TestTransaction(t *testing.T) {
+	srv := &stomp.Server{Handler: New()}
+	defer srv.Close()
+	c := connect(t, srv)
+	defer c.Close()
+	send(c, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/jobs", stomp.HdrId, "0", stomp.HdrAck, stomp.AckClientIndividual)
+	send(c, stomp.CmdSend, "1", stomp.HdrDestination, "/queue/jobs")
+	acks := expectBodies(t, c, "1")
+
+	// A SEND that could not be committed is refused when it is
+	// enlisted, and the transaction comes to nothing.
+	send(c, stomp.CmdBegin, "", stomp.HdrTransaction, "tx")
+	send(c, stomp.CmdSend, "2", stomp.HdrDestination, "/queue/jobs", stomp.HdrTransaction, "tx")
+	send(c, stomp.CmdAck, "", stomp.HdrId, acks[0], stomp.HdrTransaction, "tx")
+	f := stomp.NewFrame(stomp.CmdSend, nil)
+	f.Header.Set(stomp.HdrDestination, "/queue/jobs")
+	f.Header.Set(HdrExpires, "soon")
+	f.Header.Set(stomp.HdrTransaction, "tx")
+	c.Send(f)
+	c.ExpectError()
+
+	other := connect(t, srv)
+	defer other.Close()
+	send(other, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/jobs", stomp.HdrId, "0")
+	expectBodies(t, other, "1")
+	other.ExpectNothing(100 * time.Millisecond)
+}
+
+func TestDeadLetter(t *testing.T) {
+	b, _ := Open(Options{
+		TTL:            map[string]time.Duration{"/queue/short.>": 50 * time.Millisecond, "/queue/>": time.Hour},
+		MaxDeliveries:  2,
+		DeadLetter:     "/queue/DLQ",
+		ExpiryInterval: 10 * time.Millisecond,
+	})
+	defer b.Close()
+	srv := &stomp.Server{Handler: b}
+	defer srv.Close()
+	dlq := connect(t, srv)
+	defer dlq.Close()
+	send(dlq, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/DLQ", stomp.HdrId, "0")
+	c := connect(t, srv)
+	defer c.Close()
+
+	expectDead := func(body, destination, reason string) *stomp.Frame {
+		t.Helper()
+		msg := dlq.Expect(stomp.CmdMessage)
+		got, _ := ioutil.ReadAll(msg.Body)
+
+		if body != string(got) {
+			t.Fatalf("dead-lettered %q, expected %q", got, body)
+		}
+
+		if v, _ := msg.Header.Get(HdrOriginalDestination); destination != v {
+			t.Errorf("%s = %q", HdrOriginalDestination, v)
+		}
+
+		if v, _ := msg.Header.Get(HdrDeadLetterReason); reason != v {
+			t.Errorf("%s = %q", HdrDeadLetterReason, v)
+		}
+
+		if v, _ := msg.Header.Get("x-kept"); "yes" != v {
+			t.Errorf("x-kept = %q", v)
+		}
+
+		if _, ok := msg.Header.Get(HdrExpires); ok {
+			t.Errorf("dead-lettered message expires")
+		}
+		return msg
+	}
+
+	send(c, stomp.CmdSend, "past", stomp.HdrDestination, "/queue/jobs", HdrExpires, "1", "x-kept", "yes")
+	msg := expectDead("past", "/queue/jobs", ReasonExpired)
+
+	if v, _ := msg.Header.Get(HdrOriginalExpires); "1" != v {
+		t.Errorf("%s = %q", HdrOriginalExpires, v)
+	}
+	send(c, stomp.CmdSend, "ttl", stomp.HdrDestination, "/queue/short.a", "x-kept", "yes")
+	send(c, stomp.CmdSend, "never", stomp.HdrDestination, "/queue/short.a", HdrExpires, "0", "x-kept", "yes")
+	expectDead("ttl", "/queue/short.a", ReasonExpired)
+
+	// A message is dead-lettered after its last allowed delivery.
+	send(c, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/poison", stomp.HdrId, "0", stomp.HdrAck, stomp.AckClientIndividual)
+	send(c, stomp.CmdSend, "poison", stomp.HdrDestination, "/queue/poison", "x-kept", "yes")
+
+	for i := 1; i <= 2; i++ {
+		msg := c.Expect(stomp.CmdMessage)
+
+		if v, _ := msg.Header.Get(HdrDeliveryCount); strconv.Itoa(i) != v {
+			t.Errorf("%s = %q on delivery %d", HdrDeliveryCount, v, i)
+		}
+
+		if v, _ := msg.Header.Get(HdrExpires); "" == v {
+			t.Errorf("no default time to live")
+		}
+		ack, _ := msg.Header.Get(stomp.HdrAck)
+		send(c, stomp.CmdNack, "", stomp.HdrId, ack)
+	}
+	expectDead("poison", "/queue/poison", ReasonMaxDeliveries)
+	c.ExpectNothing(50 * time.Millisecond)
+	dlq.ExpectNothing(50 * time.Millisecond)
+
+	f := stomp.NewFrame(stomp.CmdSend, nil)
+	f.Header.Set(stomp.HdrDestination, "/queue/jobs")
+	f.Header.Set(HdrExpires, "soon")
+	c.Send(f)
+	c.ExpectError()
+}
+
+func TestPrefetch(t *testing.T) {
+	srv := &stomp.Server{Handler: New()}
+	defer srv.Close()
+	slow := connect(t, srv)
+	defer slow.Close()
+	fast := connect(t, srv)
+	defer fast.Close()
+	send(slow, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/work", stomp.HdrId, "0", stomp.HdrAck, stomp.AckClientIndividual, stomp.HdrPrefetchCount, "1")
+	send(fast, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/work", stomp.HdrId, "0", stomp.HdrAck, stomp.AckClient, HdrActiveMQPrefetchSize, "2")
+
+	for _, body := range []string{"1", "2", "3", "4", "5"} {
+		send(slow, stomp.CmdSend, body, stomp.HdrDestination, "/queue/work")
+	}
+	slowAcks := expectBodies(t, slow, "1")
+	fastAcks := expectBodies(t, fast, "2", "3")
+	slow.ExpectNothing(50 * time.Millisecond)
+	fast.ExpectNothing(0)
+
+	// A cumulative acknowledgement frees the whole window.
+	send(fast, stomp.CmdAck, "", stomp.HdrId, fastAcks[1])
+	expectBodies(t, fast, "4", "5")
+	send(slow, stomp.CmdAck, "", stomp.HdrId, slowAcks[0])
+	slow.ExpectNothing(50 * time.Millisecond)
+
+	f := stomp.NewFrame(stomp.CmdSubscribe, nil)
+	f.Header.Set(stomp.HdrDestination, "/queue/work")
+	f.Header.Set(stomp.HdrId, "1")
+	f.Header.Set(stomp.HdrAck, stomp.AckClient)
+	f.Header.Set(stomp.HdrPrefetchCount, "many")
+	slow.Send(f)
+	slow.ExpectError()
+}
+
+func TestSelector(t *testing.T) {
+	srv := &stomp.Server{Handler: New()}
+	defer srv.Close()
+	eu := connect(t, srv)
+	defer eu.Close()
+	us := connect(t, srv)
+	defer us.Close()
+	send(eu, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/orders", stomp.HdrId, "0", selector.HdrSelector, "region = 'eu'")
+	send(us, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/orders", stomp.HdrId, "0", selector.HdrSelector, "region = 'us'")
+	send(us, stomp.CmdSubscribe, "", stomp.HdrDestination, "/topic/prices", stomp.HdrId, "1", selector.HdrSelector, "price > 10")
+
+	for _, region := range []string{"eu", "us", "asia", "eu"} {
+		send(eu, stomp.CmdSend, region, stomp.HdrDestination, "/queue/orders", "region", region)
+	}
+
+	for _, price := range []string{"5", "15", "none"} {
+		send(eu, stomp.CmdSend, price, stomp.HdrDestination, "/topic/prices", "price", price)
+	}
+	expectBodies(t, eu, "eu", "eu")
+	expectBodies(t, us, "us", "15")
+	eu.ExpectNothing(50 * time.Millisecond)
+	us.ExpectNothing(0)
+
+	// A message no consumer selects waits for one that does.
+	any := connect(t, srv)
+	defer any.Close()
+	send(any, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/orders", stomp.HdrId, "0")
+	expectBodies(t, any, "asia")
+
+	f := stomp.NewFrame(stomp.CmdSubscribe, nil)
+	f.Header.Set(stomp.HdrDestination, "/queue/orders")
+	f.Header.Set(stomp.HdrId, "1")
+	f.Header.Set(selector.HdrSelector, "region = ")
+	any.Send(f)
+	any.ExpectError()
+}
+
+func TestSchedule(t *testing.T) {
+	memory := store.NewMemory()
+	b, openErr := Open(Options{Store: memory, ScheduleTick: 10 * time.Millisecond})
+
+	if nil != openErr {
+		t.Fatal(openErr)
+	}
+	srv := &stomp.Server{Handler: b}
+	c := connect(t, srv)
+	send(c, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/jobs", stomp.HdrId, "0")
+	at := time.Now().Add(100*time.Millisecond).UnixNano() / int64(time.Millisecond)
+	send(c, stomp.CmdSend, "later", stomp.HdrDestination, "/queue/jobs", stomp.HdrDelay, "300")
+	send(c, stomp.CmdSend, "at", stomp.HdrDestination, "/queue/jobs", stomp.HdrDeliverAt, strconv.FormatInt(at, 10))
+	send(c, stomp.CmdSend, "now", stomp.HdrDestination, "/queue/jobs", stomp.HdrDelay, "0")
+
+	if n := b.Scheduled()["/queue/jobs"]; 2 != n {
+		t.Errorf("%d messages scheduled, expected 2", n)
+	}
+	expectBodies(t, c, "now", "at")
+	msg := c.Expect(stomp.CmdMessage)
+
+	if _, ok := msg.Header.Get(stomp.HdrDelay); ok {
+		t.Error("delay header delivered")
+	}
+
+	if _, ok := msg.Header.Get(stomp.HdrDeliverAt); !ok {
+		t.Error("deliver-at header missing")
+	}
+
+	if 0 != len(b.Scheduled()) {
+		t.Errorf("scheduled messages left: %v", b.Scheduled())
+	}
+
+	// Scheduled queue messages survive a restart.
+	send(c, stomp.CmdSend, "restarted", stomp.HdrDestination, "/queue/jobs", stomp.HdrDelay, "200")
+	c.Close()
+	srv.Close()
+	b.Close()
+
+	b, openErr = Open(Options{Store: memory, ScheduleTick: 10 * time.Millisecond})
+
+	if nil != openErr {
+		t.Fatal(openErr)
+	}
+	defer b.Close()
+	srv = &stomp.Server{Handler: b}
+	defer srv.Close()
+
+	if n := b.Scheduled()["/queue/jobs"]; 1 != n {
+		t.Errorf("%d messages scheduled after restart, expected 1", n)
+	}
+	c = connect(t, srv)
+	defer c.Close()
+	send(c, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/jobs", stomp.HdrId, "0")
+	c.ExpectNothing(50 * time.Millisecond)
+	expectBodies(t, c, "restarted")
+
+	f := stomp.NewFrame(stomp.CmdSend, nil)
+	f.Header.Set(stomp.HdrDestination, "/queue/jobs")
+	f.Header.Set(stomp.HdrDelay, "soon")
+	c.Send(f)
+	c.ExpectError()
+}
+
+func TestWheel(t *testing.T) {
+	tick := time.Millisecond
+	start := time.Unix(0, 0)
+	w := newWheel(tick, start)
+	var sent []*message
+
+	// Due times span several revolutions of the wheel.
+	for _, d := range []time.Duration{3 * wheelSlots, 5, wheelSlots + 5, 5, 0} {
+		m := &message{id: strconv.Itoa(len(sent)), destination: "/queue/q", due: start.Add(d * tick)}
+		w.add(m)
+		sent = append(sent, m)
+	}
+	var got []string
+
+	for now := start; now.Before(start.Add(4 * wheelSlots * tick)); now = now.Add(7 * tick) {
+		for _, m := range w.advance(now) {
+			if now.Before(m.due) {
+				t.Errorf("message %s released early", m.id)
+			}
+
+			if now.Sub(m.due) >= 7*tick {
+				t.Errorf("message %s released late", m.id)
+			}
+			got = append(got, m.id)
+		}
+	}
+
+	if "4 1 3 2 0" != strings.Join(got, " ") {
+		t.Errorf("released %v", got)
+	}
+
+	if 0 != w.len || 0 != len(w.counts) {
+		t.Errorf("wheel holds %d messages, counts %v", w.len, w.counts)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	srv := &stomp.Server{Handler: New()}
+	defer srv.Close()
+	a := connect(t, srv)
+	b := connect(t, srv)
+	defer b.Close()
+
+	for _, c := range []*stomptest.Conn{a, b} {
+		send(c, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/orders", stomp.HdrId, "0", stomp.HdrAck, stomp.AckClientIndividual)
+	}
+
+	for _, body := range []string{"x1", "y1", "x2", "y2", "x3"} {
+		send(b, stomp.CmdSend, body, stomp.HdrDestination, "/queue/orders", HdrMessageGroup, body[:1])
+	}
+
+	// expectFirst receives a message, checking its body and whether
+	// it is marked as the first of its group.
+	expectFirst := func(c *stomptest.Conn, body string, first bool) {
+		t.Helper()
+		msg := c.Expect(stomp.CmdMessage)
+		got, _ := ioutil.ReadAll(msg.Body)
+
+		if body != string(got) {
+			t.Fatalf("received %q, expected %q", got, body)
+		}
+
+		if _, marked := msg.Header.Get(HdrMessageGroupFirst); marked != first {
+			t.Errorf("%s: %s is %v, expected %v", body, HdrMessageGroupFirst, marked, first)
+		}
+	}
+	expectFirst(a, "x1", true)
+	expectFirst(a, "x2", false)
+	expectFirst(a, "x3", false)
+	expectFirst(b, "y1", true)
+	expectFirst(b, "y2", false)
+
+	// The groups of a consumer that leaves are given to another, with
+	// its unacknowledged messages in order.
+	a.Close()
+	expectFirst(b, "x1", true)
+	expectFirst(b, "x2", false)
+	expectFirst(b, "x3", false)
+
+	// A closed group is given to the consumer owning the fewest.
+	c := connect(t, srv)
+	defer c.Close()
+	send(c, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/orders", stomp.HdrId, "0", stomp.HdrAck, stomp.AckClientIndividual)
+	send(b, stomp.CmdSend, "y3", stomp.HdrDestination, "/queue/orders", HdrMessageGroup, "y", HdrMessageGroupSeq, "-1")
+	send(b, stomp.CmdSend, "y4", stomp.HdrDestination, "/queue/orders", HdrMessageGroup, "y")
+	send(b, stomp.CmdSend, "x4", stomp.HdrDestination, "/queue/orders", HdrMessageGroup, "x")
+	expectFirst(b, "y3", false)
+	expectFirst(b, "x4", false)
+	expectFirst(c, "y4", true)
+	c.ExpectNothing(50 * time.Millisecond)
+}
+
+func TestGroupSelector(t *testing.T) {
+	srv := &stomp.Server{Handler: New()}
+	defer srv.Close()
+	eu := connect(t, srv)
+	defer eu.Close()
+	us := connect(t, srv)
+	defer us.Close()
+	send(eu, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/orders", stomp.HdrId, "0", selector.HdrSelector, "region = 'eu'")
+	send(eu, stomp.CmdSend, "1", stomp.HdrDestination, "/queue/orders", HdrMessageGroup, "g", "region", "eu")
+	expectBodies(t, eu, "1")
+	send(us, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/orders", stomp.HdrId, "0", selector.HdrSelector, "region = 'us'")
+
+	// A message of the group its owner does not select moves the
+	// group to a consumer that does.
+	send(eu, stomp.CmdSend, "2", stomp.HdrDestination, "/queue/orders", HdrMessageGroup, "g", "region", "us")
+	send(eu, stomp.CmdSend, "3", stomp.HdrDestination, "/queue/orders", HdrMessageGroup, "g", "region", "us")
+
+	for i, want := range []string{"2", "3"} {
+		msg := us.Expect(stomp.CmdMessage)
+
+		if body, _ := ioutil.ReadAll(msg.Body); want != string(body) {
+			t.Fatalf("received %q, expected %q", body, want)
+		}
+
+		if _, first := msg.Header.Get(HdrMessageGroupFirst); first != (0 == i) {
+			t.Errorf("%s: %s is %v", want, HdrMessageGroupFirst, first)
+		}
+	}
+
+	// One no consumer selects waits in the queue, without a dead
+	// letter destination to take it.
+	send(eu, stomp.CmdSend, "4", stomp.HdrDestination, "/queue/orders", HdrMessageGroup, "g", "region", "asia")
+	eu.ExpectNothing(50 * time.Millisecond)
+	us.ExpectNothing(0)
+	any := connect(t, srv)
+	defer any.Close()
+	send(any, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/orders", stomp.HdrId, "0")
+	expectBodies(t, any, "4")
+}
+
+func TestAutoAckUnsent(t *testing.T) {
+	memory := store.NewMemory()
+	b, openErr := Open(Options{Store: memory})
+
+	if nil != openErr {
+		t.Fatal(openErr)
+	}
+	defer b.Close()
+	srv := &stomp.Server{Handler: b}
+	defer srv.Close()
+
+	// The consumer stops reading once subscribed, so the messages
+	// delivered to it wait in its outbox.
+	client, server := net.Pipe()
+	go srv.ServeConn(server)
+
+	for _, f := range []*stomp.Frame{stomp.NewFrame(stomp.CmdConnect, nil), stomp.NewFrame(stomp.CmdSubscribe, nil)} {
+		f.Header.Set(stomp.HdrAcceptVersion, "1.2")
+		f.Header.Set(stomp.HdrDestination, "/queue/jobs")
+		f.Header.Set(stomp.HdrId, "0")
+		f.Header.Set(stomp.HdrReceipt, "r")
+
+		if _, writeErr := f.WriteTo(client); nil != writeErr {
+			t.Fatal(writeErr)
+		}
+		reply, readErr := stomp.ReadFrame(client)
+
+		if nil != readErr {
+			t.Fatal(readErr)
+		}
+		reply.Body.Close()
+	}
+	producer := connect(t, srv)
+	defer producer.Close()
+
+	for _, body := range []string{"1", "2", "3"} {
+		send(producer, stomp.CmdSend, body, stomp.HdrDestination, "/queue/jobs")
+	}
+
+	if 3 != memory.Len() {
+		t.Errorf("%d messages stored before any was written", memory.Len())
+	}
+	client.Close()
+
+	// The unsent messages return to the queue, and are removed from
+	// the store once written to the next consumer.
+	c := connect(t, srv)
+	defer c.Close()
+	send(c, stomp.CmdSubscribe, "", stomp.HdrDestination, "/queue/jobs", stomp.HdrId, "0")
+	expectBodies(t, c, "1", "2", "3")
+	deadline := time.Now().Add(5 * time.Second)
+
+	for 0 != memory.Len() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if 0 != memory.Len() {
+		t.Errorf("%d messages stored after all were written", memory.Len())
+	}
+}
@@ -0,0 +1,204 @@
+package broker
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+// DefaultScheduleTick is the resolution at which a broker delivers
+// scheduled messages, unless Options sets another.
+const DefaultScheduleTick = 100 * time.Millisecond
+
+// wheelSlots is the number of slots of a timer wheel.
+const wheelSlots = 512
+
+// A wheel is a hashed timer wheel holding scheduled messages until
+// they are due. A message is kept in the slot of the first tick at or
+// after its due time, modulo the number of slots, so a slot holds the
+// messages of every revolution of the wheel that map to it.
+type wheel struct {
+	tick   time.Duration
+	slots  [wheelSlots][]*message
+	len    int
+	counts map[string]int
+
+	// current is the next tick to be advanced over.
+	current int64
+}
+
+func newWheel(tick time.Duration, now time.Time) *wheel {
+	w := &wheel{tick: tick, counts: make(map[string]int)}
+	w.current = now.UnixNano() / int64(tick)
+	return w
+}
+
+// add holds m until it is due.
+func (w *wheel) add(m *message) {
+	n := (m.due.UnixNano() + int64(w.tick) - 1) / int64(w.tick)
+
+	if n < w.current {
+		n = w.current
+	}
+	slot := &w.slots[n%wheelSlots]
+	*slot = append(*slot, m)
+	w.len++
+	w.counts[m.destination]++
+}
+
+// advance removes the messages due by now from the wheel, and returns
+// them in the order they are due.
+func (w *wheel) advance(now time.Time) []*message {
+	end := now.UnixNano() / int64(w.tick)
+	var due []*message
+
+	// One revolution visits every slot.
+	if end-w.current >= wheelSlots {
+		w.current = end - wheelSlots + 1
+	}
+
+	for ; w.current <= end; w.current++ {
+		slot := w.slots[w.current%wheelSlots]
+		kept := slot[:0]
+
+		for _, m := range slot {
+			if now.Before(m.due) {
+				kept = append(kept, m)
+				continue
+			}
+			due = append(due, m)
+			w.len--
+
+			if w.counts[m.destination]--; 0 == w.counts[m.destination] {
+				delete(w.counts, m.destination)
+			}
+		}
+
+		for i := len(kept); i < len(slot); i++ {
+			slot[i] = nil
+		}
+		w.slots[w.current%wheelSlots] = kept
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].due.Before(due[j].due)
+	})
+	return due
+}
+
+// parseDeliverAt returns the time of a header's deliver-at header, or
+// the zero time if it has none.
+func parseDeliverAt(header stomp.Header) (time.Time, error) {
+	v, ok := header.Get(stomp.HdrDeliverAt)
+
+	if !ok {
+		return time.Time{}, nil
+	}
+	ms, parseErr := strconv.ParseInt(v, 10, 64)
+
+	if nil != parseErr || ms < 0 {
+		return time.Time{}, fmt.Errorf("invalid %s header %q", stomp.HdrDeliverAt, v)
+	}
+
+	if 0 == ms {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+// parseDelay returns the delay header of a message, which must not
+// be given along with a deliver-at header.
+func parseDelay(header stomp.Header) (time.Duration, bool, error) {
+	v, ok := header.Get(stomp.HdrDelay)
+
+	if !ok {
+		return 0, false, nil
+	}
+
+	if _, conflict := header.Get(stomp.HdrDeliverAt); conflict {
+		return 0, false, fmt.Errorf("%s and %s headers are exclusive", stomp.HdrDelay, stomp.HdrDeliverAt)
+	}
+	ms, parseErr := strconv.ParseInt(v, 10, 64)
+
+	if nil != parseErr || ms < 0 {
+		return 0, false, fmt.Errorf("invalid %s header %q", stomp.HdrDelay, v)
+	}
+	return time.Duration(ms) * time.Millisecond, true, nil
+}
+
+// scheduleHeader replaces the delay header of a message sent at now
+// with the deliver-at header it stands for, so that the message keeps
+// its due time when it is recovered from a store, and returns that
+// time.
+func scheduleHeader(header stomp.Header, now time.Time) (time.Time, error) {
+	delay, ok, delayErr := parseDelay(header)
+
+	if nil != delayErr {
+		return time.Time{}, delayErr
+	}
+
+	if !ok {
+		return parseDeliverAt(header)
+	}
+	header.Del(stomp.HdrDelay)
+
+	if 0 == delay {
+		return time.Time{}, nil
+	}
+	due := now.Add(delay)
+	header.Set(stomp.HdrDeliverAt, strconv.FormatInt(due.UnixNano()/int64(time.Millisecond), 10))
+	return due, nil
+}
+
+// schedule holds m until it is due, starting the delivery of
+// scheduled messages unless the broker already delivers them.
+func (b *Broker) schedule(m *message) {
+	b.wheel.add(m)
+
+	if b.scheduling {
+		return
+	}
+	b.scheduling = true
+
+	go func() {
+		ticker := time.NewTicker(b.opts.ScheduleTick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-b.done:
+				return
+			case <-ticker.C:
+			}
+			b.mu.Lock()
+
+			for _, due := range b.wheel.advance(time.Now()) {
+				b.publish(due)
+			}
+
+			// Delivery starts again with the next scheduled message.
+			if 0 == b.wheel.len {
+				b.scheduling = false
+				b.mu.Unlock()
+				return
+			}
+			b.mu.Unlock()
+		}
+	}()
+}
+
+// Scheduled returns the number of scheduled messages held by the
+// broker until they are due, by destination.
+func (b *Broker) Scheduled() map[string]int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	counts := make(map[string]int, len(b.wheel.counts))
+
+	for destination, n := range b.wheel.counts {
+		counts[destination] = n
+	}
+	return counts
+}
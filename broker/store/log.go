@@ -0,0 +1,627 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+const (
+	// DefaultSegmentSize is the size beyond which a log starts a new
+	// segment file, unless LogOptions sets another.
+	DefaultSegmentSize = 16 << 20
+
+	// DefaultSyncInterval is the interval at which a log with the
+	// SyncPeriodic policy flushes its writes, unless LogOptions sets
+	// another.
+	DefaultSyncInterval = time.Second
+
+	// DefaultCompactSegments is the number of full segments a log
+	// keeps before it compacts them, unless LogOptions sets another.
+	DefaultCompactSegments = 4
+
+	segmentSuffix = ".log"
+	recordHeader  = 8
+)
+
+// ErrCorrupt is returned when a log holds a damaged record other than
+// at its end, where a damaged record is the trace of an interrupted
+// write, and is discarded.
+var ErrCorrupt = errors.New("corrupt message log")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// A SyncPolicy decides when a log flushes its writes to disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes each commit before it returns, so that a
+	// commit survives the failure of the machine.
+	SyncAlways SyncPolicy = iota
+
+	// SyncPeriodic flushes commits in the background, every
+	// SyncInterval, so that a failure of the machine loses at most
+	// the commits of the last interval.
+	SyncPeriodic
+
+	// SyncNever leaves flushing to the operating system. Commits
+	// survive the failure of the broker process, but not of the
+	// machine.
+	SyncNever
+)
+
+// LogOptions configure a Log.
+type LogOptions struct {
+	// SegmentSize is the size beyond which the log starts a new
+	// segment file. Zero selects DefaultSegmentSize.
+	SegmentSize int64
+
+	// Sync decides when the log flushes its writes.
+	Sync SyncPolicy
+
+	// SyncInterval is the interval of the SyncPeriodic policy. Zero
+	// selects DefaultSyncInterval.
+	SyncInterval time.Duration
+
+	// CompactSegments is the number of full segments holding
+	// unacknowledged messages the log keeps; beyond it, the log
+	// copies their messages to its current segment and removes them.
+	// Zero selects DefaultCompactSegments, and a negative number
+	// leaves compaction to Compact.
+	CompactSegments int
+}
+
+// A Log is a MessageStore writing commits to an append-only log of
+// segment files in a directory. A full segment is removed once all of
+// the messages added in it have been removed from the store, and full
+// segments holding messages that remain unacknowledged are compacted:
+// their messages are copied to the current segment, and they are
+// removed.
+type Log struct {
+	dir  string
+	opts LogOptions
+
+	mu       sync.Mutex
+	segments []*segment
+	file     *os.File
+	entries  map[string]*logEntry
+	nextSeq  uint64
+	dirty    bool
+	closed   bool
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// A segment is a file of the log.
+type segment struct {
+	n    uint64
+	size int64
+
+	// live counts the messages held by the store that were last
+	// written to the segment.
+	live int
+}
+
+// A logEntry is a message held by a log, with the segment it was last
+// written to.
+type logEntry struct {
+	entry
+	seg *segment
+}
+
+// OpenLog opens the log in dir, creating it if need be, and recovers
+// the messages it holds.
+func OpenLog(dir string, opts LogOptions) (*Log, error) {
+	if 0 == opts.SegmentSize {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+
+	if 0 == opts.SyncInterval {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+
+	if 0 == opts.CompactSegments {
+		opts.CompactSegments = DefaultCompactSegments
+	}
+
+	if mkdirErr := os.MkdirAll(dir, 0755); nil != mkdirErr {
+		return nil, mkdirErr
+	}
+	l := &Log{dir: dir, opts: opts, entries: make(map[string]*logEntry), nextSeq: 1}
+
+	if recoverErr := l.recover(); nil != recoverErr {
+		return nil, recoverErr
+	}
+
+	if 0 == len(l.segments) {
+		if createErr := l.createSegment(1); nil != createErr {
+			return nil, createErr
+		}
+	} else {
+		active := l.segments[len(l.segments)-1]
+		f, openErr := os.OpenFile(l.path(active.n), os.O_WRONLY|os.O_APPEND, 0644)
+
+		if nil != openErr {
+			return nil, openErr
+		}
+		l.file = f
+	}
+	l.dropDead()
+
+	if SyncPeriodic == opts.Sync {
+		l.done = make(chan struct{})
+		l.stopped = make(chan struct{})
+		go l.syncLoop()
+	}
+	return l, nil
+}
+
+func (l *Log) path(n uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%016d%s", n, segmentSuffix))
+}
+
+// recover replays the log's segments, discarding a damaged record at
+// the end of the last one.
+func (l *Log) recover() error {
+	infos, readErr := ioutil.ReadDir(l.dir)
+
+	if nil != readErr {
+		return readErr
+	}
+	var numbers []uint64
+
+	for _, info := range infos {
+		name := info.Name()
+
+		if info.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		n, parseErr := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+
+		if nil != parseErr {
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Slice(numbers, func(i, j int) bool {
+		return numbers[i] < numbers[j]
+	})
+
+	for i, n := range numbers {
+		data, readErr := ioutil.ReadFile(l.path(n))
+
+		if nil != readErr {
+			return readErr
+		}
+		seg := &segment{n: n}
+		l.segments = append(l.segments, seg)
+
+		for int64(len(data)) > seg.size {
+			add, remove, size, decodeErr := decodeRecord(data[seg.size:])
+
+			if nil != decodeErr {
+				if i != len(numbers)-1 {
+					return fmt.Errorf("%w. %s at offset %d: %v", ErrCorrupt, l.path(n), seg.size, decodeErr)
+				}
+
+				if truncErr := os.Truncate(l.path(n), seg.size); nil != truncErr {
+					return truncErr
+				}
+				break
+			}
+			l.apply(seg, add, remove)
+			seg.size += size
+		}
+	}
+	return nil
+}
+
+// apply updates the log's entries for a commit written to seg.
+func (l *Log) apply(seg *segment, add []*entry, remove []string) {
+	for _, e := range add {
+		// A message added again was copied by compaction.
+		if old, ok := l.entries[e.msg.ID]; ok {
+			old.seg.live--
+			e.seq = old.seq
+		}
+		l.entries[e.msg.ID] = &logEntry{entry: *e, seg: seg}
+		seg.live++
+
+		if e.seq >= l.nextSeq {
+			l.nextSeq = e.seq + 1
+		}
+	}
+
+	for _, id := range remove {
+		if old, ok := l.entries[id]; ok {
+			old.seg.live--
+			delete(l.entries, id)
+		}
+	}
+}
+
+func (l *Log) createSegment(n uint64) error {
+	f, createErr := os.OpenFile(l.path(n), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0644)
+
+	if nil != createErr {
+		return createErr
+	}
+	l.file = f
+	l.segments = append(l.segments, &segment{n: n})
+	return nil
+}
+
+// write appends a record to the current segment, starting a new one
+// first if the record would take the current one beyond its size,
+// and returns the segment written to.
+func (l *Log) write(record []byte) (*segment, error) {
+	active := l.segments[len(l.segments)-1]
+
+	if active.size > 0 && active.size+int64(len(record)) > l.opts.SegmentSize {
+		if syncErr := l.file.Sync(); nil != syncErr {
+			return nil, syncErr
+		}
+
+		if closeErr := l.file.Close(); nil != closeErr {
+			return nil, closeErr
+		}
+
+		if createErr := l.createSegment(active.n + 1); nil != createErr {
+			return nil, createErr
+		}
+		active = l.segments[len(l.segments)-1]
+	}
+	if _, writeErr := l.file.Write(record); nil != writeErr {
+		// A partial record would hide the records written after it.
+		l.file.Truncate(active.size)
+		return nil, writeErr
+	}
+	active.size += int64(len(record))
+
+	if SyncAlways == l.opts.Sync {
+		return active, l.file.Sync()
+	}
+	l.dirty = true
+	return active, nil
+}
+
+// Commit appends a record of the change to the log.
+func (l *Log) Commit(add []*Message, remove []string) error {
+	if 0 == len(add) && 0 == len(remove) {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	entries := make([]*entry, len(add))
+
+	for i, msg := range add {
+		entries[i] = &entry{seq: l.nextSeq, msg: msg}
+		l.nextSeq++
+	}
+	seg, writeErr := l.write(encodeRecord(entries, remove))
+
+	if nil != writeErr {
+		return writeErr
+	}
+	l.apply(seg, entries, remove)
+
+	if 0 == len(remove) {
+		return nil
+	}
+	l.dropDead()
+
+	if l.opts.CompactSegments >= 0 && len(l.segments)-1 > l.opts.CompactSegments {
+		return l.compact()
+	}
+	return nil
+}
+
+// dropDead removes the oldest full segments while none of the messages
+// added in them remain. Segments are removed oldest first, so that
+// the removal of a message is never forgotten while the record adding
+// it remains.
+func (l *Log) dropDead() {
+	for len(l.segments) > 1 && 0 == l.segments[0].live {
+		if removeErr := os.Remove(l.path(l.segments[0].n)); nil != removeErr && !os.IsNotExist(removeErr) {
+			return
+		}
+		l.segments[0] = nil
+		l.segments = l.segments[1:]
+	}
+}
+
+// Compact copies the messages of the log's full segments to its
+// current segment, and removes the full segments.
+func (l *Log) Compact() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	return l.compact()
+}
+
+func (l *Log) compact() error {
+	full := l.segments[:len(l.segments)-1]
+
+	if 0 == len(full) {
+		return nil
+	}
+	var copied []*entry
+
+	for _, e := range l.entries {
+		for _, seg := range full {
+			if e.seg == seg {
+				copied = append(copied, &entry{seq: e.seq, msg: e.msg})
+			}
+		}
+	}
+	sort.Slice(copied, func(i, j int) bool {
+		return copied[i].seq < copied[j].seq
+	})
+
+	if len(copied) > 0 {
+		seg, writeErr := l.write(encodeRecord(copied, nil))
+
+		if nil != writeErr {
+			return writeErr
+		}
+
+		// The copies must be on disk before the originals are removed.
+		if SyncAlways != l.opts.Sync {
+			if syncErr := l.file.Sync(); nil != syncErr {
+				return syncErr
+			}
+		}
+		l.apply(seg, copied, nil)
+	}
+	l.dropDead()
+	return nil
+}
+
+// Recover returns the messages held.
+func (l *Log) Recover() ([]*Message, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil, ErrClosed
+	}
+	entries := make([]*entry, 0, len(l.entries))
+
+	for _, e := range l.entries {
+		entries = append(entries, &entry{seq: e.seq, msg: e.msg})
+	}
+	return sorted(entries), nil
+}
+
+// Len returns the number of messages held.
+func (l *Log) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// Segments returns the number of segment files of the log.
+func (l *Log) Segments() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.segments)
+}
+
+// Sync flushes the log's writes to disk.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	l.dirty = false
+	return l.file.Sync()
+}
+
+func (l *Log) syncLoop() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(l.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+		l.mu.Lock()
+
+		if l.dirty && !l.closed {
+			l.dirty = false
+			l.file.Sync()
+		}
+		l.mu.Unlock()
+	}
+}
+
+// Close flushes the log and closes its current segment.
+func (l *Log) Close() error {
+	l.mu.Lock()
+
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	syncErr := l.file.Sync()
+	closeErr := l.file.Close()
+	l.mu.Unlock()
+
+	if nil != l.done {
+		close(l.done)
+		<-l.stopped
+	}
+
+	if nil != syncErr {
+		return syncErr
+	}
+	return closeErr
+}
+
+// encodeRecord encodes a commit as a record: its length and CRC-32C
+// checksum, followed by the messages added and the ids of the
+// messages removed.
+func encodeRecord(add []*entry, remove []string) []byte {
+	b := make([]byte, recordHeader, 256)
+	b = appendUvarint(b, uint64(len(add)))
+
+	for _, e := range add {
+		b = appendUvarint(b, e.seq)
+		b = appendString(b, e.msg.ID)
+		b = appendString(b, e.msg.Destination)
+		b = appendUvarint(b, uint64(len(e.msg.Header)))
+
+		for k, values := range e.msg.Header {
+			b = appendString(b, k)
+			b = appendUvarint(b, uint64(len(values)))
+
+			for _, v := range values {
+				b = appendString(b, v)
+			}
+		}
+		b = appendUvarint(b, uint64(len(e.msg.Body)))
+		b = append(b, e.msg.Body...)
+	}
+	b = appendUvarint(b, uint64(len(remove)))
+
+	for _, id := range remove {
+		b = appendString(b, id)
+	}
+	payload := b[recordHeader:]
+	binary.BigEndian.PutUint32(b, uint32(len(payload)))
+	binary.BigEndian.PutUint32(b[4:], crc32.Checksum(payload, castagnoli))
+	return b
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// decodeRecord decodes the record at the start of data, returning its
+// size.
+func decodeRecord(data []byte) ([]*entry, []string, int64, error) {
+	if len(data) < recordHeader {
+		return nil, nil, 0, errors.New("short record header")
+	}
+	length := binary.BigEndian.Uint32(data)
+
+	if uint64(len(data)-recordHeader) < uint64(length) {
+		return nil, nil, 0, errors.New("short record")
+	}
+	payload := data[recordHeader : recordHeader+int(length)]
+
+	if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(data[4:]) {
+		return nil, nil, 0, errors.New("checksum mismatch")
+	}
+	d := decoder{b: payload}
+	add := make([]*entry, d.count())
+
+	for i := range add {
+		e := &entry{seq: d.uvarint(), msg: &Message{}}
+		e.msg.ID = d.string()
+		e.msg.Destination = d.string()
+		e.msg.Header = make(stomp.Header)
+
+		for n := d.count(); n > 0; n-- {
+			k := d.string()
+			values := make([]string, d.count())
+
+			for j := range values {
+				values[j] = d.string()
+			}
+			e.msg.Header[k] = values
+		}
+		e.msg.Body = append([]byte(nil), d.bytes()...)
+		add[i] = e
+	}
+	remove := make([]string, d.count())
+
+	for i := range remove {
+		remove[i] = d.string()
+	}
+
+	if nil != d.err {
+		return nil, nil, 0, d.err
+	}
+
+	if 0 != len(d.b) {
+		return nil, nil, 0, errors.New("trailing bytes in record")
+	}
+	return add, remove, recordHeader + int64(length), nil
+}
+
+// A decoder reads the fields of a record, remembering the first
+// error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if nil != d.err {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+
+	if n <= 0 {
+		d.err = errors.New("malformed varint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// count reads a number of items, each of which takes at least a byte.
+func (d *decoder) count() int {
+	n := d.uvarint()
+
+	if n > uint64(len(d.b)) {
+		if nil == d.err {
+			d.err = errors.New("count exceeds record")
+		}
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
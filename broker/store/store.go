@@ -0,0 +1,136 @@
+// Package store keeps the messages of a broker's queues, so that they
+// survive a restart of the broker.
+//
+// A MessageStore records each change to the broker's queues as one
+// atomic commit: the messages sent to queues, and the messages
+// removed from them by acknowledgement. A transaction is recorded by a
+// single commit, made when it is committed; a transaction in flight
+// when the broker stops leaves no trace, so on recovery its messages
+// are not sent, and the messages it acknowledged are recovered
+// unacknowledged, as if it had been aborted.
+package store
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/jjware/stomp"
+)
+
+// ErrClosed is returned by the methods of a closed store.
+var ErrClosed = errors.New("store closed")
+
+// A Message is a message held by a store.
+type Message struct {
+	// ID is the message's broker-assigned message-id, unique among
+	// the messages of the store.
+	ID string
+
+	// Destination is the queue the message was sent to.
+	Destination string
+
+	// Header holds the message's headers. The store does not modify
+	// it.
+	Header stomp.Header
+
+	// Body is the message's body.
+	Body []byte
+}
+
+// A MessageStore durably records the messages of a broker's queues.
+// Its methods are thread safe.
+type MessageStore interface {
+	// Commit records, as one atomic change, the addition of the
+	// messages of add and the removal of the messages whose ids are
+	// listed by remove. Ids of messages the store does not hold are
+	// ignored. The messages of add must not be modified afterwards.
+	Commit(add []*Message, remove []string) error
+
+	// Recover returns the messages the store holds, in the order they
+	// were first added.
+	Recover() ([]*Message, error)
+
+	// Close releases the store's resources.
+	Close() error
+}
+
+// entry is a message held by a store, numbered by the order in which
+// it was first added.
+type entry struct {
+	seq uint64
+	msg *Message
+}
+
+// sorted returns the messages of entries in order of their sequence
+// numbers.
+func sorted(entries []*entry) []*Message {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+	messages := make([]*Message, len(entries))
+
+	for i, e := range entries {
+		messages[i] = e.msg
+	}
+	return messages
+}
+
+// A Memory is a MessageStore holding messages in memory, which
+// survives the restart of a broker, but not of its process. The zero
+// Memory is empty and ready to use.
+type Memory struct {
+	mu      sync.Mutex
+	nextSeq uint64
+	entries map[string]*entry
+}
+
+// NewMemory returns an empty memory store.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Commit adds and removes messages.
+func (m *Memory) Commit(add []*Message, remove []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if nil == m.entries {
+		m.entries = make(map[string]*entry)
+	}
+
+	for _, msg := range add {
+		m.nextSeq++
+		m.entries[msg.ID] = &entry{seq: m.nextSeq, msg: msg}
+	}
+
+	for _, id := range remove {
+		delete(m.entries, id)
+	}
+	return nil
+}
+
+// Recover returns the messages held.
+func (m *Memory) Recover() ([]*Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make([]*entry, 0, len(m.entries))
+
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	return sorted(entries), nil
+}
+
+// Len returns the number of messages held.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// Close does nothing: the messages held remain available to the next
+// broker given the store.
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jjware/stomp"
+)
+
+func newMessage(id string) *Message {
+	return &Message{
+		ID:          id,
+		Destination: "/queue/test",
+		Header:      stomp.Header{"x-id": {id}, "x-empty": {""}},
+		Body:        []byte("body of " + id),
+	}
+}
+
+func ids(messages []*Message) string {
+	var s []string
+
+	for _, m := range messages {
+		s = append(s, m.ID)
+	}
+	return strings.Join(s, ",")
+}
+
+func expectMessages(t *testing.T, s MessageStore, want string) {
+	t.Helper()
+	messages, recoverErr := s.Recover()
+
+	if nil != recoverErr {
+		t.Fatal(recoverErr)
+	}
+
+	if got := ids(messages); got != want {
+		t.Fatalf("messages = %q, expected %q", got, want)
+	}
+
+	for _, m := range messages {
+		if v, _ := m.Header.Get("x-id"); v != m.ID || "body of "+m.ID != string(m.Body) || "/queue/test" != m.Destination {
+			t.Errorf("message %s recovered as %+v", m.ID, m)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	s := NewMemory()
+
+	if commitErr := s.Commit([]*Message{newMessage("a"), newMessage("b"), newMessage("c")}, nil); nil != commitErr {
+		t.Fatal(commitErr)
+	}
+
+	if commitErr := s.Commit([]*Message{newMessage("d")}, []string{"b", "unknown"}); nil != commitErr {
+		t.Fatal(commitErr)
+	}
+	expectMessages(t, s, "a,c,d")
+}
+
+func tempDir(t *testing.T) string {
+	dir, tempErr := ioutil.TempDir("", "store")
+
+	if nil != tempErr {
+		t.Fatal(tempErr)
+	}
+	return dir
+}
+
+func openLog(t *testing.T, dir string, opts LogOptions) *Log {
+	l, openErr := OpenLog(dir, opts)
+
+	if nil != openErr {
+		t.Fatal(openErr)
+	}
+	return l
+}
+
+func TestLogRecover(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, policy := range []SyncPolicy{SyncAlways, SyncPeriodic, SyncNever} {
+		l := openLog(t, filepath.Join(dir, fmt.Sprint(policy)), LogOptions{Sync: policy})
+
+		if commitErr := l.Commit([]*Message{newMessage("a"), newMessage("b")}, nil); nil != commitErr {
+			t.Fatal(commitErr)
+		}
+
+		if commitErr := l.Commit([]*Message{newMessage("c")}, []string{"a"}); nil != commitErr {
+			t.Fatal(commitErr)
+		}
+
+		if closeErr := l.Close(); nil != closeErr {
+			t.Fatal(closeErr)
+		}
+
+		if commitErr := l.Commit(nil, []string{"b"}); !errors.Is(commitErr, ErrClosed) {
+			t.Errorf("commit after close: %v", commitErr)
+		}
+		l = openLog(t, filepath.Join(dir, fmt.Sprint(policy)), LogOptions{Sync: policy})
+		expectMessages(t, l, "b,c")
+
+		if commitErr := l.Commit([]*Message{newMessage("d")}, []string{"b"}); nil != commitErr {
+			t.Fatal(commitErr)
+		}
+		l.Close()
+		l = openLog(t, filepath.Join(dir, fmt.Sprint(policy)), LogOptions{Sync: policy})
+		expectMessages(t, l, "c,d")
+		l.Close()
+	}
+}
+
+func TestLogTornWrite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l := openLog(t, dir, LogOptions{})
+	l.Commit([]*Message{newMessage("a")}, nil)
+	l.Commit([]*Message{newMessage("b")}, nil)
+	l.Close()
+
+	// Cut the last record short, as a crash during its write would.
+	path := filepath.Join(dir, "0000000000000001.log")
+	info, statErr := os.Stat(path)
+
+	if nil != statErr {
+		t.Fatal(statErr)
+	}
+
+	if truncErr := os.Truncate(path, info.Size()-3); nil != truncErr {
+		t.Fatal(truncErr)
+	}
+	l = openLog(t, dir, LogOptions{})
+	expectMessages(t, l, "a")
+	l.Commit([]*Message{newMessage("c")}, nil)
+	l.Close()
+
+	l = openLog(t, dir, LogOptions{})
+	expectMessages(t, l, "a,c")
+	l.Close()
+}
+
+func TestLogCompaction(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	opts := LogOptions{SegmentSize: 128, CompactSegments: -1}
+	l := openLog(t, dir, opts)
+
+	for i := 0; i < 20; i++ {
+		if commitErr := l.Commit([]*Message{newMessage(fmt.Sprint(i))}, nil); nil != commitErr {
+			t.Fatal(commitErr)
+		}
+	}
+	segments := l.Segments()
+
+	if segments < 5 {
+		t.Fatalf("%d segments", segments)
+	}
+	var acked []string
+
+	for i := 0; i < 10; i++ {
+		acked = append(acked, fmt.Sprint(i))
+	}
+
+	if commitErr := l.Commit(nil, acked); nil != commitErr {
+		t.Fatal(commitErr)
+	}
+
+	if l.Segments() >= segments {
+		t.Errorf("%d segments remain of %d after acknowledging the oldest messages", l.Segments(), segments)
+	}
+
+	// The oldest message keeps every segment after its own.
+	if commitErr := l.Commit(nil, []string{"11", "12", "13", "14", "15", "16", "17", "18"}); nil != commitErr {
+		t.Fatal(commitErr)
+	}
+	segments = l.Segments()
+
+	if compactErr := l.Compact(); nil != compactErr {
+		t.Fatal(compactErr)
+	}
+
+	if l.Segments() >= segments {
+		t.Errorf("%d segments remain of %d after compaction", l.Segments(), segments)
+	}
+	expectMessages(t, l, "10,19")
+	l.Close()
+
+	files, _ := ioutil.ReadDir(dir)
+
+	if len(files) != l.Segments() {
+		t.Errorf("%d files for %d segments", len(files), l.Segments())
+	}
+	l = openLog(t, dir, opts)
+	expectMessages(t, l, "10,19")
+	l.Close()
+
+	// Compaction also happens as segments accumulate.
+	l = openLog(t, dir, LogOptions{SegmentSize: 128, CompactSegments: 2})
+	defer l.Close()
+
+	for i := 20; i < 60; i++ {
+		l.Commit([]*Message{newMessage(fmt.Sprint(i))}, []string{fmt.Sprint(i - 1)})
+	}
+
+	if l.Segments() > 3 {
+		t.Errorf("%d segments", l.Segments())
+	}
+	expectMessages(t, l, "10,59")
+}
+
+func TestLogCorrupt(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l := openLog(t, dir, LogOptions{SegmentSize: 64})
+
+	for i := 0; i < 3; i++ {
+		l.Commit([]*Message{newMessage(fmt.Sprint(i))}, nil)
+	}
+	l.Close()
+	path := filepath.Join(dir, "0000000000000001.log")
+	data, _ := ioutil.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(path, data, 0644)
+
+	if _, openErr := OpenLog(dir, LogOptions{}); !errors.Is(openErr, ErrCorrupt) {
+		t.Errorf("expected %v, got %v", ErrCorrupt, openErr)
+	}
+}
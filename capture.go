@@ -0,0 +1,375 @@
+package stomp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	captureMagic   = "STOMPCAP"
+	captureVersion = uint16(1)
+
+	// maxCaptureRecordBytes guards against allocating an absurd
+	// amount of memory when reading a corrupt capture file.
+	maxCaptureRecordBytes = 1 << 30
+)
+
+// ErrBadCapture is returned when reading a stream that does not
+// begin with a capture file header.
+var ErrBadCapture = errors.New("not a stomp capture")
+
+// Direction indicates whether a captured frame was read from,
+// or written to, a connection stream.
+type Direction byte
+
+const (
+	DirInbound  Direction = 'I'
+	DirOutbound Direction = 'O'
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirInbound:
+		return "inbound"
+	case DirOutbound:
+		return "outbound"
+	}
+	return fmt.Sprintf("Direction(%d)", byte(d))
+}
+
+// A CaptureRecord is a single frame, or heart-beat, observed on a
+// connection stream. Data holds the frame exactly as it appeared on
+// the wire, including the terminating null character.
+type CaptureRecord struct {
+	Time      time.Time
+	Direction Direction
+	Data      []byte
+}
+
+// A CaptureWriter writes capture records to an underlying writer.
+//
+// The capture format begins with the 8 byte magic string "STOMPCAP"
+// followed by a big endian uint16 format version. Each record that
+// follows is made up of a big endian int64 timestamp in nanoseconds
+// since the unix epoch, a single direction byte, a big endian uint32
+// data length, and the data itself.
+type CaptureWriter struct {
+	w io.Writer
+}
+
+// NewCaptureWriter returns a new CaptureWriter writing to w. The
+// capture file header is written to w immediately.
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	var hdr [10]byte
+	copy(hdr[:], captureMagic)
+	binary.BigEndian.PutUint16(hdr[8:], captureVersion)
+
+	if _, writeErr := w.Write(hdr[:]); nil != writeErr {
+		return nil, writeErr
+	}
+	return &CaptureWriter{w: w}, nil
+}
+
+// WriteRecord writes a single record to the capture.
+func (cw *CaptureWriter) WriteRecord(rec CaptureRecord) error {
+	var hdr [13]byte
+	binary.BigEndian.PutUint64(hdr[0:], uint64(rec.Time.UnixNano()))
+	hdr[8] = byte(rec.Direction)
+	binary.BigEndian.PutUint32(hdr[9:], uint32(len(rec.Data)))
+
+	if _, writeErr := cw.w.Write(hdr[:]); nil != writeErr {
+		return writeErr
+	}
+	_, writeErr := cw.w.Write(rec.Data)
+	return writeErr
+}
+
+// A CaptureReader reads capture records written by a CaptureWriter.
+type CaptureReader struct {
+	r io.Reader
+}
+
+// NewCaptureReader returns a new CaptureReader reading from r. The
+// capture file header is read and validated immediately.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	var hdr [10]byte
+
+	if _, readErr := io.ReadFull(r, hdr[:]); nil != readErr {
+		return nil, readErr
+	}
+
+	if string(hdr[:8]) != captureMagic {
+		return nil, ErrBadCapture
+	}
+
+	if v := binary.BigEndian.Uint16(hdr[8:]); v != captureVersion {
+		return nil, fmt.Errorf("unsupported capture version %d", v)
+	}
+	return &CaptureReader{r: r}, nil
+}
+
+// ReadRecord reads the next record from the capture. At the end
+// of the capture, ReadRecord returns io.EOF.
+func (cr *CaptureReader) ReadRecord() (*CaptureRecord, error) {
+	var hdr [13]byte
+
+	if _, readErr := io.ReadFull(cr.r, hdr[:]); nil != readErr {
+		return nil, readErr
+	}
+	length := binary.BigEndian.Uint32(hdr[9:])
+
+	if length > maxCaptureRecordBytes {
+		return nil, fmt.Errorf("capture record too large. got %d bytes", length)
+	}
+	data := make([]byte, length)
+
+	if _, readErr := io.ReadFull(cr.r, data); nil != readErr {
+		if io.EOF == readErr {
+			readErr = io.ErrUnexpectedEOF
+		}
+		return nil, readErr
+	}
+
+	return &CaptureRecord{
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(hdr[0:]))),
+		Direction: Direction(hdr[8]),
+		Data:      data,
+	}, nil
+}
+
+// A Capture wraps a connection stream, recording every frame read
+// from and written to it. A Capture may be passed to Bind in place
+// of the stream it wraps.
+type Capture struct {
+	rw  io.ReadWriter
+	mu  sync.Mutex
+	cw  *CaptureWriter
+	in  frameSplitter
+	out frameSplitter
+	err error
+}
+
+// NewCapture returns a Capture that wraps rw and writes its records
+// to dst. Failing to write to dst does not interrupt traffic on rw,
+// but stops the recording. See Err.
+func NewCapture(rw io.ReadWriter, dst io.Writer) (*Capture, error) {
+	cw, cwErr := NewCaptureWriter(dst)
+
+	if nil != cwErr {
+		return nil, cwErr
+	}
+	return &Capture{rw: rw, cw: cw}, nil
+}
+
+// Read reads from the wrapped stream, recording inbound frames.
+func (c *Capture) Read(p []byte) (int, error) {
+	n, readErr := c.rw.Read(p)
+
+	if n > 0 {
+		c.record(DirInbound, &c.in, p[:n])
+	}
+	return n, readErr
+}
+
+// Write writes to the wrapped stream, recording outbound frames.
+func (c *Capture) Write(p []byte) (int, error) {
+	n, writeErr := c.rw.Write(p)
+
+	if n > 0 {
+		c.record(DirOutbound, &c.out, p[:n])
+	}
+	return n, writeErr
+}
+
+// Err returns the first error encountered while writing the
+// recording, if any.
+func (c *Capture) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Capture) record(dir Direction, s *frameSplitter, p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s.split(p, func(data []byte) {
+		if nil != c.err {
+			return
+		}
+		c.err = c.cw.WriteRecord(CaptureRecord{
+			Time:      time.Now(),
+			Direction: dir,
+			Data:      data,
+		})
+	})
+}
+
+const (
+	splitIdle = iota
+	splitHeader
+	splitBody
+	splitNull
+)
+
+// frameSplitter finds frame boundaries in a stream of bytes that
+// arrives in chunks of arbitrary size.
+type frameSplitter struct {
+	buf    []byte
+	state  int
+	line   int
+	remain int64
+}
+
+// split consumes p, calling emit with the wire bytes of every frame
+// or heart-beat completed by p.
+func (s *frameSplitter) split(p []byte, emit func([]byte)) {
+	for _, b := range p {
+		s.buf = append(s.buf, b)
+
+		switch s.state {
+		case splitIdle:
+			if byteNewLine == b {
+				s.flush(emit)
+			} else if byteCarriageReturn != b {
+				s.state = splitHeader
+				s.line = 1
+			}
+		case splitHeader:
+			switch b {
+			case byteNewLine:
+				if 0 == s.line {
+					s.remain = s.contentLength()
+
+					if s.remain > 0 {
+						s.state = splitBody
+					} else {
+						s.state = splitNull
+					}
+				}
+				s.line = 0
+			case byteCarriageReturn:
+			case byteNull:
+				s.flush(emit)
+			default:
+				s.line++
+			}
+		case splitBody:
+			s.remain--
+
+			if 0 == s.remain {
+				s.state = splitNull
+			}
+		case splitNull:
+			if byteNull == b {
+				s.flush(emit)
+			}
+		}
+	}
+}
+
+func (s *frameSplitter) flush(emit func([]byte)) {
+	data := make([]byte, len(s.buf))
+	copy(data, s.buf)
+	s.buf = s.buf[:0]
+	s.state = splitIdle
+	emit(data)
+}
+
+// contentLength returns the value of the first content-length header
+// found in the buffered frame header, or 0 if there is none.
+func (s *frameSplitter) contentLength() int64 {
+	lines := bytes.Split(s.buf, bytesNewLine)
+
+	for _, line := range lines[1:] {
+		line = stripCarriageReturn(line)
+		ndx := bytes.IndexByte(line, byteColon)
+
+		if ndx <= 0 || decode(string(line[:ndx])) != HdrContentLength {
+			continue
+		}
+		length, convErr := strconv.ParseInt(string(line[ndx+1:]), 10, 64)
+
+		if nil != convErr || length < 0 {
+			return 0
+		}
+		return length
+	}
+	return 0
+}
+
+// A Replayer plays back the frames of a capture that travelled in
+// a single direction. Reading from a Replayer yields the recorded
+// frames, paced according to their original timestamps, which makes
+// it suitable for passing to Bind. Writes to a Replayer are discarded;
+// wrap the Replayer in a Capture to record the responses.
+type Replayer struct {
+	cr      *CaptureReader
+	dir     Direction
+	speed   float64
+	start   time.Time
+	origin  time.Time
+	pending []byte
+}
+
+// NewReplayer returns a Replayer that reads a capture from r and plays
+// back the frames recorded in direction dir. A speed of 1 replays
+// frames at their original pace, a speed of 2 twice as fast, and so on.
+// A speed of 0 or less replays frames without delay.
+func NewReplayer(r io.Reader, dir Direction, speed float64) (*Replayer, error) {
+	cr, crErr := NewCaptureReader(r)
+
+	if nil != crErr {
+		return nil, crErr
+	}
+	return &Replayer{cr: cr, dir: dir, speed: speed}, nil
+}
+
+// Read reads the recorded frames. Read blocks until the next frame
+// is due, and returns io.EOF once the capture is exhausted.
+func (rp *Replayer) Read(p []byte) (int, error) {
+	for len(rp.pending) == 0 {
+		rec, readErr := rp.cr.ReadRecord()
+
+		if nil != readErr {
+			return 0, readErr
+		}
+
+		if rec.Direction != rp.dir {
+			continue
+		}
+		rp.wait(rec.Time)
+		rp.pending = rec.Data
+	}
+	n := copy(p, rp.pending)
+	rp.pending = rp.pending[n:]
+	return n, nil
+}
+
+// Write discards p.
+func (rp *Replayer) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (rp *Replayer) wait(t time.Time) {
+	if rp.start.IsZero() {
+		rp.start = time.Now()
+		rp.origin = t
+		return
+	}
+
+	if rp.speed <= 0 {
+		return
+	}
+	offset := time.Duration(float64(t.Sub(rp.origin)) / rp.speed)
+
+	if d := time.Until(rp.start.Add(offset)); d > 0 {
+		time.Sleep(d)
+	}
+}
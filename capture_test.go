@@ -0,0 +1,153 @@
+package stomp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+type captureStream struct {
+	io.Reader
+	io.Writer
+}
+
+const captureInput = "CONNECTED\n" +
+	"version:1.2\n" +
+	"\n" +
+	"\x00" +
+	"\r\n" +
+	"MESSAGE\n" +
+	"content-length:5\n" +
+	"destination:/queue/test\n" +
+	"\n" +
+	"a\x00b\nc\x00"
+
+func TestCapture(t *testing.T) {
+	var out, rec bytes.Buffer
+	capture, captureErr := NewCapture(captureStream{strings.NewReader(captureInput), &out}, &rec)
+
+	if nil != captureErr {
+		t.Fatal(captureErr)
+	}
+	f := NewFrame(CmdSend, bytes.NewBufferString("hello"))
+	f.Header.Set(HdrDestination, "/queue/test")
+
+	if _, writeErr := f.WriteTo(capture); nil != writeErr {
+		t.Fatal(writeErr)
+	}
+
+	for {
+		frm, readErr := ReadFrame(capture)
+
+		if nil != readErr {
+			t.Fatal(readErr)
+		}
+
+		if nil == frm {
+			continue
+		}
+		frm.Body.Close()
+
+		if frm.Command == CmdMessage {
+			break
+		}
+	}
+
+	if nil != capture.Err() {
+		t.Fatal(capture.Err())
+	}
+	cr, crErr := NewCaptureReader(&rec)
+
+	if nil != crErr {
+		t.Fatal(crErr)
+	}
+	want := []CaptureRecord{
+		{Direction: DirOutbound, Data: out.Bytes()},
+		{Direction: DirInbound, Data: []byte("CONNECTED\nversion:1.2\n\n\x00")},
+		{Direction: DirInbound, Data: []byte("\r\n")},
+		{Direction: DirInbound, Data: []byte("MESSAGE\ncontent-length:5\ndestination:/queue/test\n\na\x00b\nc\x00")},
+	}
+
+	for i, w := range want {
+		have, readErr := cr.ReadRecord()
+
+		if nil != readErr {
+			t.Fatalf("#%d: %v", i, readErr)
+		}
+
+		if have.Direction != w.Direction {
+			t.Errorf("#%d: Direction = %v want %v", i, have.Direction, w.Direction)
+		}
+
+		if !bytes.Equal(have.Data, w.Data) {
+			t.Errorf("#%d: Data = %q want %q", i, have.Data, w.Data)
+		}
+
+		if have.Time.IsZero() {
+			t.Errorf("#%d: Time is zero", i)
+		}
+	}
+
+	if _, readErr := cr.ReadRecord(); io.EOF != readErr {
+		t.Errorf("expected io.EOF, got %v", readErr)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var rec bytes.Buffer
+	cw, cwErr := NewCaptureWriter(&rec)
+
+	if nil != cwErr {
+		t.Fatal(cwErr)
+	}
+	start := time.Now()
+	records := []CaptureRecord{
+		{start, DirOutbound, []byte("CONNECT\n\n\x00")},
+		{start, DirInbound, []byte("CONNECTED\nversion:1.2\n\n\x00")},
+		{start.Add(50 * time.Millisecond), DirInbound, []byte("MESSAGE\ncontent-length:2\n\nhi\x00")},
+	}
+
+	for _, r := range records {
+		if writeErr := cw.WriteRecord(r); nil != writeErr {
+			t.Fatal(writeErr)
+		}
+	}
+	replayer, replayErr := NewReplayer(&rec, DirInbound, 1)
+
+	if nil != replayErr {
+		t.Fatal(replayErr)
+	}
+	handle := Bind(replayer)
+	defer handle.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	began := time.Now()
+
+	for i, want := range []Command{CmdConnected, CmdMessage} {
+		f, readErr := handle.Receive(ctx)
+
+		if nil != readErr {
+			t.Fatalf("#%d: %v", i, readErr)
+		}
+
+		if f.Command != want {
+			t.Errorf("#%d: Command = %v want %v", i, f.Command, want)
+		}
+		body, _ := ioutil.ReadAll(f.Body)
+		f.Body.Close()
+
+		if want == CmdMessage && string(body) != "hi" {
+			t.Errorf("#%d: Body = %q want %q", i, body, "hi")
+		}
+	}
+
+	if elapsed := time.Since(began); elapsed < 50*time.Millisecond {
+		t.Errorf("replay did not honor timing. took %v", elapsed)
+	}
+}
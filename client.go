@@ -0,0 +1,683 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAcceptVersion is the accept-version header Connect sends
+// when the caller does not provide one.
+const DefaultAcceptVersion = "1.0,1.1,1.2"
+
+// DefaultSubscriptionBuffer is the number of messages a subscription
+// holds until Receive is called, unless ClientOptions sets another.
+const DefaultSubscriptionBuffer = 16
+
+// HdrPrefetchCount is a SUBSCRIBE header limiting the number of
+// messages a server delivers to a subscription in client or
+// client-individual ack mode before they are acknowledged. It is
+// understood by the broker package, but is not part of STOMP.
+const HdrPrefetchCount = "prefetch-count"
+
+const (
+	// HdrDelay is a SEND header asking a server to hold a message for
+	// a number of milliseconds before delivering it.
+	HdrDelay = "delay"
+
+	// HdrDeliverAt is a SEND header asking a server to hold a message
+	// until a time, in milliseconds since the Unix epoch.
+	HdrDeliverAt = "deliver-at"
+)
+
+var (
+	// ErrClientClosed is returned by the methods of a Client whose
+	// session was ended by Close or Disconnect.
+	ErrClientClosed = errors.New("client closed")
+
+	// ErrSubscriptionClosed is returned by Receive once a
+	// subscription has been unsubscribed.
+	ErrSubscriptionClosed = errors.New("subscription closed")
+
+	// ErrSubscriptionOverflow is returned by Receive, once the
+	// messages held are received, for a subscription the server sent
+	// more messages than it holds. The client unsubscribes it rather
+	// than stop reading the connection.
+	ErrSubscriptionOverflow = errors.New("subscription buffer overflow")
+)
+
+// A ServerError is an ERROR frame sent by a server.
+type ServerError struct {
+	// Message is the value of the frame's message header.
+	Message string
+
+	// Header is the frame's header.
+	Header Header
+
+	// Body is the frame's body, which may describe the error in
+	// more detail.
+	Body []byte
+}
+
+func newServerError(f *Frame, body []byte) *ServerError {
+	message, _ := f.Header.Get(HdrMessage)
+	return &ServerError{Message: message, Header: f.Header, Body: body}
+}
+
+func (e *ServerError) Error() string {
+	if "" == e.Message {
+		return "server error"
+	}
+	return "server error: " + e.Message
+}
+
+// ClientOptions configures a client created by Connect.
+type ClientOptions struct {
+	// Logger receives the client's events. A nil Logger discards
+	// them.
+	Logger Logger
+
+	// Interceptors are installed on the client's handle before the
+	// CONNECT frame is sent.
+	Interceptors []Interceptor
+
+	// SubscriptionBuffer is the number of messages each subscription
+	// holds until Receive is called. Subscriptions in client or
+	// client-individual ack mode send it as their prefetch-count
+	// header, unless they give a larger one, which they hold instead.
+	// A subscription sent more messages than it holds ends with
+	// ErrSubscriptionOverflow. Zero selects DefaultSubscriptionBuffer.
+	SubscriptionBuffer int
+
+	// ReplyTo is the destination the client subscribes to for the
+	// replies to its requests. Empty selects DefaultReplyPrefix
+	// followed by a random name.
+	ReplyTo string
+}
+
+// A Client is a STOMP session with a server. A Client reads frames
+// from the connection in the background, routing MESSAGE frames to
+// their subscriptions and RECEIPT frames to the calls waiting on
+// them. The methods of a Client are thread safe.
+type Client struct {
+	handle  *Handle
+	conn    io.Closer
+	log     Logger
+	version string
+	session string
+	server  string
+	buffer  int
+
+	sendMu   sync.RWMutex
+	released bool
+
+	mu       sync.Mutex
+	subs     map[string]*Subscription
+	receipts map[string]chan *Frame
+	closing  bool
+	err      error
+	done     chan struct{}
+	wg       sync.WaitGroup
+	nextID   uint64
+
+	replyMu  sync.Mutex
+	replyTo  string
+	replySub *Subscription
+	waiting  map[string]chan *Frame
+}
+
+// Connect performs the STOMP handshake over conn and returns the
+// established session. The given header is sent with the CONNECT
+// frame; accept-version defaults to DefaultAcceptVersion, and host
+// defaults to "/". If the header requests heart-beats, the client
+// sends and monitors them at the negotiated intervals. ctx governs
+// the handshake only. If the handshake fails, conn is closed.
+func Connect(ctx context.Context, conn io.ReadWriteCloser, header Header, opts *ClientOptions) (*Client, error) {
+	if nil == opts {
+		opts = &ClientOptions{}
+	}
+	var log Logger = NopLogger{}
+
+	if nil != opts.Logger {
+		log = opts.Logger
+	}
+	handle := Bind(conn)
+	handle.SetLogger(opts.Logger)
+	handle.Use(opts.Interceptors...)
+
+	abort := func(err error) (*Client, error) {
+		handle.Release()
+		conn.Close()
+		log.Error("connect failed", "error", err)
+		return nil, err
+	}
+	f := NewFrame(CmdConnect, nil)
+
+	for k, v := range header {
+		f.Header[k] = append([]string(nil), v...)
+	}
+
+	if _, ok := f.Header.Get(HdrAcceptVersion); !ok {
+		f.Header.Set(HdrAcceptVersion, DefaultAcceptVersion)
+	}
+
+	if _, ok := f.Header.Get(HdrHost); !ok {
+		f.Header.Set(HdrHost, "/")
+	}
+	hb, _ := f.Header.Get(HdrHeartBeat)
+	cx, cy, hbErr := parseHeartBeat(hb)
+
+	if nil != hbErr {
+		return abort(hbErr)
+	}
+	host, _ := f.Header.Get(HdrHost)
+	log.Debug("connecting", "host", host)
+
+	if sendErr := handle.Send(ctx, f); nil != sendErr {
+		return abort(sendErr)
+	}
+	var resp *Frame
+
+	for nil == resp {
+		var readErr error
+		resp, readErr = handle.Receive(ctx)
+
+		if nil != readErr {
+			return abort(readErr)
+		}
+	}
+	body, bodyErr := bufferBody(resp)
+
+	if nil != bodyErr {
+		return abort(bodyErr)
+	}
+
+	switch resp.Command {
+	case CmdConnected:
+	case CmdError:
+		return abort(newServerError(resp, body))
+	default:
+		return abort(fmt.Errorf("expected %s frame, got %s", CmdConnected, resp.Command))
+	}
+	hb, _ = resp.Header.Get(HdrHeartBeat)
+	sx, sy, hbErr := parseHeartBeat(hb)
+
+	if nil != hbErr {
+		return abort(hbErr)
+	}
+
+	c := &Client{
+		handle:   handle,
+		conn:     conn,
+		log:      log,
+		version:  "1.0",
+		buffer:   opts.SubscriptionBuffer,
+		subs:     make(map[string]*Subscription),
+		receipts: make(map[string]chan *Frame),
+		done:     make(chan struct{}),
+		replyTo:  opts.ReplyTo,
+		waiting:  make(map[string]chan *Frame),
+	}
+
+	if "" == c.replyTo {
+		c.replyTo = replyDestination()
+	}
+
+	if c.buffer <= 0 {
+		c.buffer = DefaultSubscriptionBuffer
+	}
+
+	if v, ok := resp.Header.Get(HdrVersion); ok {
+		c.version = v
+	}
+	c.session, _ = resp.Header.Get(HdrSession)
+	c.server, _ = resp.Header.Get(HdrServer)
+
+	beater := newHeartBeater(handle.Send, heartBeatInterval(cx, sy), heartBeatInterval(sx, cy))
+	c.wg.Add(2)
+	go c.readLoop(beater)
+	go func() {
+		defer c.wg.Done()
+		beater.run(c.done, func() {
+			c.log.Warn("server heart-beat missed", "interval", beater.receive)
+			handle.heartBeatMissed()
+			c.fail(ErrHeartBeatTimeout)
+		})
+	}()
+	log.Info("connected", "version", c.version, "session", c.session, "server", c.server)
+	return c, nil
+}
+
+// Version returns the protocol version negotiated with the server.
+func (c *Client) Version() string {
+	return c.version
+}
+
+// Session returns the session identifier assigned by the server,
+// if any.
+func (c *Client) Session() string {
+	return c.session
+}
+
+// Server returns the server's description of itself, if any.
+func (c *Client) Server() string {
+	return c.server
+}
+
+// Done returns a channel that is closed when the session ends.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the session ended, or nil while it is
+// still active.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// outgoing returns a frame for a client to send: frame, given a header
+// if it has none, or an empty SEND frame if it is nil.
+func outgoing(frame *Frame) *Frame {
+	if nil == frame {
+		return NewFrame(CmdSend, nil)
+	}
+
+	if nil == frame.Header {
+		frame.Header = make(Header)
+	}
+	return frame
+}
+
+// Send sends frame to destination as a SEND frame. A nil frame sends
+// an empty message. If the frame has a receipt header, Send waits for
+// the server to acknowledge the frame.
+func (c *Client) Send(ctx context.Context, destination string, frame *Frame) error {
+	frame = outgoing(frame)
+	frame.Command = CmdSend
+	frame.Header.Set(HdrDestination, destination)
+	return c.send(ctx, frame)
+}
+
+// SendAfter sends frame to destination, asking the server to deliver
+// it once delay has passed. Delays are understood by the broker
+// package, but are not part of STOMP.
+func (c *Client) SendAfter(ctx context.Context, destination string, frame *Frame, delay time.Duration) error {
+	frame = outgoing(frame)
+	frame.Header.Set(HdrDelay, strconv.FormatInt(int64(delay/time.Millisecond), 10))
+	return c.Send(ctx, destination, frame)
+}
+
+// SendAt sends frame to destination, asking the server to deliver it
+// at t. Delivery times are understood by the broker package, but are
+// not part of STOMP.
+func (c *Client) SendAt(ctx context.Context, destination string, frame *Frame, t time.Time) error {
+	frame = outgoing(frame)
+	frame.Header.Set(HdrDeliverAt, strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10))
+	return c.Send(ctx, destination, frame)
+}
+
+// Subscribe subscribes to destination. The given header is sent
+// with the SUBSCRIBE frame; the subscription id is generated when
+// the header does not provide one, and ack defaults to AckAuto.
+func (c *Client) Subscribe(ctx context.Context, destination string, header Header) (*Subscription, error) {
+	f := NewFrame(CmdSubscribe, nil)
+
+	for k, v := range header {
+		f.Header[k] = append([]string(nil), v...)
+	}
+	f.Header.Set(HdrDestination, destination)
+	id, ok := f.Header.Get(HdrId)
+
+	if !ok {
+		id = c.newID("sub-")
+		f.Header.Set(HdrId, id)
+	}
+	ack, ok := f.Header.Get(HdrAck)
+
+	if !ok {
+		ack = AckAuto
+		f.Header.Set(HdrAck, ack)
+	}
+
+	buffer := c.buffer
+
+	// A server honouring the prefetch count never sends more than
+	// the subscription can hold.
+	if AckAuto != ack {
+		v, ok := f.Header.Get(HdrPrefetchCount)
+
+		if !ok {
+			f.Header.Set(HdrPrefetchCount, strconv.Itoa(buffer))
+		} else if prefetch, atoiErr := strconv.Atoi(v); nil == atoiErr && prefetch > buffer {
+			buffer = prefetch
+		}
+	}
+
+	sub := &Subscription{
+		c:           c,
+		id:          id,
+		destination: destination,
+		ack:         ack,
+		ch:          make(chan *Frame, buffer),
+		done:        make(chan struct{}),
+	}
+	c.mu.Lock()
+
+	if _, exists := c.subs[id]; exists {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("subscription %q already exists", id)
+	}
+	c.subs[id] = sub
+	c.mu.Unlock()
+
+	if sendErr := c.send(ctx, f); nil != sendErr {
+		c.removeSubscription(sub, ErrSubscriptionClosed)
+		return nil, sendErr
+	}
+	return sub, nil
+}
+
+// Ack acknowledges the consumption of msg, a MESSAGE frame received
+// on a subscription using client or client-individual ack mode.
+func (c *Client) Ack(ctx context.Context, msg *Frame) error {
+	return c.send(ctx, c.ackFrame(CmdAck, msg))
+}
+
+// Nack informs the server that msg, a MESSAGE frame received on a
+// subscription using client or client-individual ack mode, was not
+// consumed. NACK is not available in STOMP 1.0.
+func (c *Client) Nack(ctx context.Context, msg *Frame) error {
+	return c.send(ctx, c.ackFrame(CmdNack, msg))
+}
+
+// ackFrame builds an ACK or NACK frame for msg according to the
+// negotiated protocol version.
+func (c *Client) ackFrame(cmd Command, msg *Frame) *Frame {
+	f := NewFrame(cmd, nil)
+
+	if "1.2" == c.version {
+		id, _ := msg.Header.Get(HdrAck)
+		f.Header.Set(HdrId, id)
+		return f
+	}
+	id, _ := msg.Header.Get(HdrMessageId)
+	f.Header.Set(HdrMessageId, id)
+
+	if "1.1" == c.version {
+		sub, _ := msg.Header.Get(HdrSubscription)
+		f.Header.Set(HdrSubscription, sub)
+	}
+	return f
+}
+
+// Disconnect gracefully ends the session, waiting for the server to
+// acknowledge the DISCONNECT frame before closing the connection.
+func (c *Client) Disconnect(ctx context.Context) error {
+	c.mu.Lock()
+	c.closing = true
+	c.mu.Unlock()
+
+	f := NewFrame(CmdDisconnect, nil)
+	f.Header.Set(HdrReceipt, c.newID("disconnect-"))
+	sendErr := c.send(ctx, f)
+	c.Close()
+
+	if ErrClientClosed == sendErr {
+		return nil
+	}
+	return sendErr
+}
+
+// Close ends the session immediately, closing the connection and
+// releasing the client's resources.
+func (c *Client) Close() error {
+	c.fail(ErrClientClosed)
+	c.wg.Wait()
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if !c.released {
+		c.handle.Release()
+		c.released = true
+	}
+	return nil
+}
+
+// send sends f, waiting for a receipt if f requests one.
+func (c *Client) send(ctx context.Context, f *Frame) error {
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+
+	if c.released {
+		return ErrClientClosed
+	}
+
+	if err := c.Err(); nil != err {
+		return err
+	}
+	receipt, wantReceipt := f.Header.Get(HdrReceipt)
+	var ch chan *Frame
+
+	if wantReceipt {
+		ch = make(chan *Frame, 1)
+		c.mu.Lock()
+		c.receipts[receipt] = ch
+		c.mu.Unlock()
+	}
+	removeReceipt := func() {
+		c.mu.Lock()
+		delete(c.receipts, receipt)
+		c.mu.Unlock()
+	}
+
+	if sendErr := c.handle.Send(ctx, f); nil != sendErr {
+		if wantReceipt {
+			removeReceipt()
+		}
+		return sendErr
+	}
+
+	if !wantReceipt {
+		return nil
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-ctx.Done():
+		removeReceipt()
+		return ctx.Err()
+	}
+}
+
+// fail ends the session with err, unless it has already ended.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+
+	if nil != c.err {
+		c.mu.Unlock()
+		return
+	}
+
+	if c.closing {
+		err = ErrClientClosed
+	}
+	c.err = err
+	close(c.done)
+	c.mu.Unlock()
+	c.conn.Close()
+}
+
+func (c *Client) readLoop(beater *heartBeater) {
+	defer c.wg.Done()
+
+	for {
+		f, readErr := c.handle.Receive(context.Background())
+
+		if nil != readErr {
+			if nil == c.Err() {
+				c.log.Error("connection lost", "error", readErr)
+			}
+			c.fail(readErr)
+			return
+		}
+		beater.touch()
+
+		if nil == f {
+			continue
+		}
+		body, bodyErr := bufferBody(f)
+
+		if nil != bodyErr {
+			c.fail(bodyErr)
+			return
+		}
+
+		switch f.Command {
+		case CmdMessage:
+			c.dispatch(f)
+		case CmdReceipt:
+			id, _ := f.Header.Get(HdrReceiptId)
+			c.mu.Lock()
+			ch, ok := c.receipts[id]
+			delete(c.receipts, id)
+			c.mu.Unlock()
+
+			if ok {
+				ch <- f
+			}
+		case CmdError:
+			serverErr := newServerError(f, body)
+			c.log.Error("received error frame", "message", serverErr.Message)
+			c.fail(serverErr)
+			return
+		default:
+			c.log.Warn("received unexpected frame", "command", f.Command.String())
+		}
+	}
+}
+
+// dispatch delivers msg to its subscription. A subscription whose
+// buffer is full is ended and unsubscribed, for the client must go on
+// reading the receipts, errors and heart-beats of the connection.
+func (c *Client) dispatch(msg *Frame) {
+	id, _ := msg.Header.Get(HdrSubscription)
+	c.mu.Lock()
+	sub, ok := c.subs[id]
+	c.mu.Unlock()
+
+	if !ok {
+		c.log.Debug("dropped message for unknown subscription", "subscription", id)
+		return
+	}
+
+	select {
+	case sub.ch <- msg:
+		return
+	default:
+	}
+	c.log.Warn("subscription buffer overflow", "subscription", id, "buffer", cap(sub.ch))
+	c.removeSubscription(sub, ErrSubscriptionOverflow)
+	f := NewFrame(CmdUnsubscribe, nil)
+	f.Header.Set(HdrId, id)
+	c.wg.Add(1)
+
+	go func() {
+		defer c.wg.Done()
+
+		if sendErr := c.send(context.Background(), f); nil != sendErr {
+			c.log.Debug("failed to unsubscribe", "subscription", id, "error", sendErr)
+		}
+	}()
+}
+
+// removeSubscription ends sub, whose Receive then returns err once
+// its buffer is empty.
+func (c *Client) removeSubscription(sub *Subscription, err error) {
+	c.mu.Lock()
+
+	if c.subs[sub.id] == sub {
+		delete(c.subs, sub.id)
+	}
+	c.mu.Unlock()
+	sub.once.Do(func() {
+		sub.err = err
+		close(sub.done)
+	})
+}
+
+func (c *Client) newID(prefix string) string {
+	return prefix + strconv.FormatUint(atomic.AddUint64(&c.nextID, 1), 10)
+}
+
+// A Subscription receives the messages sent to a destination.
+type Subscription struct {
+	c           *Client
+	id          string
+	destination string
+	ack         string
+	ch          chan *Frame
+	done        chan struct{}
+	once        sync.Once
+	err         error
+}
+
+// ID returns the subscription's identifier.
+func (s *Subscription) ID() string {
+	return s.id
+}
+
+// Destination returns the destination subscribed to.
+func (s *Subscription) Destination() string {
+	return s.destination
+}
+
+// AckMode returns the subscription's ack mode.
+func (s *Subscription) AckMode() string {
+	return s.ack
+}
+
+// Buffered returns the number of messages the subscription holds that
+// Receive has yet to return.
+func (s *Subscription) Buffered() int {
+	return len(s.ch)
+}
+
+// Receive returns the next MESSAGE frame for the subscription. The
+// frame's body has been read in full and need not be closed.
+func (s *Subscription) Receive(ctx context.Context) (*Frame, error) {
+	select {
+	case f := <-s.ch:
+		return f, nil
+	default:
+	}
+
+	select {
+	case f := <-s.ch:
+		return f, nil
+	case <-s.done:
+		return nil, s.err
+	case <-s.c.done:
+		return nil, s.c.Err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Unsubscribe ends the subscription.
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	s.c.removeSubscription(s, ErrSubscriptionClosed)
+	f := NewFrame(CmdUnsubscribe, nil)
+	f.Header.Set(HdrId, s.id)
+	return s.c.send(ctx, f)
+}
@@ -0,0 +1,559 @@
+package stomp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoHandler delivers every SEND frame back to the sending session
+// as a MESSAGE frame for each of its subscriptions.
+type echoHandler struct {
+	mu   sync.Mutex
+	subs map[*Session]map[string]string
+	acks []string
+}
+
+func (h *echoHandler) OpenSession(s *Session) error {
+	if "intruder" == s.Login() {
+		return errors.New("access denied")
+	}
+	h.mu.Lock()
+	h.subs[s] = make(map[string]string)
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *echoHandler) CloseSession(s *Session) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+func (h *echoHandler) ServeFrame(s *Session, f *Frame) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch f.Command {
+	case CmdSubscribe:
+		id, _ := f.Header.Get(HdrId)
+		dest, _ := f.Header.Get(HdrDestination)
+		h.subs[s][id] = dest
+	case CmdUnsubscribe:
+		id, _ := f.Header.Get(HdrId)
+		delete(h.subs[s], id)
+	case CmdAck:
+		id, _ := f.Header.Get(HdrId)
+		h.acks = append(h.acks, id)
+	case CmdSend:
+		dest, _ := f.Header.Get(HdrDestination)
+		body, _ := ioutil.ReadAll(f.Body)
+
+		for id, d := range h.subs[s] {
+			if d != dest {
+				continue
+			}
+			msg := NewFrame(CmdMessage, bytes.NewReader(body))
+			msg.Header.Set(HdrDestination, dest)
+			msg.Header.Set(HdrSubscription, id)
+			msg.Header.Set(HdrMessageId, "m-"+string(body))
+			msg.Header.Set(HdrAck, "a-"+string(body))
+
+			if ct, ok := f.Header.Get(HdrContentType); ok {
+				msg.Header.Set(HdrContentType, ct)
+			}
+
+			if sendErr := s.Send(context.Background(), msg); nil != sendErr {
+				return sendErr
+			}
+		}
+	case CmdAbort:
+		return errors.New("nothing to abort")
+	}
+	return nil
+}
+
+func newTestServer(h Handler) (*Server, func(t *testing.T, header Header) (*Client, error)) {
+	srv := &Server{Handler: h, Name: "test/1.0"}
+
+	connect := func(t *testing.T, header Header) (*Client, error) {
+		serverConn, clientConn := net.Pipe()
+		go srv.ServeConn(serverConn)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return Connect(ctx, clientConn, header, nil)
+	}
+	return srv, connect
+}
+
+func TestClientServer(t *testing.T) {
+	handler := &echoHandler{subs: make(map[*Session]map[string]string)}
+	srv, connect := newTestServer(handler)
+	defer srv.Close()
+
+	client, connectErr := connect(t, Header{HdrLogin: {"guest"}, HdrPasscode: {"guest"}})
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+
+	if client.Version() != "1.2" || client.Server() != "test/1.0" || client.Session() == "" {
+		t.Errorf("version = %q, server = %q, session = %q", client.Version(), client.Server(), client.Session())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, subErr := client.Subscribe(ctx, "/queue/a", Header{HdrAck: {AckClientIndividual}, HdrReceipt: {"r-1"}})
+
+	if nil != subErr {
+		t.Fatal(subErr)
+	}
+	f := NewFrame(CmdSend, bytes.NewBufferString("1"))
+	f.Header.Set(HdrReceipt, "r-2")
+
+	if sendErr := client.Send(ctx, "/queue/a", f); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+	msg, readErr := sub.Receive(ctx)
+
+	if nil != readErr {
+		t.Fatal(readErr)
+	}
+	body, _ := ioutil.ReadAll(msg.Body)
+
+	if string(body) != "1" {
+		t.Errorf("body = %q", body)
+	}
+
+	if ackErr := client.Ack(ctx, msg); nil != ackErr {
+		t.Fatal(ackErr)
+	}
+
+	if unsubErr := sub.Unsubscribe(ctx); nil != unsubErr {
+		t.Fatal(unsubErr)
+	}
+
+	if _, readErr = sub.Receive(ctx); ErrSubscriptionClosed != readErr {
+		t.Errorf("expected %v, got %v", ErrSubscriptionClosed, readErr)
+	}
+
+	if disconnectErr := client.Disconnect(ctx); nil != disconnectErr {
+		t.Fatal(disconnectErr)
+	}
+
+	if ErrClientClosed != client.Err() {
+		t.Errorf("expected %v, got %v", ErrClientClosed, client.Err())
+	}
+
+	if sendErr := client.Send(ctx, "/queue/a", nil); ErrClientClosed != sendErr {
+		t.Errorf("expected %v, got %v", ErrClientClosed, sendErr)
+	}
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+
+	if len(handler.acks) != 1 || handler.acks[0] != "a-1" {
+		t.Errorf("acks = %v", handler.acks)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	handler := &echoHandler{subs: make(map[*Session]map[string]string)}
+	srv, connect := newTestServer(handler)
+	defer srv.Close()
+
+	_, connectErr := connect(t, Header{HdrLogin: {"intruder"}})
+	var serverErr *ServerError
+
+	if !errors.As(connectErr, &serverErr) || serverErr.Message != "access denied" {
+		t.Fatalf("expected access denied, got %v", connectErr)
+	}
+
+	_, connectErr = connect(t, Header{HdrAcceptVersion: {"2.0"}})
+
+	if !errors.As(connectErr, &serverErr) {
+		t.Fatalf("expected a server error, got %v", connectErr)
+	}
+
+	if v, _ := serverErr.Header.Get(HdrVersion); v != "1.0,1.1,1.2" {
+		t.Errorf("version = %q", v)
+	}
+	client, connectErr := connect(t, Header{HdrAcceptVersion: {"1.0,1.1"}})
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer client.Close()
+
+	if client.Version() != "1.1" {
+		t.Errorf("version = %q", client.Version())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	f := NewFrame(CmdAbort, nil)
+	f.Header.Set(HdrTransaction, "tx")
+	f.Header.Set(HdrReceipt, "r-1")
+
+	if sendErr := client.send(ctx, f); !errors.As(sendErr, &serverErr) {
+		t.Fatalf("expected a server error, got %v", sendErr)
+	}
+
+	if id, _ := serverErr.Header.Get(HdrReceiptId); id != "r-1" {
+		t.Errorf("receipt-id = %q", id)
+	}
+
+	select {
+	case <-client.Done():
+	case <-ctx.Done():
+		t.Fatal("session did not end after ERROR frame")
+	}
+}
+
+func TestHeartBeat(t *testing.T) {
+	srv := &Server{Handler: HandlerFunc(func(s *Session, f *Frame) error { return nil }), HeartBeat: 20 * time.Millisecond}
+	defer srv.Close()
+
+	serverConn, clientConn := net.Pipe()
+	go srv.ServeConn(serverConn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, connectErr := Connect(ctx, clientConn, Header{HdrHeartBeat: {"20,20"}}, nil)
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer client.Close()
+
+	select {
+	case <-client.Done():
+		t.Fatalf("session ended despite heart-beats: %v", client.Err())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	var mu sync.Mutex
+	var frames []*Frame
+
+	srv, connect := newTestServer(HandlerFunc(func(s *Session, f *Frame) error {
+		mu.Lock()
+		frames = append(frames, f)
+		mu.Unlock()
+		return nil
+	}))
+	defer srv.Close()
+
+	client, connectErr := connect(t, nil)
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, beginErr := client.Begin(ctx)
+
+	if nil != beginErr {
+		t.Fatal(beginErr)
+	}
+
+	if sendErr := tx.Send(ctx, "/queue/a", nil); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+
+	if commitErr := tx.Commit(ctx); nil != commitErr {
+		t.Fatal(commitErr)
+	}
+
+	if sendErr := tx.Send(ctx, "/queue/a", nil); ErrTransactionDone != sendErr {
+		t.Errorf("expected %v, got %v", ErrTransactionDone, sendErr)
+	}
+
+	if abortErr := tx.Abort(ctx); ErrTransactionDone != abortErr {
+		t.Errorf("expected %v, got %v", ErrTransactionDone, abortErr)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := []Command{CmdBegin, CmdSend, CmdCommit}
+
+	if len(frames) != len(want) {
+		t.Fatalf("received %d frames, want %d", len(frames), len(want))
+	}
+
+	for i, f := range frames {
+		if f.Command != want[i] {
+			t.Errorf("frame %d: command = %s, want %s", i, f.Command, want[i])
+		}
+
+		if id, _ := f.Header.Get(HdrTransaction); id != tx.ID() {
+			t.Errorf("frame %d: transaction = %q, want %q", i, id, tx.ID())
+		}
+	}
+}
+
+// windowHandler delivers the messages sent to its one subscription
+// no faster than the subscription's prefetch-count header allows.
+type windowHandler struct {
+	mu      sync.Mutex
+	session *Session
+	sub     string
+	window  int
+	unacked int
+	sent    int
+	pending [][]byte
+}
+
+func (h *windowHandler) ServeFrame(s *Session, f *Frame) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch f.Command {
+	case CmdSubscribe:
+		h.session = s
+		h.sub, _ = f.Header.Get(HdrId)
+		v, _ := f.Header.Get(HdrPrefetchCount)
+		h.window, _ = strconv.Atoi(v)
+	case CmdSend:
+		body, _ := ioutil.ReadAll(f.Body)
+		h.pending = append(h.pending, body)
+	case CmdAck:
+		h.unacked--
+	}
+
+	for 0 != len(h.pending) && nil != h.session && (0 == h.window || h.unacked < h.window) {
+		h.sent++
+		msg := NewFrame(CmdMessage, bytes.NewReader(h.pending[0]))
+		msg.Header.Set(HdrSubscription, h.sub)
+		msg.Header.Set(HdrMessageId, "m-"+strconv.Itoa(h.sent))
+		msg.Header.Set(HdrAck, "a-"+strconv.Itoa(h.sent))
+		h.pending = h.pending[1:]
+		h.unacked++
+
+		if sendErr := h.session.Send(context.Background(), msg); nil != sendErr {
+			return sendErr
+		}
+	}
+	return nil
+}
+
+// Sent returns the number of messages the handler has delivered.
+func (h *windowHandler) Sent() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sent
+}
+
+func TestSubscriptionBuffer(t *testing.T) {
+	tests := []struct {
+		header Header
+		sent   int
+	}{
+		// The buffer is the prefetch count: the server holds the
+		// third message back until the first is received and
+		// acknowledged.
+		{Header{HdrAck: {AckClientIndividual}}, 2},
+
+		// A larger prefetch count is honoured by a larger buffer.
+		{Header{HdrAck: {AckClientIndividual}, HdrPrefetchCount: {"3"}}, 3},
+	}
+
+	for _, test := range tests {
+		handler := &windowHandler{}
+		srv := &Server{Handler: handler}
+		serverConn, clientConn := net.Pipe()
+		go srv.ServeConn(serverConn)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		client, connectErr := Connect(ctx, clientConn, nil, &ClientOptions{SubscriptionBuffer: 2})
+
+		if nil != connectErr {
+			t.Fatal(connectErr)
+		}
+		sub, subErr := client.Subscribe(ctx, "/queue/a", test.header)
+
+		if nil != subErr {
+			t.Fatal(subErr)
+		}
+
+		for _, body := range []string{"1", "2", "3"} {
+			f := NewFrame(CmdSend, strings.NewReader(body))
+			f.Header.Set(HdrReceipt, "sent")
+
+			if sendErr := client.Send(ctx, "/queue/a", f); nil != sendErr {
+				t.Fatal(sendErr)
+			}
+		}
+
+		if handler.Sent() != test.sent {
+			t.Errorf("%v: %d messages sent, expected %d", test.header, handler.Sent(), test.sent)
+		}
+
+		for _, want := range []string{"1", "2", "3"} {
+			msg, receiveErr := sub.Receive(ctx)
+
+			if nil != receiveErr {
+				t.Fatalf("%v: %v", test.header, receiveErr)
+			}
+			body, _ := ioutil.ReadAll(msg.Body)
+
+			if want != string(body) {
+				t.Errorf("%v: received %q, expected %q", test.header, body, want)
+			}
+
+			if ackErr := client.Ack(ctx, msg); nil != ackErr {
+				t.Fatal(ackErr)
+			}
+		}
+		client.Close()
+		srv.Close()
+		cancel()
+	}
+}
+
+func TestSubscriptionOverflow(t *testing.T) {
+	handler := &echoHandler{subs: make(map[*Session]map[string]string)}
+	srv := &Server{Handler: handler, HeartBeat: 20 * time.Millisecond}
+	defer srv.Close()
+	serverConn, clientConn := net.Pipe()
+	go srv.ServeConn(serverConn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, connectErr := Connect(ctx, clientConn, Header{HdrHeartBeat: {"20,20"}}, &ClientOptions{SubscriptionBuffer: 1})
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer client.Close()
+	full, subErr := client.Subscribe(ctx, "/queue/full", nil)
+
+	if nil != subErr {
+		t.Fatal(subErr)
+	}
+	other, subErr := client.Subscribe(ctx, "/queue/other", Header{HdrReceipt: {"other"}})
+
+	if nil != subErr {
+		t.Fatal(subErr)
+	}
+
+	for _, body := range []string{"1", "2"} {
+		if sendErr := client.Send(ctx, "/queue/full", NewFrame(CmdSend, strings.NewReader(body))); nil != sendErr {
+			t.Fatal(sendErr)
+		}
+	}
+
+	// The client goes on reading heart-beats, receipts and the
+	// messages of other subscriptions while the buffer is full.
+	time.Sleep(100 * time.Millisecond)
+	tx, beginErr := client.Begin(ctx)
+
+	if nil != beginErr {
+		t.Fatal(beginErr)
+	}
+
+	if sendErr := tx.Send(ctx, "/queue/other", NewFrame(CmdSend, strings.NewReader("3"))); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+
+	if commitErr := tx.Commit(ctx); nil != commitErr {
+		t.Fatal(commitErr)
+	}
+
+	msg, receiveErr := other.Receive(ctx)
+
+	if nil != receiveErr {
+		t.Fatal(receiveErr)
+	}
+
+	if body, _ := ioutil.ReadAll(msg.Body); "3" != string(body) {
+		t.Errorf("received %q", body)
+	}
+
+	if _, receiveErr = full.Receive(ctx); nil != receiveErr {
+		t.Fatal(receiveErr)
+	}
+
+	if _, receiveErr := full.Receive(ctx); ErrSubscriptionOverflow != receiveErr {
+		t.Errorf("expected %v, got %v", ErrSubscriptionOverflow, receiveErr)
+	}
+
+	if err := client.Err(); nil != err {
+		t.Errorf("session ended with %v", err)
+	}
+}
+
+func TestSendFrameWithoutHeader(t *testing.T) {
+	var mu sync.Mutex
+	var frames []*Frame
+
+	srv, connect := newTestServer(HandlerFunc(func(s *Session, f *Frame) error {
+		mu.Lock()
+		frames = append(frames, f)
+		mu.Unlock()
+		return nil
+	}))
+	defer srv.Close()
+
+	client, connectErr := connect(t, nil)
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, beginErr := client.Begin(ctx)
+
+	if nil != beginErr {
+		t.Fatal(beginErr)
+	}
+	req := NewFrame(CmdMessage, nil)
+	req.Header.Set(HdrReplyTo, "/queue/replies")
+
+	for _, send := range []func(f *Frame) error{
+		func(f *Frame) error { return client.Send(ctx, "/queue/a", f) },
+		func(f *Frame) error { return client.SendAfter(ctx, "/queue/a", f, time.Second) },
+		func(f *Frame) error { return client.SendAt(ctx, "/queue/a", f, time.Now()) },
+		func(f *Frame) error { return tx.Send(ctx, "/queue/a", f) },
+		func(f *Frame) error { return client.Reply(ctx, req, f) },
+	} {
+		if sendErr := send(&Frame{Command: CmdSend}); nil != sendErr {
+			t.Fatal(sendErr)
+		}
+	}
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+
+	if _, requestErr := client.Request(shortCtx, "/queue/a", &Frame{Command: CmdSend}); context.DeadlineExceeded != requestErr {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, requestErr)
+	}
+
+	if commitErr := tx.Commit(ctx); nil != commitErr {
+		t.Fatal(commitErr)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	sends := 0
+
+	for _, f := range frames {
+		if CmdSend == f.Command {
+			sends++
+		}
+	}
+
+	if 6 != sends {
+		t.Errorf("server received %d SEND frames, expected 6", sends)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBucketBits sets the precision of a histogram: every power of two
+// range of values is divided into 1<<subBucketBits buckets, bounding
+// the relative error of a recorded value to under 1%.
+const subBucketBits = 7
+
+const subBucketCount = 1 << subBucketBits
+
+// A histogram records durations in logarithmic buckets of linear sub
+// buckets, in the manner of an HDR histogram. It uses a fixed amount
+// of memory regardless of the range and number of values recorded.
+// A histogram is not thread safe.
+type histogram struct {
+	counts [(64 - subBucketBits + 1) * subBucketCount]uint64
+	total  uint64
+	sum    float64
+	min    int64
+	max    int64
+}
+
+func bucketIndex(v int64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits - 1
+	return (shift+1)*subBucketCount + int(v>>uint(shift)) - subBucketCount
+}
+
+// bucketHigh returns the highest value recorded in the bucket at ndx.
+func bucketHigh(ndx int) int64 {
+	if ndx < subBucketCount {
+		return int64(ndx)
+	}
+	shift := uint(ndx/subBucketCount - 1)
+	mantissa := int64(ndx%subBucketCount + subBucketCount)
+	return (mantissa+1)<<shift - 1
+}
+
+// Record adds d to the histogram. Negative durations are recorded
+// as zero.
+func (h *histogram) Record(d time.Duration) {
+	v := int64(d)
+
+	if v < 0 {
+		v = 0
+	}
+
+	if 0 == h.total || v < h.min {
+		h.min = v
+	}
+
+	if v > h.max {
+		h.max = v
+	}
+	h.counts[bucketIndex(v)]++
+	h.total++
+	h.sum += float64(v)
+}
+
+// Merge adds the values recorded by o to the histogram.
+func (h *histogram) Merge(o *histogram) {
+	if 0 == o.total {
+		return
+	}
+
+	if 0 == h.total || o.min < h.min {
+		h.min = o.min
+	}
+
+	if o.max > h.max {
+		h.max = o.max
+	}
+
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	h.total += o.total
+	h.sum += o.sum
+}
+
+// Count returns the number of values recorded.
+func (h *histogram) Count() uint64 {
+	return h.total
+}
+
+// Min returns the smallest value recorded.
+func (h *histogram) Min() time.Duration {
+	return time.Duration(h.min)
+}
+
+// Max returns the largest value recorded.
+func (h *histogram) Max() time.Duration {
+	return time.Duration(h.max)
+}
+
+// Mean returns the arithmetic mean of the values recorded.
+func (h *histogram) Mean() time.Duration {
+	if 0 == h.total {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.total))
+}
+
+// Percentile returns the value below which the given percentage of
+// the recorded values fall, to within the histogram's precision.
+func (h *histogram) Percentile(p float64) time.Duration {
+	if 0 == h.total {
+		return 0
+	}
+	rank := uint64(math.Ceil(p / 100 * float64(h.total)))
+
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+
+	for i, n := range h.counts {
+		seen += n
+
+		if seen >= rank {
+			v := bucketHigh(i)
+
+			if v > h.max {
+				v = h.max
+			}
+			return time.Duration(v)
+		}
+	}
+	return time.Duration(h.max)
+}
@@ -0,0 +1,559 @@
+// Command stompbench generates load against a STOMP broker and
+// reports the throughput and latency it observes.
+//
+// Usage:
+//
+//	stompbench [flags]
+//
+// stompbench connects -consumers clients that subscribe to
+// -destination, then -producers clients that send messages of -size
+// bytes to it, each at up to -rate messages per second, until each
+// has sent -n messages or -duration has passed. Once the producers
+// finish, the consumers run until every message has arrived or none
+// has arrived for -drain. Latency is measured from the moment a
+// message is sent until it is received, and is reported as
+// percentiles of a high dynamic range histogram.
+//
+// With -tx, producers send, and consumers acknowledge, in
+// transactions of the given number of messages. With -json, the
+// report is written as a JSON object.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jjware/stomp"
+	"github.com/jjware/stomp/internal/dial"
+)
+
+// hdrSent carries the time a message was sent, in nanoseconds since
+// the Unix epoch.
+const hdrSent = "stompbench-sent"
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+type config struct {
+	url         string
+	login       string
+	passcode    string
+	vhost       string
+	insecure    bool
+	destination string
+	producers   int
+	consumers   int
+	size        int
+	rate        float64
+	count       int
+	duration    time.Duration
+	drain       time.Duration
+	ack         string
+	txSize      int
+	receipt     bool
+	json        bool
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	var cfg config
+	fs := flag.NewFlagSet("stompbench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&cfg.url, "url", "stomp://localhost:61613", "broker `URL`; schemes stomp, tcp, stomp+ssl, stomps and ssl are understood")
+	fs.StringVar(&cfg.login, "login", "", "login, overriding the URL's user")
+	fs.StringVar(&cfg.passcode, "passcode", "", "passcode, overriding the URL's password")
+	fs.StringVar(&cfg.vhost, "vhost", "", "value of the CONNECT host header; defaults to the URL's path, or its host name")
+	fs.BoolVar(&cfg.insecure, "insecure", false, "skip verification of the server certificate")
+	fs.StringVar(&cfg.destination, "destination", "/queue/stompbench", "destination to send to and subscribe to")
+	fs.IntVar(&cfg.producers, "producers", 1, "number of producing clients")
+	fs.IntVar(&cfg.consumers, "consumers", 1, "number of consuming clients")
+	fs.IntVar(&cfg.size, "size", 1024, "message body size in `bytes`")
+	fs.Float64Var(&cfg.rate, "rate", 0, "messages per second sent by each producer; 0 means as fast as possible")
+	fs.IntVar(&cfg.count, "n", 0, "messages sent by each producer; 0 means until -duration has passed")
+	fs.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long producers send for when -n is 0")
+	fs.DurationVar(&cfg.drain, "drain", 2*time.Second, "how long consumers wait for further messages once producers finish")
+	fs.StringVar(&cfg.ack, "ack", stomp.AckAuto, "subscription ack mode: auto, client or client-individual")
+	fs.IntVar(&cfg.txSize, "tx", 0, "messages per transaction; 0 disables transactions")
+	fs.BoolVar(&cfg.receipt, "receipt", false, "wait for a receipt for every message sent")
+	fs.BoolVar(&cfg.json, "json", false, "write the report as JSON")
+
+	if parseErr := fs.Parse(args); nil != parseErr {
+		return 2
+	}
+
+	if fs.NArg() > 0 || cfg.producers < 0 || cfg.consumers < 0 || cfg.size < 0 || cfg.txSize < 0 {
+		fs.Usage()
+		return 2
+	}
+
+	switch cfg.ack {
+	case stomp.AckAuto, stomp.AckClient, stomp.AckClientIndividual:
+	default:
+		fmt.Fprintf(stderr, "stompbench: unknown ack mode %q\n", cfg.ack)
+		return 2
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	res, benchErr := bench(ctx, &cfg)
+
+	if nil != benchErr {
+		fmt.Fprintln(stderr, "stompbench:", benchErr)
+		return 1
+	}
+	var writeErr error
+
+	if cfg.json {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		writeErr = enc.Encode(res)
+	} else {
+		writeErr = res.writeText(stdout)
+	}
+
+	if nil != writeErr {
+		fmt.Fprintln(stderr, "stompbench:", writeErr)
+		return 1
+	}
+
+	if "" != res.Error {
+		return 1
+	}
+	return 0
+}
+
+// A latency summarizes a latency histogram, in microseconds.
+type latency struct {
+	Min  float64 `json:"min_us"`
+	Mean float64 `json:"mean_us"`
+	P50  float64 `json:"p50_us"`
+	P90  float64 `json:"p90_us"`
+	P99  float64 `json:"p99_us"`
+	P999 float64 `json:"p99_9_us"`
+	Max  float64 `json:"max_us"`
+}
+
+func summarize(h *histogram) latency {
+	us := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Microsecond)
+	}
+
+	return latency{
+		Min:  us(h.Min()),
+		Mean: us(h.Mean()),
+		P50:  us(h.Percentile(50)),
+		P90:  us(h.Percentile(90)),
+		P99:  us(h.Percentile(99)),
+		P999: us(h.Percentile(99.9)),
+		Max:  us(h.Max()),
+	}
+}
+
+// A result is the outcome of a benchmark run.
+type result struct {
+	Destination string  `json:"destination"`
+	Producers   int     `json:"producers"`
+	Consumers   int     `json:"consumers"`
+	Size        int     `json:"size"`
+	AckMode     string  `json:"ack_mode"`
+	TxSize      int     `json:"tx_size"`
+	Sent        uint64  `json:"sent"`
+	Received    uint64  `json:"received"`
+	Elapsed     float64 `json:"elapsed_s"`
+	SendRate    float64 `json:"send_rate"`
+	ReceiveRate float64 `json:"receive_rate"`
+	Throughput  float64 `json:"throughput_mib_s"`
+	Latency     latency `json:"latency"`
+	Error       string  `json:"error,omitempty"`
+}
+
+func (r *result) writeText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "destination\t%s\n", r.Destination)
+	fmt.Fprintf(tw, "producers\t%d\n", r.Producers)
+	fmt.Fprintf(tw, "consumers\t%d\n", r.Consumers)
+	fmt.Fprintf(tw, "message size\t%d B\n", r.Size)
+	fmt.Fprintf(tw, "ack mode\t%s\n", r.AckMode)
+
+	if r.TxSize > 0 {
+		fmt.Fprintf(tw, "transaction size\t%d\n", r.TxSize)
+	}
+	fmt.Fprintf(tw, "elapsed\t%.3f s\n", r.Elapsed)
+	fmt.Fprintf(tw, "sent\t%d msgs\t%.1f msgs/s\n", r.Sent, r.SendRate)
+	fmt.Fprintf(tw, "received\t%d msgs\t%.1f msgs/s\t%.2f MiB/s\n", r.Received, r.ReceiveRate, r.Throughput)
+
+	for _, row := range []struct {
+		name string
+		v    float64
+	}{
+		{"min", r.Latency.Min},
+		{"mean", r.Latency.Mean},
+		{"p50", r.Latency.P50},
+		{"p90", r.Latency.P90},
+		{"p99", r.Latency.P99},
+		{"p99.9", r.Latency.P999},
+		{"max", r.Latency.Max},
+	} {
+		fmt.Fprintf(tw, "latency %s\t%.1f µs\n", row.name, row.v)
+	}
+
+	if "" != r.Error {
+		fmt.Fprintf(tw, "error\t%s\n", r.Error)
+	}
+	return tw.Flush()
+}
+
+// errorOnce keeps the first error reported by the workers.
+type errorOnce struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (e *errorOnce) set(err error) {
+	e.mu.Lock()
+
+	if nil == e.err {
+		e.err = err
+	}
+	e.mu.Unlock()
+}
+
+func (e *errorOnce) get() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+func bench(ctx context.Context, cfg *config) (*result, error) {
+	var clients []*stomp.Client
+
+	defer func() {
+		for _, c := range clients {
+			c.Close()
+		}
+	}()
+	consumers := make([]*consumer, cfg.consumers)
+
+	for i := range consumers {
+		client, connectErr := connect(ctx, cfg)
+
+		if nil != connectErr {
+			return nil, connectErr
+		}
+		clients = append(clients, client)
+		sub, subErr := client.Subscribe(ctx, cfg.destination, stomp.Header{
+			stomp.HdrAck:     {cfg.ack},
+			stomp.HdrReceipt: {"subscribe"},
+		})
+
+		if nil != subErr {
+			return nil, subErr
+		}
+		consumers[i] = &consumer{client: client, sub: sub, cfg: cfg}
+	}
+	producers := make([]*producer, cfg.producers)
+
+	for i := range producers {
+		client, connectErr := connect(ctx, cfg)
+
+		if nil != connectErr {
+			return nil, connectErr
+		}
+		clients = append(clients, client)
+		producers[i] = &producer{client: client, cfg: cfg}
+	}
+	var failure errorOnce
+	var received uint64
+
+	consumeCtx, stopConsumers := context.WithCancel(ctx)
+	defer stopConsumers()
+
+	var consumersDone sync.WaitGroup
+	consumersDone.Add(len(consumers))
+
+	for _, c := range consumers {
+		go func(c *consumer) {
+			defer consumersDone.Done()
+
+			if consumeErr := c.run(consumeCtx, &received); nil != consumeErr && nil == consumeCtx.Err() {
+				failure.set(consumeErr)
+				stopConsumers()
+			}
+		}(c)
+	}
+	start := time.Now()
+	deadline := start.Add(cfg.duration)
+	var producersDone sync.WaitGroup
+	producersDone.Add(len(producers))
+
+	for _, p := range producers {
+		go func(p *producer) {
+			defer producersDone.Done()
+
+			if produceErr := p.run(ctx, start, deadline); nil != produceErr && nil == ctx.Err() {
+				failure.set(produceErr)
+			}
+		}(p)
+	}
+	producersDone.Wait()
+
+	var sent uint64
+
+	for _, p := range producers {
+		sent += p.sent
+	}
+	expected := sent
+
+	if strings.HasPrefix(cfg.destination, "/topic/") {
+		expected *= uint64(cfg.consumers)
+	}
+
+	if 0 == cfg.consumers {
+		expected = 0
+	}
+	last, idleSince := atomic.LoadUint64(&received), time.Now()
+	ticker := time.NewTicker(10 * time.Millisecond)
+
+	for last < expected && time.Since(idleSince) < cfg.drain && nil == consumeCtx.Err() {
+		<-ticker.C
+
+		if n := atomic.LoadUint64(&received); n != last {
+			last, idleSince = n, time.Now()
+		}
+	}
+	ticker.Stop()
+
+	// The run ends with the last message received, or with the
+	// producers if no message arrived after they finished.
+	elapsed := idleSince.Sub(start)
+	stopConsumers()
+	consumersDone.Wait()
+
+	var latencies histogram
+
+	for _, c := range consumers {
+		latencies.Merge(&c.latency)
+	}
+
+	for _, client := range clients {
+		disconnectCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		client.Disconnect(disconnectCtx)
+		cancel()
+	}
+	seconds := elapsed.Seconds()
+
+	res := &result{
+		Destination: cfg.destination,
+		Producers:   cfg.producers,
+		Consumers:   cfg.consumers,
+		Size:        cfg.size,
+		AckMode:     cfg.ack,
+		TxSize:      cfg.txSize,
+		Sent:        sent,
+		Received:    atomic.LoadUint64(&received),
+		Elapsed:     seconds,
+		Latency:     summarize(&latencies),
+	}
+
+	if seconds > 0 {
+		res.SendRate = float64(res.Sent) / seconds
+		res.ReceiveRate = float64(res.Received) / seconds
+		res.Throughput = float64(res.Received) * float64(cfg.size) / seconds / (1 << 20)
+	}
+
+	if err := failure.get(); nil != err {
+		res.Error = err.Error()
+	} else if nil != ctx.Err() {
+		res.Error = ctx.Err().Error()
+	}
+	return res, nil
+}
+
+// A producer sends messages from a single client.
+type producer struct {
+	client *stomp.Client
+	cfg    *config
+	sent   uint64
+}
+
+func (p *producer) run(ctx context.Context, start, deadline time.Time) error {
+	body := bytes.Repeat([]byte("x"), p.cfg.size)
+	var interval time.Duration
+
+	if p.cfg.rate > 0 {
+		interval = time.Duration(float64(time.Second) / p.cfg.rate)
+	}
+	var tx *stomp.Transaction
+	var inTx int
+
+	for i := 0; 0 == p.cfg.count || i < p.cfg.count; i++ {
+		if 0 == p.cfg.count && !time.Now().Before(deadline) {
+			break
+		}
+
+		if interval > 0 {
+			if wait := time.Until(start.Add(time.Duration(i) * interval)); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		f := stomp.NewFrame(stomp.CmdSend, bytes.NewReader(body))
+
+		if p.cfg.receipt {
+			f.Header.Set(stomp.HdrReceipt, "r-"+strconv.Itoa(i))
+		}
+
+		if p.cfg.txSize > 0 && nil == tx {
+			var beginErr error
+			tx, beginErr = p.client.Begin(ctx)
+
+			if nil != beginErr {
+				return beginErr
+			}
+		}
+		f.Header.Set(hdrSent, strconv.FormatInt(time.Now().UnixNano(), 10))
+		var sendErr error
+
+		if nil != tx {
+			sendErr = tx.Send(ctx, p.cfg.destination, f)
+		} else {
+			sendErr = p.client.Send(ctx, p.cfg.destination, f)
+		}
+
+		if nil != sendErr {
+			return sendErr
+		}
+
+		if nil == tx {
+			p.sent++
+			continue
+		}
+		inTx++
+
+		if inTx == p.cfg.txSize {
+			if commitErr := tx.Commit(ctx); nil != commitErr {
+				return commitErr
+			}
+			p.sent += uint64(inTx)
+			tx, inTx = nil, 0
+		}
+	}
+
+	if nil != tx {
+		if commitErr := tx.Commit(ctx); nil != commitErr {
+			return commitErr
+		}
+		p.sent += uint64(inTx)
+	}
+	return nil
+}
+
+// A consumer receives messages on a single subscription.
+type consumer struct {
+	client  *stomp.Client
+	sub     *stomp.Subscription
+	cfg     *config
+	latency histogram
+}
+
+func (c *consumer) run(ctx context.Context, received *uint64) error {
+	var tx *stomp.Transaction
+	var inTx int
+
+	defer func() {
+		if nil != tx {
+			commitCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			tx.Commit(commitCtx)
+			cancel()
+		}
+	}()
+
+	for {
+		msg, readErr := c.sub.Receive(ctx)
+
+		if nil != readErr {
+			return readErr
+		}
+
+		if v, ok := msg.Header.Get(hdrSent); ok {
+			if sent, parseErr := strconv.ParseInt(v, 10, 64); nil == parseErr {
+				c.latency.Record(time.Since(time.Unix(0, sent)))
+			}
+		}
+		atomic.AddUint64(received, 1)
+
+		if stomp.AckAuto == c.cfg.ack {
+			continue
+		}
+
+		if 0 == c.cfg.txSize {
+			if ackErr := c.client.Ack(ctx, msg); nil != ackErr {
+				return ackErr
+			}
+			continue
+		}
+
+		if nil == tx {
+			var beginErr error
+			tx, beginErr = c.client.Begin(ctx)
+
+			if nil != beginErr {
+				return beginErr
+			}
+		}
+
+		if ackErr := tx.Ack(ctx, msg); nil != ackErr {
+			return ackErr
+		}
+		inTx++
+
+		if inTx == c.cfg.txSize {
+			commitErr := tx.Commit(ctx)
+			tx, inTx = nil, 0
+
+			if nil != commitErr {
+				return commitErr
+			}
+		}
+	}
+}
+
+// connect dials the broker and performs the STOMP handshake.
+func connect(ctx context.Context, cfg *config) (*stomp.Client, error) {
+	return dial.Connect(ctx, dial.Options{
+		URL:      cfg.url,
+		Login:    cfg.login,
+		Passcode: cfg.passcode,
+		VHost:    cfg.vhost,
+		TLSConfig: func(serverName string) (*tls.Config, error) {
+			return &tls.Config{ServerName: serverName, InsecureSkipVerify: cfg.insecure}, nil
+		},
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+func TestHistogram(t *testing.T) {
+	var h histogram
+
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+
+	if h.Count() != 10000 || h.Min() != time.Microsecond || h.Max() != 10*time.Millisecond {
+		t.Fatalf("count = %d, min = %v, max = %v", h.Count(), h.Min(), h.Max())
+	}
+
+	for _, tc := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 5 * time.Millisecond},
+		{90, 9 * time.Millisecond},
+		{99, 9900 * time.Microsecond},
+		{100, 10 * time.Millisecond},
+	} {
+		have := h.Percentile(tc.p)
+
+		if have < tc.want || float64(have-tc.want) > float64(tc.want)/subBucketCount {
+			t.Errorf("p%v = %v, want %v", tc.p, have, tc.want)
+		}
+	}
+	var merged histogram
+	merged.Record(time.Nanosecond)
+	merged.Merge(&h)
+
+	if merged.Count() != 10001 || merged.Min() != time.Nanosecond || merged.Max() != h.Max() {
+		t.Errorf("count = %d, min = %v, max = %v", merged.Count(), merged.Min(), merged.Max())
+	}
+
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, 1000, 123456789, 1<<63 - 1} {
+		if high := bucketHigh(bucketIndex(v)); high < v {
+			t.Errorf("bucket of %d ends at %d", v, high)
+		}
+	}
+}
+
+// queue delivers each SEND frame to one subscriber of its destination
+// in turn, holding transactional frames until they are committed.
+type queue struct {
+	mu      sync.Mutex
+	subs    map[*stomp.Session]string
+	order   []*stomp.Session
+	next    int
+	pending map[string][][]byte
+}
+
+func (q *queue) OpenSession(s *stomp.Session) error {
+	return nil
+}
+
+func (q *queue) CloseSession(s *stomp.Session) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.subs, s)
+
+	for i, o := range q.order {
+		if o == s {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (q *queue) ServeFrame(s *stomp.Session, f *stomp.Frame) error {
+	tx, _ := f.Header.Get(stomp.HdrTransaction)
+	var deliver [][]byte
+
+	q.mu.Lock()
+
+	switch f.Command {
+	case stomp.CmdSubscribe:
+		id, _ := f.Header.Get(stomp.HdrId)
+		q.subs[s] = id
+		q.order = append(q.order, s)
+	case stomp.CmdSend:
+		body, _ := ioutil.ReadAll(f.Body)
+		sent, _ := f.Header.Get(hdrSent)
+		msg := append([]byte(sent+"\n"), body...)
+
+		if "" != tx {
+			q.pending[tx] = append(q.pending[tx], msg)
+		} else {
+			deliver = append(deliver, msg)
+		}
+	case stomp.CmdCommit:
+		deliver = q.pending[tx]
+		delete(q.pending, tx)
+	case stomp.CmdAbort:
+		delete(q.pending, tx)
+	}
+	var targets []*stomp.Session
+
+	for range deliver {
+		if 0 == len(q.order) {
+			break
+		}
+		targets = append(targets, q.order[q.next%len(q.order)])
+		q.next++
+	}
+	subs := make(map[*stomp.Session]string, len(q.subs))
+
+	for s, id := range q.subs {
+		subs[s] = id
+	}
+	q.mu.Unlock()
+
+	for i, target := range targets {
+		split := bytes.IndexByte(deliver[i], '\n')
+		msg := stomp.NewFrame(stomp.CmdMessage, bytes.NewReader(deliver[i][split+1:]))
+		msg.Header.Set(stomp.HdrSubscription, subs[target])
+		msg.Header.Set(stomp.HdrMessageId, "m")
+		msg.Header.Set(stomp.HdrAck, "a")
+		msg.Header.Set(hdrSent, string(deliver[i][:split]))
+
+		if sendErr := target.Send(context.Background(), msg); nil != sendErr {
+			return sendErr
+		}
+	}
+	return nil
+}
+
+func TestBench(t *testing.T) {
+	l, listenErr := net.Listen("tcp", "127.0.0.1:0")
+
+	if nil != listenErr {
+		t.Fatal(listenErr)
+	}
+	srv := &stomp.Server{Handler: &queue{
+		subs:    make(map[*stomp.Session]string),
+		pending: make(map[string][][]byte),
+	}}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{
+		"-url", "stomp://" + l.Addr().String(),
+		"-producers", "2", "-consumers", "2", "-n", "50", "-size", "64",
+		"-ack", "client", "-tx", "7", "-rate", "5000", "-json",
+	}
+
+	if code := run(args, &stdout, &stderr); 0 != code {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	var res result
+
+	if decodeErr := json.Unmarshal(stdout.Bytes(), &res); nil != decodeErr {
+		t.Fatalf("%v: %s", decodeErr, stdout.String())
+	}
+
+	if res.Sent != 100 || res.Received != 100 {
+		t.Errorf("sent = %d, received = %d", res.Sent, res.Received)
+	}
+
+	if res.Latency.Max <= 0 || res.Latency.P50 > res.Latency.Max || res.SendRate <= 0 {
+		t.Errorf("unexpected report %+v", res)
+	}
+	stdout.Reset()
+
+	if code := run([]string{"-url", "stomp://" + l.Addr().String(), "-n", "10", "-size", "8"}, &stdout, &stderr); 0 != code {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+
+	if !bytes.Contains(stdout.Bytes(), []byte("latency p99.9")) {
+		t.Errorf("unexpected report %s", stdout.String())
+	}
+}
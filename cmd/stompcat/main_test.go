@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jjware/stomp"
+)
+
+// recorder remembers the frames it is served, and answers every
+// SUBSCRIBE with a single MESSAGE.
+type recorder struct {
+	mu     sync.Mutex
+	frames []*stomp.Frame
+	bodies []string
+}
+
+func (r *recorder) ServeFrame(s *stomp.Session, f *stomp.Frame) error {
+	body, _ := ioutil.ReadAll(f.Body)
+
+	r.mu.Lock()
+	r.frames = append(r.frames, f)
+	r.bodies = append(r.bodies, string(body))
+	r.mu.Unlock()
+
+	if stomp.CmdSubscribe != f.Command {
+		return nil
+	}
+	id, _ := f.Header.Get(stomp.HdrId)
+	dest, _ := f.Header.Get(stomp.HdrDestination)
+	msg := stomp.NewFrame(stomp.CmdMessage, strings.NewReader("hello"))
+	msg.Header.Set(stomp.HdrDestination, dest)
+	msg.Header.Set(stomp.HdrSubscription, id)
+	msg.Header.Set(stomp.HdrMessageId, "m-1")
+	msg.Header.Set(stomp.HdrAck, "a-1")
+	return s.Send(context.Background(), msg)
+}
+
+func (r *recorder) find(cmd stomp.Command) (*stomp.Frame, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, f := range r.frames {
+		if cmd == f.Command {
+			return f, r.bodies[i]
+		}
+	}
+	return nil, ""
+}
+
+func startServer(t *testing.T) (*recorder, string, func()) {
+	l, listenErr := net.Listen("tcp", "127.0.0.1:0")
+
+	if nil != listenErr {
+		t.Fatal(listenErr)
+	}
+	r := &recorder{}
+	srv := &stomp.Server{Handler: r}
+	go srv.Serve(l)
+	return r, "stomp://guest:secret@" + l.Addr().String() + "/test", func() { srv.Close() }
+}
+
+func TestSend(t *testing.T) {
+	r, url, stop := startServer(t)
+	defer stop()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-url", url, "-H", "x-test:1", "-content-type", "text/plain", "-receipt", "send", "/queue/a"}
+
+	if code := run(args, strings.NewReader("payload"), &stdout, &stderr); 0 != code {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	f, body := r.find(stomp.CmdSend)
+
+	if nil == f {
+		t.Fatal("no SEND frame received")
+	}
+
+	if body != "payload" {
+		t.Errorf("body = %q", body)
+	}
+
+	for name, want := range map[string]string{
+		stomp.HdrDestination: "/queue/a",
+		"x-test":             "1",
+		stomp.HdrContentType: "text/plain",
+	} {
+		if v, _ := f.Header.Get(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	r, url, stop := startServer(t)
+	defer stop()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-url", url, "-format", "json", "-ack", "client-individual", "-n", "1", "subscribe", "/queue/a"}
+
+	if code := run(args, strings.NewReader(""), &stdout, &stderr); 0 != code {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	var record struct {
+		Command string            `json:"command"`
+		Headers map[string]string `json:"headers"`
+		Body    string            `json:"body"`
+	}
+
+	if decodeErr := json.Unmarshal(stdout.Bytes(), &record); nil != decodeErr {
+		t.Fatalf("%v: %s", decodeErr, stdout.String())
+	}
+
+	if record.Command != "MESSAGE" || record.Body != "hello" || record.Headers[stomp.HdrMessageId] != "m-1" {
+		t.Errorf("unexpected output %+v", record)
+	}
+	ack, _ := r.find(stomp.CmdAck)
+
+	if nil == ack {
+		t.Fatal("message was not acknowledged")
+	}
+
+	if id, _ := ack.Header.Get(stomp.HdrId); id != "a-1" {
+		t.Errorf("ack id = %q", id)
+	}
+}
+
+func TestSubscribeInteractive(t *testing.T) {
+	r, url, stop := startServer(t)
+	defer stop()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-url", url, "-format", "raw", "-interactive", "-n", "1", "subscribe", "/queue/a"}
+
+	if code := run(args, strings.NewReader("n\n"), &stdout, &stderr); 0 != code {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+
+	if !strings.HasPrefix(stdout.String(), "MESSAGE\n") {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+
+	if nack, _ := r.find(stomp.CmdNack); nil == nack {
+		t.Error("message was not nacked")
+	}
+
+	if ack, _ := r.find(stomp.CmdAck); nil != ack {
+		t.Error("message was acked")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	if code := run([]string{"publish"}, nil, &stdout, &stderr); 2 != code {
+		t.Errorf("exit code %d", code)
+	}
+
+	if code := run([]string{"-url", "http://localhost", "send", "/queue/a"}, strings.NewReader(""), &stdout, &stderr); 1 != code {
+		t.Errorf("exit code %d", code)
+	}
+}
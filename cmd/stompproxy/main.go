@@ -0,0 +1,245 @@
+// Command stompproxy is a transparent STOMP proxy that can log,
+// filter and rewrite the frames passing between clients and a
+// broker.
+//
+// Usage:
+//
+//	stompproxy [flags]
+//
+// For example, to log every frame a client exchanges with a local
+// broker, while moving its queues to a new naming scheme:
+//
+//	stompproxy -listen :61614 -upstream localhost:61613 -log \
+//		-rename /queue/orders.=/queue/shop.orders.
+//
+// Rules are applied in the order: renames, headers, blocks.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/jjware/stomp"
+	"github.com/jjware/stomp/proxy"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+// listFlag collects a repeated string flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+type config struct {
+	listen      string
+	upstream    string
+	upstreamTLS bool
+	insecure    bool
+	logFrames   bool
+	logBody     int
+	renames     listFlag
+	headers     listFlag
+	blocks      listFlag
+}
+
+func run(args []string, stderr io.Writer) int {
+	p, listen, configErr := configure(args, stderr)
+
+	if nil != configErr {
+		if flag.ErrHelp != configErr {
+			fmt.Fprintln(stderr, "stompproxy:", configErr)
+		}
+		return 2
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	go func() {
+		<-interrupt
+		p.Close()
+	}()
+	p.Logger.Info("listening", "addr", listen, "upstream", p.Upstream)
+
+	if serveErr := p.ListenAndServe(listen); proxy.ErrProxyClosed != serveErr {
+		fmt.Fprintln(stderr, "stompproxy:", serveErr)
+		return 1
+	}
+	return 0
+}
+
+// configure parses the command line into a proxy and the address it
+// should listen on.
+func configure(args []string, stderr io.Writer) (*proxy.Proxy, string, error) {
+	var cfg config
+	fs := flag.NewFlagSet("stompproxy", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&cfg.listen, "listen", ":61613", "`address` to accept client connections on")
+	fs.StringVar(&cfg.upstream, "upstream", "", "`address` of the upstream broker")
+	fs.BoolVar(&cfg.upstreamTLS, "upstream-tls", false, "connect to the upstream broker using TLS")
+	fs.BoolVar(&cfg.insecure, "insecure", false, "skip verification of the upstream broker's certificate")
+	fs.BoolVar(&cfg.logFrames, "log", false, "log every frame passing through")
+	fs.IntVar(&cfg.logBody, "log-body", stomp.DefaultLogBodyBytes, "body `bytes` included when logging frames")
+	fs.Var(&cfg.renames, "rename", "rename destinations beginning with `from=to`; may be repeated")
+	fs.Var(&cfg.headers, "header", "set a header on frames sent upstream, as `[COMMAND:]name:value`; may be repeated")
+	fs.Var(&cfg.blocks, "block", "refuse frames with `COMMAND` from clients; may be repeated")
+
+	if parseErr := fs.Parse(args); nil != parseErr {
+		return nil, "", parseErr
+	}
+
+	if fs.NArg() > 0 || "" == cfg.upstream {
+		fs.Usage()
+		return nil, "", flag.ErrHelp
+	}
+	logger := &stdLogger{l: log.New(stderr, "", log.LstdFlags), debug: cfg.logFrames}
+	rules, rulesErr := cfg.rules()
+
+	if nil != rulesErr {
+		return nil, "", rulesErr
+	}
+
+	p := &proxy.Proxy{
+		Upstream: cfg.upstream,
+		Rules:    rules,
+		Logger:   logger,
+	}
+
+	if cfg.logFrames {
+		p.Interceptors = func() []stomp.Interceptor {
+			return []stomp.Interceptor{stomp.LogFrames(logger, cfg.logBody)}
+		}
+	}
+
+	if cfg.upstreamTLS {
+		host, _, splitErr := net.SplitHostPort(cfg.upstream)
+
+		if nil != splitErr {
+			return nil, "", splitErr
+		}
+		tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: cfg.insecure}
+		p.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: proxy.DefaultDialTimeout}
+			conn, dialErr := dialer.DialContext(ctx, network, addr)
+
+			if nil != dialErr {
+				return nil, dialErr
+			}
+			tlsConn := tls.Client(conn, tlsConfig)
+
+			if handshakeErr := tlsConn.Handshake(); nil != handshakeErr {
+				conn.Close()
+				return nil, handshakeErr
+			}
+			return tlsConn, nil
+		}
+	}
+	return p, cfg.listen, nil
+}
+
+// rules builds the proxy rules given on the command line.
+func (cfg *config) rules() ([]proxy.Rule, error) {
+	var rules []proxy.Rule
+
+	for _, v := range cfg.renames {
+		ndx := strings.IndexByte(v, '=')
+
+		if ndx <= 0 {
+			return nil, fmt.Errorf("rename %q is not of the form from=to", v)
+		}
+		rules = append(rules, proxy.RenameDestination(v[:ndx], v[ndx+1:]))
+	}
+
+	for _, v := range cfg.headers {
+		parts := strings.SplitN(v, ":", 3)
+		var commands []stomp.Command
+
+		switch {
+		case len(parts) == 3 && isCommand(parts[0]):
+			commands = append(commands, stomp.Command(strings.ToUpper(parts[0])))
+			parts = parts[1:]
+		case len(parts) == 3:
+			parts = []string{parts[0], parts[1] + ":" + parts[2]}
+		case len(parts) < 2 || "" == parts[0]:
+			return nil, fmt.Errorf("header %q is not of the form [COMMAND:]name:value", v)
+		}
+		rules = append(rules, proxy.SetHeader(proxy.Upstream, parts[0], parts[1], commands...))
+	}
+	var blocked []stomp.Command
+
+	for _, v := range cfg.blocks {
+		for _, c := range strings.Split(v, ",") {
+			if !isCommand(c) {
+				return nil, fmt.Errorf("unknown command %q", c)
+			}
+			blocked = append(blocked, stomp.Command(strings.ToUpper(c)))
+		}
+	}
+
+	if len(blocked) > 0 {
+		rules = append(rules, proxy.Block(blocked...))
+	}
+	return rules, nil
+}
+
+func isCommand(v string) bool {
+	switch stomp.Command(strings.ToUpper(v)) {
+	case stomp.CmdConnect, stomp.CmdStomp, stomp.CmdSend, stomp.CmdSubscribe,
+		stomp.CmdUnsubscribe, stomp.CmdAck, stomp.CmdNack, stomp.CmdBegin,
+		stomp.CmdCommit, stomp.CmdAbort, stomp.CmdDisconnect:
+		return true
+	}
+	return false
+}
+
+// stdLogger adapts a *log.Logger to stomp.Logger, writing each event
+// as its level and message followed by key=value pairs.
+type stdLogger struct {
+	l     *log.Logger
+	debug bool
+}
+
+func (s *stdLogger) print(level, msg string, args []interface{}) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteByte(' ')
+	b.WriteString(msg)
+
+	for i := 0; i+1 < len(args); i += 2 {
+		v := fmt.Sprint(args[i+1])
+
+		if "" == v || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %v=%s", args[i], v)
+	}
+	s.l.Print(b.String())
+}
+
+func (s *stdLogger) Debug(msg string, args ...interface{}) {
+	if s.debug {
+		s.print("DEBUG", msg, args)
+	}
+}
+
+func (s *stdLogger) Info(msg string, args ...interface{})  { s.print("INFO", msg, args) }
+func (s *stdLogger) Warn(msg string, args ...interface{})  { s.print("WARN", msg, args) }
+func (s *stdLogger) Error(msg string, args ...interface{}) { s.print("ERROR", msg, args) }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/jjware/stomp"
+	"github.com/jjware/stomp/proxy"
+)
+
+func TestConfigure(t *testing.T) {
+	var stderr bytes.Buffer
+	args := []string{
+		"-upstream", "broker:61613",
+		"-rename", "/queue/old.=/queue/new.",
+		"-header", "SEND:x-via:proxy",
+		"-header", "x-url:http://example.com",
+		"-block", "begin,COMMIT",
+	}
+	p, listen, configErr := configure(args, &stderr)
+
+	if nil != configErr {
+		t.Fatal(configErr)
+	}
+
+	if ":61613" != listen || "broker:61613" != p.Upstream || nil != p.Interceptors {
+		t.Errorf("listen = %q, upstream = %q", listen, p.Upstream)
+	}
+	apply := func(f *stomp.Frame) (*stomp.Frame, error) {
+		for _, r := range p.Rules {
+			var ruleErr error
+
+			if f, ruleErr = r.Rewrite(proxy.Upstream, f); nil != ruleErr {
+				return nil, ruleErr
+			}
+		}
+		return f, nil
+	}
+	f := stomp.NewFrame(stomp.CmdSend, nil)
+	f.Header.Set(stomp.HdrDestination, "/queue/old.a")
+
+	if _, ruleErr := apply(f); nil != ruleErr {
+		t.Fatal(ruleErr)
+	}
+
+	for name, want := range map[string]string{
+		stomp.HdrDestination: "/queue/new.a",
+		"x-via":              "proxy",
+		"x-url":              "http://example.com",
+	} {
+		if v, _ := f.Header.Get(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+	f = stomp.NewFrame(stomp.CmdSubscribe, nil)
+
+	if _, ruleErr := apply(f); nil != ruleErr {
+		t.Fatal(ruleErr)
+	}
+
+	if _, ok := f.Header.Get("x-via"); ok {
+		t.Error("x-via set on SUBSCRIBE frame")
+	}
+
+	for _, cmd := range []stomp.Command{stomp.CmdBegin, stomp.CmdCommit} {
+		if _, ruleErr := apply(stomp.NewFrame(cmd, nil)); nil == ruleErr {
+			t.Errorf("%s was not blocked", cmd)
+		}
+	}
+
+	for _, bad := range [][]string{
+		{},
+		{"-upstream", "broker:61613", "-rename", "/queue/a"},
+		{"-upstream", "broker:61613", "-header", "novalue"},
+		{"-upstream", "broker:61613", "-block", "MESSAGE"},
+	} {
+		if _, _, configErr = configure(bad, &stderr); nil == configErr {
+			t.Errorf("configure(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var out bytes.Buffer
+	l := &stdLogger{l: log.New(&out, "", 0)}
+	l.Debug("hidden")
+	l.Info("listening", "addr", ":61613", "note", "two words")
+
+	if have := out.String(); have != "INFO listening addr=:61613 note=\"two words\"\n" {
+		t.Errorf("logged %q", have)
+	}
+
+	if strings.Contains(out.String(), "hidden") {
+		t.Error("debug event logged")
+	}
+}
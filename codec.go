@@ -0,0 +1,227 @@
+package stomp
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownContentType is returned when no codec is registered for
+// a message's content type.
+var ErrUnknownContentType = errors.New("unknown content type")
+
+// ErrUnsupportedValue is returned by a codec given a value it cannot
+// marshal, or unmarshal into.
+var ErrUnsupportedValue = errors.New("unsupported value")
+
+// A Codec converts values to and from the bodies of messages with a
+// particular content type.
+type Codec interface {
+	// ContentType returns the content-type header of the messages
+	// the codec marshals.
+	ContentType() string
+
+	// Marshal returns the body of a message carrying v.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes the body of a message into v, which is
+	// usually a pointer.
+	Unmarshal(body []byte, v interface{}) error
+}
+
+// A BinaryMessage is a value that encodes itself in a binary format,
+// in the manner of generated protocol buffer types.
+type BinaryMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(body []byte) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(body []byte, v interface{}) error {
+	return json.Unmarshal(body, v)
+}
+
+// JSONCodec marshals values as JSON, with encoding/json.
+var JSONCodec Codec = jsonCodec{}
+
+type textCodec struct{}
+
+func (textCodec) ContentType() string {
+	return "text/plain;charset=utf-8"
+}
+
+func (textCodec) Marshal(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case string:
+		return []byte(t), nil
+	case []byte:
+		return t, nil
+	case encoding.TextMarshaler:
+		return t.MarshalText()
+	case fmt.Stringer:
+		return []byte(t.String()), nil
+	}
+	return nil, fmt.Errorf("%w. text codec cannot marshal %T", ErrUnsupportedValue, v)
+}
+
+func (textCodec) Unmarshal(body []byte, v interface{}) error {
+	switch t := v.(type) {
+	case *string:
+		*t = string(body)
+		return nil
+	case *[]byte:
+		*t = append([]byte(nil), body...)
+		return nil
+	case encoding.TextUnmarshaler:
+		return t.UnmarshalText(body)
+	}
+	return fmt.Errorf("%w. text codec cannot unmarshal into %T", ErrUnsupportedValue, v)
+}
+
+// TextCodec marshals strings, byte slices, and values implementing
+// encoding.TextMarshaler or fmt.Stringer as UTF-8 text. It
+// unmarshals into strings, byte slices, and values implementing
+// encoding.TextUnmarshaler.
+var TextCodec Codec = textCodec{}
+
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case []byte:
+		return t, nil
+	case string:
+		return []byte(t), nil
+	}
+	return nil, fmt.Errorf("%w. raw codec cannot marshal %T", ErrUnsupportedValue, v)
+}
+
+func (rawCodec) Unmarshal(body []byte, v interface{}) error {
+	if t, ok := v.(*[]byte); ok {
+		*t = append([]byte(nil), body...)
+		return nil
+	}
+	return fmt.Errorf("%w. raw codec cannot unmarshal into %T", ErrUnsupportedValue, v)
+}
+
+// RawCodec passes byte slices and strings through unchanged, and
+// unmarshals into byte slices.
+var RawCodec Codec = rawCodec{}
+
+type binaryCodec struct {
+	contentType string
+}
+
+// NewBinaryCodec returns a codec for values that encode themselves:
+// those implementing BinaryMessage, such as generated protocol buffer
+// types, or encoding.BinaryMarshaler and encoding.BinaryUnmarshaler.
+// Byte slices are passed through unchanged.
+func NewBinaryCodec(contentType string) Codec {
+	return binaryCodec{contentType: contentType}
+}
+
+func (c binaryCodec) ContentType() string {
+	return c.contentType
+}
+
+func (c binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case BinaryMessage:
+		return t.Marshal()
+	case encoding.BinaryMarshaler:
+		return t.MarshalBinary()
+	case []byte:
+		return t, nil
+	}
+	return nil, fmt.Errorf("%w. %s codec cannot marshal %T", ErrUnsupportedValue, c.contentType, v)
+}
+
+func (c binaryCodec) Unmarshal(body []byte, v interface{}) error {
+	switch t := v.(type) {
+	case BinaryMessage:
+		return t.Unmarshal(body)
+	case encoding.BinaryUnmarshaler:
+		return t.UnmarshalBinary(body)
+	case *[]byte:
+		*t = append([]byte(nil), body...)
+		return nil
+	}
+	return fmt.Errorf("%w. %s codec cannot unmarshal into %T", ErrUnsupportedValue, c.contentType, v)
+}
+
+// A CodecRegistry maps content types to codecs. The methods of a
+// CodecRegistry are thread safe.
+type CodecRegistry struct {
+	mu     sync.RWMutex
+	codecs map[string]Codec
+}
+
+// NewCodecRegistry returns a registry holding the given codecs.
+func NewCodecRegistry(codecs ...Codec) *CodecRegistry {
+	r := &CodecRegistry{codecs: make(map[string]Codec)}
+
+	for _, c := range codecs {
+		r.Register(c)
+	}
+	return r
+}
+
+// DefaultCodecs holds JSONCodec, TextCodec and RawCodec. A message
+// without a content-type header is raw, as the STOMP specification
+// has it.
+var DefaultCodecs = NewCodecRegistry(JSONCodec, TextCodec, RawCodec)
+
+// mediaType returns the media type of a content-type header value,
+// without its parameters, in lower case.
+func mediaType(contentType string) string {
+	if mt, _, parseErr := mime.ParseMediaType(contentType); nil == parseErr {
+		return mt
+	}
+
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// Register adds c to the registry, replacing any codec for the same
+// media type. Parameters of the content type, such as charset, are
+// not significant.
+func (r *CodecRegistry) Register(c Codec) {
+	r.mu.Lock()
+	r.codecs[mediaType(c.ContentType())] = c
+	r.mu.Unlock()
+}
+
+// Lookup returns the codec for contentType. An empty contentType
+// selects the codec for application/octet-stream.
+func (r *CodecRegistry) Lookup(contentType string) (Codec, error) {
+	if "" == contentType {
+		contentType = RawCodec.ContentType()
+	}
+	r.mu.RLock()
+	c, ok := r.codecs[mediaType(contentType)]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w. no codec for %q", ErrUnknownContentType, contentType)
+	}
+	return c, nil
+}
@@ -0,0 +1,183 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// point is a value with a hand-written binary encoding, standing in
+// for a generated protocol buffer type.
+type point struct {
+	X, Y int
+}
+
+func (p *point) Marshal() ([]byte, error) {
+	return []byte(strconv.Itoa(p.X) + "," + strconv.Itoa(p.Y)), nil
+}
+
+func (p *point) Unmarshal(body []byte) error {
+	parts := strings.Split(string(body), ",")
+
+	if 2 != len(parts) {
+		return errors.New("malformed point")
+	}
+	var xErr, yErr error
+	p.X, xErr = strconv.Atoi(parts[0])
+	p.Y, yErr = strconv.Atoi(parts[1])
+
+	if nil != xErr {
+		return xErr
+	}
+	return yErr
+}
+
+func TestCodecs(t *testing.T) {
+	binary := NewBinaryCodec("application/x-protobuf")
+	tests := []struct {
+		codec Codec
+		in    interface{}
+		body  string
+		out   interface{}
+	}{
+		{JSONCodec, map[string]int{"a": 1}, `{"a":1}`, &map[string]int{}},
+		{TextCodec, "hello", "hello", new(string)},
+		{TextCodec, net.IPv4(10, 0, 0, 1), "10.0.0.1", &net.IP{}},
+		{RawCodec, []byte{0, 1, 2}, "\x00\x01\x02", &[]byte{}},
+		{binary, &point{3, 4}, "3,4", &point{}},
+		{binary, time.Unix(0, 0).UTC(), "", &time.Time{}},
+	}
+
+	for _, test := range tests {
+		body, marshalErr := test.codec.Marshal(test.in)
+
+		if nil != marshalErr {
+			t.Errorf("%s: marshal %T: %v", test.codec.ContentType(), test.in, marshalErr)
+			continue
+		}
+
+		if "" != test.body && test.body != string(body) {
+			t.Errorf("%s: marshalled %v as %q, want %q", test.codec.ContentType(), test.in, body, test.body)
+		}
+
+		if unmarshalErr := test.codec.Unmarshal(body, test.out); nil != unmarshalErr {
+			t.Errorf("%s: unmarshal into %T: %v", test.codec.ContentType(), test.out, unmarshalErr)
+			continue
+		}
+		got := reflect.ValueOf(test.out).Elem().Interface()
+		want := test.in
+
+		if v := reflect.ValueOf(want); reflect.Ptr == v.Kind() {
+			want = v.Elem().Interface()
+		}
+
+		if ip, ok := want.(net.IP); ok {
+			want = ip.To4()
+			got = got.(net.IP).To4()
+		}
+
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: round trip of %v gave %v", test.codec.ContentType(), want, got)
+		}
+	}
+
+	if _, marshalErr := RawCodec.Marshal(42); !errors.Is(marshalErr, ErrUnsupportedValue) {
+		t.Errorf("expected %v, got %v", ErrUnsupportedValue, marshalErr)
+	}
+
+	if unmarshalErr := TextCodec.Unmarshal([]byte("x"), new(int)); !errors.Is(unmarshalErr, ErrUnsupportedValue) {
+		t.Errorf("expected %v, got %v", ErrUnsupportedValue, unmarshalErr)
+	}
+}
+
+func TestCodecRegistry(t *testing.T) {
+	r := NewCodecRegistry(JSONCodec, TextCodec)
+
+	for _, ct := range []string{"application/json", "Application/JSON; charset=utf-8", "text/plain", "text/plain;charset=utf-8"} {
+		if _, lookupErr := r.Lookup(ct); nil != lookupErr {
+			t.Errorf("lookup %q: %v", ct, lookupErr)
+		}
+	}
+
+	if _, lookupErr := r.Lookup(""); !errors.Is(lookupErr, ErrUnknownContentType) {
+		t.Errorf("expected %v, got %v", ErrUnknownContentType, lookupErr)
+	}
+	r.Register(RawCodec)
+
+	if c, lookupErr := r.Lookup(""); nil != lookupErr || RawCodec != c {
+		t.Errorf("lookup of no content type gave %v, %v", c, lookupErr)
+	}
+}
+
+func TestMessenger(t *testing.T) {
+	handler := &echoHandler{subs: make(map[*Session]map[string]string)}
+	srv, connect := newTestServer(handler)
+	defer srv.Close()
+
+	client, connectErr := connect(t, nil)
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	m := NewMessenger(client)
+	m.Codecs = NewCodecRegistry(JSONCodec, TextCodec, RawCodec, NewBinaryCodec("application/x-point"))
+	sub, subErr := m.Subscribe(ctx, "/queue/a", Header{HdrReceipt: {"r-1"}})
+
+	if nil != subErr {
+		t.Fatal(subErr)
+	}
+	type order struct {
+		ID    string
+		Items int
+	}
+
+	if sendErr := m.Send(ctx, "/queue/a", order{"o-1", 3}); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+	var got order
+	msg, receiveErr := sub.ReceiveValue(ctx, &got)
+
+	if nil != receiveErr {
+		t.Fatal(receiveErr)
+	}
+
+	if (order{"o-1", 3}) != got || "application/json" != msg.ContentType() || "/queue/a" != msg.Destination() {
+		t.Errorf("received %+v as %q from %q", got, msg.ContentType(), msg.Destination())
+	}
+	f, encodeErr := m.Encode("application/x-point", &point{1, 2})
+
+	if nil != encodeErr {
+		t.Fatal(encodeErr)
+	}
+
+	if sendErr := client.Send(ctx, "/queue/a", f); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+	var p point
+
+	if _, receiveErr = sub.ReceiveValue(ctx, &p); nil != receiveErr || (point{1, 2}) != p {
+		t.Errorf("received %+v, %v", p, receiveErr)
+	}
+
+	// A message the codec cannot decode is still returned.
+	if sendErr := m.Send(ctx, "/queue/a", "not an order"); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+
+	if msg, receiveErr = sub.ReceiveValue(ctx, &p); nil == receiveErr || nil == msg || `"not an order"` != string(msg.Body) {
+		t.Errorf("expected a decoding error with the message, got %v, %v", msg, receiveErr)
+	}
+
+	if _, encodeErr = m.Encode("application/x-unknown", 1); !errors.Is(encodeErr, ErrUnknownContentType) {
+		t.Errorf("expected %v, got %v", ErrUnknownContentType, encodeErr)
+	}
+}
@@ -0,0 +1,379 @@
+// Package compress compresses the bodies of STOMP frames. An
+// interceptor compresses outbound SEND and MESSAGE bodies, naming the
+// algorithm in a content-encoding header, and transparently restores
+// inbound bodies that carry one. Algorithms are looked up by name in a
+// Registry, which holds gzip and deflate by default.
+//
+// Peers advertise the encodings they accept with an accept-encoding
+// header on their CONNECT or CONNECTED frame, which the interceptor
+// adds and reads. By default a body is only compressed once the peer
+// has advertised the encoding, so that a peer unaware of compression
+// never receives it.
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jjware/stomp"
+)
+
+const (
+	// HdrContentEncoding names the algorithm a frame's body is
+	// compressed with.
+	HdrContentEncoding = "content-encoding"
+
+	// HdrAcceptEncoding lists the algorithms a peer can decompress,
+	// separated by commas. It is sent on CONNECT and CONNECTED
+	// frames.
+	HdrAcceptEncoding = "accept-encoding"
+)
+
+const (
+	// DefaultMinSize is the size, in bytes, below which bodies are
+	// sent uncompressed when an interceptor's MinSize is zero.
+	DefaultMinSize = 1024
+
+	// DefaultMaxSize limits the size, in bytes, of a decompressed
+	// body when an interceptor's MaxSize is zero.
+	DefaultMaxSize = 64 << 20
+)
+
+// ErrTooLarge is returned when a body decompresses to more than the
+// permitted size.
+var ErrTooLarge = errors.New("decompressed body too large")
+
+// An Algorithm compresses and decompresses bodies.
+type Algorithm interface {
+	// Name returns the content-encoding token of the algorithm.
+	Name() string
+
+	// NewWriter returns a writer that compresses to w. Closing it
+	// flushes any pending data, but does not close w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+
+	// NewReader returns a reader that decompresses r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+type gzipAlgorithm struct{}
+
+func (gzipAlgorithm) Name() string {
+	return "gzip"
+}
+
+func (gzipAlgorithm) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipAlgorithm) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// Gzip is the gzip algorithm of RFC 1952.
+var Gzip Algorithm = gzipAlgorithm{}
+
+type deflateAlgorithm struct{}
+
+func (deflateAlgorithm) Name() string {
+	return "deflate"
+}
+
+func (deflateAlgorithm) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, flate.DefaultCompression)
+}
+
+func (deflateAlgorithm) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+// Deflate is the raw deflate algorithm of RFC 1951.
+var Deflate Algorithm = deflateAlgorithm{}
+
+// A Registry holds algorithms by name. The methods of a Registry are
+// thread safe.
+type Registry struct {
+	mu         sync.RWMutex
+	algorithms map[string]Algorithm
+}
+
+// NewRegistry returns a registry holding the given algorithms.
+func NewRegistry(algorithms ...Algorithm) *Registry {
+	r := &Registry{algorithms: make(map[string]Algorithm)}
+
+	for _, a := range algorithms {
+		r.Register(a)
+	}
+	return r
+}
+
+// DefaultRegistry holds Gzip and Deflate.
+var DefaultRegistry = NewRegistry(Gzip, Deflate)
+
+// Register adds a to the registry, replacing any algorithm of the
+// same name.
+func (r *Registry) Register(a Algorithm) {
+	r.mu.Lock()
+	r.algorithms[strings.ToLower(a.Name())] = a
+	r.mu.Unlock()
+}
+
+// Lookup returns the algorithm with the given name.
+func (r *Registry) Lookup(name string) (Algorithm, bool) {
+	r.mu.RLock()
+	a, ok := r.algorithms[strings.ToLower(strings.TrimSpace(name))]
+	r.mu.RUnlock()
+	return a, ok
+}
+
+// Names returns the names of the registered algorithms, sorted.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.algorithms))
+
+	for name := range r.algorithms {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Options configures an interceptor created by Interceptor.
+type Options struct {
+	// Encoding names the algorithm outbound bodies are compressed
+	// with. Empty selects gzip.
+	Encoding string
+
+	// MinSize is the size, in bytes, below which bodies are sent
+	// uncompressed. Zero selects DefaultMinSize.
+	MinSize int
+
+	// MaxSize limits the size, in bytes, of a decompressed body.
+	// Zero selects DefaultMaxSize.
+	MaxSize int64
+
+	// Registry holds the algorithms the interceptor can use. Nil
+	// selects DefaultRegistry.
+	Registry *Registry
+
+	// Force compresses outbound bodies without waiting for the peer
+	// to advertise the encoding. It suits clients of brokers that
+	// relay bodies untouched to consumers able to decompress them.
+	Force bool
+}
+
+type interceptor struct {
+	opts     Options
+	registry *Registry
+
+	mu   sync.Mutex
+	peer map[string]bool
+}
+
+// Interceptor returns an interceptor that compresses the bodies of
+// outbound SEND and MESSAGE frames, and decompresses inbound bodies
+// with a content-encoding header that names a registered algorithm.
+// A body is sent uncompressed when it is shorter than the minimum
+// size, or would not shrink. The interceptor records the encodings the
+// peer accepts, so each connection needs an interceptor of its own.
+// A nil opts selects the defaults.
+func Interceptor(opts *Options) stomp.Interceptor {
+	i := &interceptor{}
+
+	if nil != opts {
+		i.opts = *opts
+	}
+
+	if "" == i.opts.Encoding {
+		i.opts.Encoding = Gzip.Name()
+	}
+
+	if 0 == i.opts.MinSize {
+		i.opts.MinSize = DefaultMinSize
+	}
+
+	if 0 == i.opts.MaxSize {
+		i.opts.MaxSize = DefaultMaxSize
+	}
+	i.registry = i.opts.Registry
+
+	if nil == i.registry {
+		i.registry = DefaultRegistry
+	}
+	return i
+}
+
+// advertise adds the registry's encodings to a connection frame.
+func (i *interceptor) advertise(f *stomp.Frame) {
+	if _, ok := f.Header.Get(HdrAcceptEncoding); !ok {
+		f.Header.Set(HdrAcceptEncoding, strings.Join(i.registry.Names(), ","))
+	}
+}
+
+// learn records the encodings a peer's connection frame accepts.
+func (i *interceptor) learn(f *stomp.Frame) {
+	v, _ := f.Header.Get(HdrAcceptEncoding)
+	peer := make(map[string]bool)
+
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); "" != name {
+			peer[name] = true
+		}
+	}
+	i.mu.Lock()
+	i.peer = peer
+	i.mu.Unlock()
+}
+
+// accepted reports whether the peer can decompress the encoding.
+func (i *interceptor) accepted(name string) bool {
+	if i.opts.Force {
+		return true
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.peer[strings.ToLower(name)]
+}
+
+func (i *interceptor) InterceptSend(next stomp.SendFunc) stomp.SendFunc {
+	return func(ctx context.Context, f *stomp.Frame) error {
+		if nil == f {
+			return next(ctx, f)
+		}
+
+		switch f.Command {
+		case stomp.CmdConnect, stomp.CmdStomp, stomp.CmdConnected:
+			i.advertise(f)
+		case stomp.CmdSend, stomp.CmdMessage:
+			if compressErr := i.compress(f); nil != compressErr {
+				return compressErr
+			}
+		}
+		return next(ctx, f)
+	}
+}
+
+// compress replaces f's body with its compressed form, when that is
+// acceptable to the peer and worthwhile.
+func (i *interceptor) compress(f *stomp.Frame) error {
+	if nil == f.Body || !i.accepted(i.opts.Encoding) {
+		return nil
+	}
+
+	if _, ok := f.Header.Get(HdrContentEncoding); ok {
+		return nil
+	}
+	algorithm, ok := i.registry.Lookup(i.opts.Encoding)
+
+	if !ok {
+		return fmt.Errorf("unknown encoding %q", i.opts.Encoding)
+	}
+	body, readErr := ioutil.ReadAll(f.Body)
+	closeErr := f.Body.Close()
+
+	if nil == readErr {
+		readErr = closeErr
+	}
+
+	if nil != readErr {
+		return readErr
+	}
+	f.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if len(body) < i.opts.MinSize {
+		return nil
+	}
+	var buf bytes.Buffer
+	w, writerErr := algorithm.NewWriter(&buf)
+
+	if nil != writerErr {
+		return writerErr
+	}
+
+	if _, writeErr := w.Write(body); nil != writeErr {
+		return writeErr
+	}
+
+	if flushErr := w.Close(); nil != flushErr {
+		return flushErr
+	}
+
+	if buf.Len() >= len(body) {
+		return nil
+	}
+	f.Header.Set(HdrContentEncoding, algorithm.Name())
+	f.Header.Set(stomp.HdrContentLength, strconv.Itoa(buf.Len()))
+	f.Body = ioutil.NopCloser(&buf)
+	return nil
+}
+
+func (i *interceptor) InterceptReceive(next stomp.ReceiveFunc) stomp.ReceiveFunc {
+	return func(ctx context.Context) (*stomp.Frame, error) {
+		f, readErr := next(ctx)
+
+		if nil != readErr || nil == f {
+			return f, readErr
+		}
+
+		switch f.Command {
+		case stomp.CmdConnect, stomp.CmdStomp, stomp.CmdConnected:
+			i.learn(f)
+		}
+		return f, i.decompress(f)
+	}
+}
+
+// decompress restores f's body, if it is compressed with a registered
+// algorithm. Bodies in other encodings are left for the application.
+func (i *interceptor) decompress(f *stomp.Frame) error {
+	name, ok := f.Header.Get(HdrContentEncoding)
+
+	if !ok || nil == f.Body {
+		return nil
+	}
+	algorithm, ok := i.registry.Lookup(name)
+
+	if !ok {
+		return nil
+	}
+	compressed, readErr := ioutil.ReadAll(f.Body)
+	closeErr := f.Body.Close()
+
+	if nil == readErr {
+		readErr = closeErr
+	}
+
+	if nil != readErr {
+		return readErr
+	}
+	r, readerErr := algorithm.NewReader(bytes.NewReader(compressed))
+
+	if nil != readerErr {
+		return fmt.Errorf("%w. bad %s body: %v", stomp.ErrMalformedFrame, name, readerErr)
+	}
+	defer r.Close()
+	body, readErr := ioutil.ReadAll(io.LimitReader(r, i.opts.MaxSize+1))
+
+	if nil != readErr {
+		return fmt.Errorf("%w. bad %s body: %v", stomp.ErrMalformedFrame, name, readErr)
+	}
+
+	if int64(len(body)) > i.opts.MaxSize {
+		return fmt.Errorf("%w. limit is %d bytes", ErrTooLarge, i.opts.MaxSize)
+	}
+	f.Header.Del(HdrContentEncoding)
+	f.Header.Set(stomp.HdrContentLength, strconv.Itoa(len(body)))
+	f.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return nil
+}
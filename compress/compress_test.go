@@ -0,0 +1,201 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jjware/stomp"
+	"github.com/jjware/stomp/broker"
+)
+
+// wire records the content-encoding of the frames passing through
+// it, by command.
+type wire struct {
+	mu        sync.Mutex
+	encodings map[stomp.Command][]string
+}
+
+func (w *wire) record(f *stomp.Frame) {
+	if nil == f {
+		return
+	}
+	v, _ := f.Header.Get(HdrContentEncoding)
+	w.mu.Lock()
+	w.encodings[f.Command] = append(w.encodings[f.Command], v)
+	w.mu.Unlock()
+}
+
+func (w *wire) get(cmd stomp.Command) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.encodings[cmd]...)
+}
+
+func (w *wire) InterceptSend(next stomp.SendFunc) stomp.SendFunc {
+	return func(ctx context.Context, f *stomp.Frame) error {
+		w.record(f)
+		return next(ctx, f)
+	}
+}
+
+func (w *wire) InterceptReceive(next stomp.ReceiveFunc) stomp.ReceiveFunc {
+	return func(ctx context.Context) (*stomp.Frame, error) {
+		f, readErr := next(ctx)
+		w.record(f)
+		return f, readErr
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	server := &wire{encodings: make(map[stomp.Command][]string)}
+	srv := &stomp.Server{
+		Handler: broker.New(),
+		Interceptors: func() []stomp.Interceptor {
+			return []stomp.Interceptor{Interceptor(nil), server}
+		},
+	}
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	connect := func(interceptors ...stomp.Interceptor) *stomp.Client {
+		serverConn, clientConn := net.Pipe()
+		go srv.ServeConn(serverConn)
+		c, connectErr := stomp.Connect(ctx, clientConn, nil, &stomp.ClientOptions{Interceptors: interceptors})
+
+		if nil != connectErr {
+			t.Fatal(connectErr)
+		}
+		return c
+	}
+	compressing := connect(Interceptor(nil))
+	defer compressing.Close()
+	plain := connect()
+	defer plain.Close()
+
+	subscribe := func(c *stomp.Client) *stomp.Subscription {
+		sub, subErr := c.Subscribe(ctx, "/topic/bulk", stomp.Header{stomp.HdrReceipt: {"subscribed"}})
+
+		if nil != subErr {
+			t.Fatal(subErr)
+		}
+		return sub
+	}
+	compressedSub := subscribe(compressing)
+	plainSub := subscribe(plain)
+
+	large := strings.Repeat("compressible ", 1000)
+
+	for _, body := range []string{large, "small"} {
+		f := stomp.NewFrame(stomp.CmdSend, strings.NewReader(body))
+		f.Header.Set(stomp.HdrReceipt, "sent")
+
+		if sendErr := compressing.Send(ctx, "/topic/bulk", f); nil != sendErr {
+			t.Fatal(sendErr)
+		}
+
+		for _, sub := range []*stomp.Subscription{compressedSub, plainSub} {
+			msg, receiveErr := sub.Receive(ctx)
+
+			if nil != receiveErr {
+				t.Fatal(receiveErr)
+			}
+			got, _ := ioutil.ReadAll(msg.Body)
+
+			if body != string(got) {
+				t.Errorf("received %d bytes, want %d", len(got), len(body))
+			}
+
+			if _, ok := msg.Header.Get(HdrContentEncoding); ok {
+				t.Errorf("content-encoding header was not removed")
+			}
+		}
+	}
+
+	if got := server.get(stomp.CmdSend); 2 != len(got) || "gzip" != got[0] || "" != got[1] {
+		t.Errorf("SEND encodings on the wire = %q", got)
+	}
+
+	// Only the subscriber that advertised gzip receives it.
+	messages := server.get(stomp.CmdMessage)
+
+	if 4 != len(messages) {
+		t.Fatalf("MESSAGE encodings on the wire = %q", messages)
+	}
+	gzipped := 0
+
+	for _, v := range messages {
+		if "gzip" == v {
+			gzipped++
+		}
+	}
+
+	if 1 != gzipped {
+		t.Errorf("MESSAGE encodings on the wire = %q", messages)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	body := bytes.Repeat([]byte{'x'}, 4096)
+	sender := Interceptor(&Options{Encoding: "deflate", Force: true}).(*interceptor)
+	f := stomp.NewFrame(stomp.CmdSend, bytes.NewReader(body))
+
+	if compressErr := sender.compress(f); nil != compressErr {
+		t.Fatal(compressErr)
+	}
+
+	if v, _ := f.Header.Get(HdrContentEncoding); "deflate" != v {
+		t.Fatalf("content-encoding = %q", v)
+	}
+	compressed, _ := ioutil.ReadAll(f.Body)
+
+	if len(compressed) >= len(body) {
+		t.Fatalf("compressed %d bytes to %d", len(body), len(compressed))
+	}
+
+	if v, _ := f.Header.Get(stomp.HdrContentLength); strconv.Itoa(len(compressed)) != v {
+		t.Errorf("content-length = %s for %d compressed bytes", v, len(compressed))
+	}
+
+	receive := func(maxSize int64) (*stomp.Frame, error) {
+		r := Interceptor(&Options{MaxSize: maxSize}).(*interceptor)
+		g := stomp.NewFrame(stomp.CmdMessage, bytes.NewReader(compressed))
+		g.Header.Set(HdrContentEncoding, "deflate")
+		return g, r.decompress(g)
+	}
+	g, decompressErr := receive(0)
+
+	if nil != decompressErr {
+		t.Fatal(decompressErr)
+	}
+	got, _ := ioutil.ReadAll(g.Body)
+
+	if !bytes.Equal(body, got) {
+		t.Errorf("decompressed %d bytes, want %d", len(got), len(body))
+	}
+
+	if _, decompressErr = receive(1024); !errors.Is(decompressErr, ErrTooLarge) {
+		t.Errorf("expected %v, got %v", ErrTooLarge, decompressErr)
+	}
+
+	// An encoding that is not registered is left for the
+	// application.
+	h := stomp.NewFrame(stomp.CmdMessage, strings.NewReader("opaque"))
+	h.Header.Set(HdrContentEncoding, "br")
+
+	if decompressErr = sender.decompress(h); nil != decompressErr {
+		t.Fatal(decompressErr)
+	}
+
+	if v, _ := h.Header.Get(HdrContentEncoding); "br" != v {
+		t.Errorf("content-encoding = %q", v)
+	}
+}
@@ -0,0 +1,223 @@
+package stomp
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxDiffBody is the number of body bytes around the first
+// difference that a Difference renders.
+const maxDiffBody = 32
+
+// FramePart identifies the part of a frame a Difference is in.
+type FramePart int
+
+const (
+	// PartCommand is the frame's command.
+	PartCommand FramePart = iota
+
+	// PartHeader is one of the frame's headers.
+	PartHeader
+
+	// PartBody is the frame's body.
+	PartBody
+)
+
+func (p FramePart) String() string {
+	switch p {
+	case PartCommand:
+		return "command"
+	case PartHeader:
+		return "header"
+	case PartBody:
+		return "body"
+	}
+	return "FramePart(" + strconv.Itoa(int(p)) + ")"
+}
+
+// A Difference is a single way in which two frames differ.
+type Difference struct {
+	// Part is the part of the frames that differs.
+	Part FramePart
+
+	// Name is the name of the header that differs, when Part is
+	// PartHeader.
+	Name string
+
+	// A and B are the values of the part in each frame. A header
+	// missing from a frame has no values; a repeated header has
+	// several. A body has a single value.
+	A, B []string
+
+	// Offset is the index of the first byte at which the bodies
+	// differ, when Part is PartBody.
+	Offset int
+}
+
+func quoteValues(values []string) string {
+	if 0 == len(values) {
+		return "(none)"
+	}
+	quoted := make([]string, len(values))
+
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// excerpt returns the part of body around offset that a Difference
+// renders.
+func excerpt(body string, offset int) string {
+	start := offset - maxDiffBody/2
+
+	if start < 0 {
+		start = 0
+	}
+	end := start + maxDiffBody
+	prefix, suffix := "", ""
+
+	if start > 0 {
+		prefix = "..."
+	}
+
+	if end < len(body) {
+		suffix = "..."
+	} else {
+		end = len(body)
+	}
+
+	if start > end {
+		start = end
+	}
+	return prefix + strconv.Quote(body[start:end]) + suffix
+}
+
+// String renders the difference as a single line, giving the value
+// in frame A after a minus sign and the value in B after a plus.
+func (d Difference) String() string {
+	switch d.Part {
+	case PartHeader:
+		return fmt.Sprintf("header %s: -%s +%s", strconv.Quote(d.Name), quoteValues(d.A), quoteValues(d.B))
+	case PartBody:
+		var a, b string
+
+		if len(d.A) > 0 {
+			a = d.A[0]
+		}
+
+		if len(d.B) > 0 {
+			b = d.B[0]
+		}
+		return fmt.Sprintf("body: -%s +%s (%d bytes, %d bytes; first difference at byte %d)",
+			excerpt(a, d.Offset), excerpt(b, d.Offset), len(a), len(b), d.Offset)
+	}
+	return fmt.Sprintf("%s: -%s +%s", d.Part, quoteValues(d.A), quoteValues(d.B))
+}
+
+// A FrameDiff lists the differences between two frames: the command
+// first, then headers sorted by name, then the body.
+type FrameDiff []Difference
+
+// String renders the differences one per line.
+func (d FrameDiff) String() string {
+	lines := make([]string, len(d))
+
+	for i, diff := range d {
+		lines[i] = diff.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DiffFrames compares frames a and b, returning their differences,
+// which are empty if the frames are equal. Headers are compared by
+// name, and the values of a repeated header in order; the order of
+// distinct headers is not significant, as it is not preserved by a
+// Header. The bodies of both frames are read in full and replaced
+// with readers of the same content, so the frames may still be read
+// or sent. Either frame may be nil, which stands for a heart-beat.
+func DiffFrames(a, b *Frame) (FrameDiff, error) {
+	var diff FrameDiff
+
+	if nil == a || nil == b {
+		if a != b {
+			diff = append(diff, Difference{Part: PartCommand, A: frameCommand(a), B: frameCommand(b)})
+		}
+		return diff, nil
+	}
+
+	if a.Command != b.Command {
+		diff = append(diff, Difference{Part: PartCommand, A: frameCommand(a), B: frameCommand(b)})
+	}
+	union := make(Header, len(a.Header))
+
+	for k := range a.Header {
+		union[k] = nil
+	}
+
+	for k := range b.Header {
+		union[k] = nil
+	}
+
+	for _, k := range sortedHeaderNames(union) {
+		if !equalValues(a.Header[k], b.Header[k]) {
+			diff = append(diff, Difference{Part: PartHeader, Name: k, A: a.Header[k], B: b.Header[k]})
+		}
+	}
+	bodyA, readErr := diffBody(a)
+
+	if nil != readErr {
+		return nil, readErr
+	}
+	bodyB, readErr := diffBody(b)
+
+	if nil != readErr {
+		return nil, readErr
+	}
+
+	if !bytes.Equal(bodyA, bodyB) {
+		offset := 0
+
+		for offset < len(bodyA) && offset < len(bodyB) && bodyA[offset] == bodyB[offset] {
+			offset++
+		}
+
+		diff = append(diff, Difference{
+			Part:   PartBody,
+			A:      []string{string(bodyA)},
+			B:      []string{string(bodyB)},
+			Offset: offset,
+		})
+	}
+	return diff, nil
+}
+
+func frameCommand(f *Frame) []string {
+	if nil == f {
+		return []string{"heart-beat"}
+	}
+	return []string{f.Command.String()}
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diffBody reads f's body for DiffFrames.
+func diffBody(f *Frame) ([]byte, error) {
+	if nil == f.Body {
+		return nil, nil
+	}
+	return bufferBody(f)
+}
@@ -0,0 +1,186 @@
+package stomp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FormatBodyBytes is the number of body bytes Format previews at
+// VerbosityFull.
+const FormatBodyBytes = 512
+
+// Verbosity controls how much of a frame Format renders.
+type Verbosity int
+
+const (
+	// VerbosityBrief renders a single line holding the command,
+	// the headers that identify the frame, and the body length.
+	VerbosityBrief Verbosity = iota
+
+	// VerbosityHeaders renders the command and every header on
+	// lines of their own, followed by the body length.
+	VerbosityHeaders
+
+	// VerbosityFull renders everything VerbosityHeaders does,
+	// followed by a preview of the body: as text when the body is
+	// printable UTF-8, and as a hex dump otherwise.
+	VerbosityFull
+)
+
+// briefHeaders are the headers VerbosityBrief renders, in order.
+var briefHeaders = []string{
+	HdrDestination,
+	HdrId,
+	HdrSubscription,
+	HdrMessageId,
+	HdrTransaction,
+	HdrReceipt,
+	HdrReceiptId,
+	HdrMessage,
+}
+
+// escapeControl renders s with its control characters, backslashes
+// and invalid UTF-8 escaped in the manner of a Go string literal, so
+// that values differing only in such characters can be told apart.
+func escapeControl(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+
+		switch {
+		case utf8.RuneError == r && 1 == size:
+			fmt.Fprintf(&b, `\x%02x`, s[i])
+		case '\\' == r:
+			b.WriteString(`\\`)
+		case r < 0x20 || 0x7f == r:
+			q := strconv.QuoteRune(r)
+			b.WriteString(q[1 : len(q)-1])
+		default:
+			b.WriteRune(r)
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// isText reports whether p is UTF-8 text without control characters
+// other than tabs and line breaks. A truncated p may end part way
+// through a character.
+func isText(p []byte, truncated bool) bool {
+	for i := 0; i < len(p); {
+		r, size := utf8.DecodeRune(p[i:])
+
+		switch {
+		case utf8.RuneError == r && 1 == size:
+			return truncated && !utf8.FullRune(p[i:])
+		case '\t' == r || '\n' == r || '\r' == r:
+		case r < 0x20 || 0x7f == r:
+			return false
+		}
+		i += size
+	}
+	return true
+}
+
+// sortedHeaderNames returns the names in h in lexical order.
+func sortedHeaderNames(h Header) []string {
+	names := make([]string, 0, len(h))
+
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bodyLength describes the length of f's body from its
+// content-length header.
+func bodyLength(f *Frame) string {
+	if nil == f.Body {
+		return "no body"
+	}
+
+	if v, ok := f.Header.Get(HdrContentLength); ok {
+		if n, parseErr := strconv.ParseInt(v, 10, 64); nil == parseErr {
+			if 1 == n {
+				return "1 byte"
+			}
+			return strconv.FormatInt(n, 10) + " bytes"
+		}
+	}
+	return "body of unknown length"
+}
+
+// Format renders the frame for people to read, in the detail given
+// by v. Header names are sorted, and control characters in names
+// and values are escaped. At VerbosityFull, Format reads the start of
+// the body for its preview, replacing Body with a reader that
+// returns the whole body, so the frame may still be read or sent. A
+// nil frame is rendered as a heart-beat.
+func (f *Frame) Format(v Verbosity) string {
+	if nil == f {
+		return "heart-beat"
+	}
+	var b strings.Builder
+
+	if v <= VerbosityBrief {
+		b.WriteString(escapeControl(f.Command.String()))
+
+		for _, k := range briefHeaders {
+			if value, ok := f.Header.Get(k); ok {
+				fmt.Fprintf(&b, " %s=%s", k, escapeControl(value))
+			}
+		}
+		fmt.Fprintf(&b, " (%s)", bodyLength(f))
+		return b.String()
+	}
+	b.WriteString(escapeControl(f.Command.String()))
+	b.WriteByte('\n')
+
+	for _, k := range sortedHeaderNames(f.Header) {
+		for _, value := range f.Header[k] {
+			fmt.Fprintf(&b, "%s:%s\n", escapeControl(k), escapeControl(value))
+		}
+	}
+	fmt.Fprintf(&b, "\n(%s)", bodyLength(f))
+
+	if v < VerbosityFull || nil == f.Body {
+		return b.String()
+	}
+	preview, truncated := peekBody(f, FormatBodyBytes)
+
+	if 0 == len(preview) {
+		return b.String()
+	}
+	b.WriteByte('\n')
+
+	if isText(preview, truncated) {
+		lines := strings.Split(string(preview), "\n")
+
+		for i, line := range lines {
+			b.WriteString(escapeControl(line))
+
+			if i < len(lines)-1 {
+				b.WriteByte('\n')
+			}
+		}
+	} else {
+		b.WriteString(strings.TrimSuffix(hex.Dump(preview), "\n"))
+	}
+
+	if truncated {
+		b.WriteString("\n...")
+	}
+	return b.String()
+}
+
+// String renders the frame at VerbosityHeaders. It never reads the
+// frame's body.
+func (f *Frame) String() string {
+	return f.Format(VerbosityHeaders)
+}
@@ -0,0 +1,105 @@
+package stomp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	f := NewFrame(CmdSend, strings.NewReader("line 1\nline\t2"))
+	f.Header.Set(HdrDestination, "/queue/a")
+	f.Header.Set(HdrReceipt, "r-1")
+	f.Header.Set("x-multi\nline", "a:b\r\x00")
+
+	if have, want := f.Format(VerbosityBrief), "SEND destination=/queue/a receipt=r-1 (13 bytes)"; have != want {
+		t.Errorf("brief:\nhave %q\nwant %q", have, want)
+	}
+	wantHeaders := "SEND\n" +
+		"content-length:13\n" +
+		"destination:/queue/a\n" +
+		"receipt:r-1\n" +
+		"x-multi\\nline:a:b\\r\\x00\n" +
+		"\n" +
+		"(13 bytes)"
+
+	if have := f.String(); have != wantHeaders {
+		t.Errorf("headers:\nhave %q\nwant %q", have, wantHeaders)
+	}
+
+	if have, want := f.Format(VerbosityFull), wantHeaders+"\nline 1\nline\\t2"; have != want {
+		t.Errorf("full:\nhave %q\nwant %q", have, want)
+	}
+	body, _ := ioutil.ReadAll(f.Body)
+
+	if string(body) != "line 1\nline\t2" {
+		t.Errorf("body after Format = %q", body)
+	}
+	bin := NewFrame(CmdMessage, bytes.NewReader([]byte{0x00, 0x01, 'a', 0xff}))
+
+	if have := bin.Format(VerbosityFull); !strings.HasSuffix(have, "(4 bytes)\n00000000  00 01 61 ff                                       |..a.|") {
+		t.Errorf("binary:\n%s", have)
+	}
+	long := NewFrame(CmdMessage, bytes.NewReader(bytes.Repeat([]byte("é"), FormatBodyBytes)))
+	long.Header.Del(HdrContentLength)
+
+	if have := long.Format(VerbosityFull); !strings.HasPrefix(have, "MESSAGE\n\n(body of unknown length)\né") || !strings.HasSuffix(have, "\n...") {
+		t.Errorf("truncated:\n%s", have)
+	}
+	var heartBeat *Frame
+
+	if have := heartBeat.Format(VerbosityFull); have != "heart-beat" {
+		t.Errorf("heart-beat = %q", have)
+	}
+}
+
+func TestDiffFrames(t *testing.T) {
+	a := NewFrame(CmdSend, strings.NewReader("hello world"))
+	a.Header.Set(HdrDestination, "/queue/a")
+	a.Header.Set("x-same", "1")
+	a.Header.Set("x-gone", "1")
+
+	b := NewFrame(CmdMessage, strings.NewReader("hello there"))
+	b.Header.Set(HdrDestination, "/queue/b")
+	b.Header.Set("x-same", "1")
+	b.Header.Set("x-new", "1")
+	b.Header.Append("x-new", "2")
+
+	diff, diffErr := DiffFrames(a, b)
+
+	if nil != diffErr {
+		t.Fatal(diffErr)
+	}
+	want := `command: -"SEND" +"MESSAGE"
+header "destination": -"/queue/a" +"/queue/b"
+header "x-gone": -"1" +(none)
+header "x-new": -(none) +"1", "2"
+body: -"hello world" +"hello there" (11 bytes, 11 bytes; first difference at byte 6)`
+
+	if diff.String() != want {
+		t.Errorf("have:\n%s\nwant:\n%s", diff, want)
+	}
+
+	if diff[4].Part != PartBody || diff[4].Offset != 6 {
+		t.Errorf("body difference = %+v", diff[4])
+	}
+	body, _ := ioutil.ReadAll(a.Body)
+
+	if string(body) != "hello world" {
+		t.Errorf("body after DiffFrames = %q", body)
+	}
+	same := NewFrame(CmdSend, strings.NewReader("hello world"))
+	same.Header.Set(HdrDestination, "/queue/a")
+	same.Header.Set("x-same", "1")
+	same.Header.Set("x-gone", "1")
+	a.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if diff, _ = DiffFrames(a, same); 0 != len(diff) {
+		t.Errorf("unexpected differences:\n%s", diff)
+	}
+
+	if diff, _ = DiffFrames(nil, same); 1 != len(diff) || diff[0].Part != PartCommand {
+		t.Errorf("unexpected differences:\n%s", diff)
+	}
+}
@@ -3,6 +3,7 @@ package stomp
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,6 +14,10 @@ const (
 	defaultMaxHeaderBytes = 1 << 20 // 1 MB
 )
 
+// ErrMalformedFrame is wrapped by the errors ReadFrame returns
+// when the frame read does not conform to the STOMP specification.
+var ErrMalformedFrame = errors.New("malformed frame")
+
 // A Frame represents a STOMP frame received or sent by
 // a server or client.
 type Frame struct {
@@ -84,11 +89,16 @@ func calculateContentLength(r io.Reader) int64 {
 
 // WriteTo writes a STOMP frame, which is the command, header, and body,
 // in wire format. If Body is present, WriteTo closes Body once it
-// has been written in full.
+// has been written in full. A frame whose header cannot be encoded is
+// rejected before anything is written.
 func (f *Frame) WriteTo(w io.Writer) (int64, error) {
 	var bw *bufio.Writer
 	var totalBytesWrt int64
 
+	if validErr := f.Header.validate(); nil != validErr {
+		return totalBytesWrt, validErr
+	}
+
 	if _, ok := w.(io.ByteWriter); !ok {
 		bw = bufio.NewWriter(w)
 		w = bw
@@ -150,7 +160,8 @@ func (f *Frame) WriteTo(w io.Writer) (int64, error) {
 // The body of the frame must be explicitly closed by the reader in
 // order to remove the frame's contents from the stream prior to the
 // next read. A return value of (nil, nil) indicates that a heart-beat
-// was likely received.
+// was likely received. If the stream ends before a command is read,
+// ReadFrame returns io.EOF.
 func ReadFrame(r io.Reader) (*Frame, error) {
 	nullTerminatedReader := delimitReader(r, byteNull)
 	command, cmdRdErr := readCommand(nullTerminatedReader)
@@ -160,6 +171,9 @@ func ReadFrame(r io.Reader) (*Frame, error) {
 	}
 
 	if "" == command {
+		if nullTerminatedReader.eof {
+			return nil, io.EOF
+		}
 		return nil, nil
 	}
 	header, hdrRdErr := readHeader(nullTerminatedReader)
@@ -174,7 +188,7 @@ func ReadFrame(r io.Reader) (*Frame, error) {
 		contentLength, convErr := strconv.ParseInt(contentLengths[0], 10, 64)
 
 		if nil != convErr {
-			return nil, convErr
+			return nil, fmt.Errorf("%w. bad content-length: %v", ErrMalformedFrame, convErr)
 		}
 		contentLengthReader := io.LimitReader(r, contentLength)
 		body = io.MultiReader(contentLengthReader, nullTerminatedReader)
@@ -217,13 +231,15 @@ type delimitedReader struct {
 	reader    io.Reader
 	buf       bytes.Buffer
 	done      bool
+	eof       bool
 }
 
 // Read reads up to len(p) bytes into p from the internal
 // buffer until the delimiter or io.EOF has been reached.
 // If the delimiter is reached, successive calls to Read
 // will result in an io.EOF. The delimiter is not written
-// into p.
+// into p. Whether the internal reader itself reached io.EOF
+// is recorded in eof.
 func (d *delimitedReader) Read(p []byte) (int, error) {
 	if d.done {
 		return 0, io.EOF
@@ -248,6 +264,7 @@ func (d *delimitedReader) Read(p []byte) (int, error) {
 		}
 
 		if nil != rdErr {
+			d.eof = io.EOF == rdErr
 			return totalRead, rdErr
 		}
 	}
@@ -313,7 +330,7 @@ func readHeader(r io.Reader) (Header, error) {
 		ndx := bytes.IndexByte(hdrLine, byteColon)
 
 		if ndx <= 0 {
-			return nil, fmt.Errorf("malformed header. got %v", hdrLine)
+			return nil, fmt.Errorf("%w. bad header: %q", ErrMalformedFrame, hdrLine)
 		}
 		name := decode(string(hdrLine[0:ndx]))
 		value := decode(string(hdrLine[ndx+1:]))
@@ -321,3 +338,49 @@ func readHeader(r io.Reader) (Header, error) {
 	}
 	return header, nil
 }
+
+// bufferBody reads the frame's body in full, closes it, and replaces
+// it with an unread copy of its contents, which are also returned.
+func bufferBody(f *Frame) ([]byte, error) {
+	if nil == f.Body {
+		return nil, nil
+	}
+	body, readErr := ioutil.ReadAll(f.Body)
+	closeErr := f.Body.Close()
+	f.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if nil != readErr {
+		return body, readErr
+	}
+	return body, closeErr
+}
+
+// bufferBodyLimit is bufferBody for bodies of at most limit bytes,
+// or of any size when limit is not positive. A larger body, or one
+// whose content-length header announces one, is refused with
+// ErrBodyTooLarge and left open, with no more than limit+1 bytes of
+// it read.
+func bufferBodyLimit(f *Frame, limit int64) ([]byte, error) {
+	if limit <= 0 || nil == f.Body {
+		return bufferBody(f)
+	}
+	tooLarge := fmt.Errorf("%w. the limit is %d bytes", ErrBodyTooLarge, limit)
+
+	if v, ok := f.Header.Get(HdrContentLength); ok {
+		if n, parseErr := strconv.ParseInt(v, 10, 64); nil == parseErr && n > limit {
+			return nil, tooLarge
+		}
+	}
+	body, readErr := ioutil.ReadAll(io.LimitReader(f.Body, limit+1))
+
+	if nil == readErr && int64(len(body)) > limit {
+		return nil, tooLarge
+	}
+	closeErr := f.Body.Close()
+	f.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if nil != readErr {
+		return body, readErr
+	}
+	return body, closeErr
+}
@@ -0,0 +1,197 @@
+//go:build go1.18
+// +build go1.18
+
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// fuzzSeeds is the seed corpus of raw streams shared by the fuzz
+// targets that parse arbitrary input.
+var fuzzSeeds = []string{
+	"",
+	"\n",
+	"\r\n\n\r\n",
+	"SEND\ndestination:/queue/a\n\nhello\x00",
+	"SEND\r\ndestination:/queue/a\r\n\r\nhello\x00\r\n",
+	"MESSAGE\nkey\\cwith\\ccolons:value\\nwith\\r\\nbreaks\\\\\n\n\x00",
+	"SEND\ncontent-length:5\n\nab\x00cd\x00",
+	"SEND\ncontent-length:0\n\n\x00\n\nCOMMIT\ntransaction:tx\n\n\x00",
+	"SEND\ncontent-length:-1\n\nbody\x00",
+	"SEND\ncontent-length:nope\n\nbody\x00",
+	"SEND\nno-colon\n\n\x00",
+	"SEND\n:empty-name\n\n\x00",
+	"SEND\ndestination:/queue/a",
+	"CONNECT\naccept-version:1.2\nhost:/\nheart-beat:0,0\n\n\x00",
+}
+
+// readAll reads every frame in data, failing if a read does not
+// consume any input, which would make a reader loop forever.
+func readAll(t *testing.T, data []byte) ([]*Frame, error) {
+	r := bytes.NewReader(data)
+	var frames []*Frame
+
+	for {
+		before := r.Len()
+		f, readErr := ReadFrame(r)
+
+		if nil != readErr {
+			return frames, readErr
+		}
+
+		if nil != f {
+			body, bodyErr := bufferBody(f)
+
+			if nil != bodyErr {
+				t.Fatalf("reading body: %v", bodyErr)
+			}
+			f.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		frames = append(frames, f)
+
+		if r.Len() == before {
+			t.Fatalf("ReadFrame made no progress with %d bytes left", before)
+		}
+	}
+}
+
+func FuzzReadFrame(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frames, readErr := readAll(t, data)
+
+		if io.EOF == readErr {
+			readErr = nil
+		}
+
+		if nil != readErr && io.ErrUnexpectedEOF != readErr && !errors.Is(readErr, ErrMalformedFrame) {
+			t.Fatalf("unexpected error: %v", readErr)
+		}
+
+		for _, frame := range frames {
+			frame.Format(VerbosityFull)
+		}
+	})
+}
+
+// fuzzCommands are the commands the round-trip targets choose from.
+var fuzzCommands = []Command{
+	CmdConnect, CmdSend, CmdSubscribe, CmdAck, CmdMessage, CmdReceipt, CmdError,
+}
+
+// fuzzFrame builds a frame from fuzz input, reporting false when the
+// input cannot be represented in a frame.
+func fuzzFrame(cmd uint8, key, value string, body []byte) (*Frame, bool) {
+	if "" == key || HdrContentLength == key {
+		return nil, false
+	}
+	f := NewFrame(fuzzCommands[int(cmd)%len(fuzzCommands)], bytes.NewReader(body))
+	f.Header.Set(key, value)
+	f.Header.Append(HdrReceipt, value)
+	return f, true
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add(uint8(0), "accept-version", "1.2", []byte(nil))
+	f.Add(uint8(1), "destination", "/queue/a", []byte("hello"))
+	f.Add(uint8(2), "key:with:colons", "value\nwith\r\nbreaks\\", []byte("ab\x00cd"))
+	f.Add(uint8(4), "x\\c", "\\n\\\\", []byte("\n\n\x00\r\n"))
+
+	f.Fuzz(func(t *testing.T, cmd uint8, key, value string, body []byte) {
+		want, ok := fuzzFrame(cmd, key, value, body)
+
+		if !ok {
+			t.Skip()
+		}
+		var buf bytes.Buffer
+		_, writeErr := want.WriteTo(&buf)
+
+		if strings.IndexByte(key, 0) >= 0 || strings.IndexByte(value, 0) >= 0 {
+			if !errors.Is(writeErr, ErrMalformedFrame) {
+				t.Fatalf("wrote header %q:%q containing null", key, value)
+			}
+			return
+		}
+
+		if nil != writeErr {
+			t.Fatal(writeErr)
+		}
+		want.Body = ioutil.NopCloser(bytes.NewReader(body))
+		frames, readErr := readAll(t, buf.Bytes())
+
+		if io.EOF != readErr {
+			t.Fatalf("reading %q: %v", buf.Bytes(), readErr)
+		}
+
+		if 1 != len(frames) {
+			t.Fatalf("read %d frames from %q", len(frames), buf.Bytes())
+		}
+		diff, diffErr := DiffFrames(want, frames[0])
+
+		if nil != diffErr {
+			t.Fatal(diffErr)
+		}
+
+		if 0 != len(diff) {
+			t.Fatalf("frame changed in transit:\n%s", diff)
+		}
+	})
+}
+
+func FuzzStream(f *testing.F) {
+	f.Add([]byte("hello"), []byte("world"), uint8(0))
+	f.Add([]byte("a\x00b"), []byte(""), uint8(3))
+	f.Add([]byte(""), []byte("\n\x00\n"), uint8(1))
+
+	f.Fuzz(func(t *testing.T, first, second []byte, heartBeats uint8) {
+		var buf bytes.Buffer
+		var want []*Frame
+		beats := int(heartBeats % 4)
+
+		for i, body := range [][]byte{first, second, first} {
+			frame := NewFrame(CmdMessage, bytes.NewReader(body))
+			frame.Header.Set(HdrMessageId, string(rune('a'+i)))
+
+			if _, writeErr := frame.WriteTo(&buf); nil != writeErr {
+				t.Fatal(writeErr)
+			}
+			frame.Body = ioutil.NopCloser(bytes.NewReader(body))
+			want = append(want, frame)
+
+			for j := 0; j < beats; j++ {
+				buf.WriteString("\r\n")
+				want = append(want, nil)
+			}
+		}
+		frames, readErr := readAll(t, buf.Bytes())
+
+		if io.EOF != readErr {
+			t.Fatalf("reading %q: %v", buf.Bytes(), readErr)
+		}
+
+		if len(want) != len(frames) {
+			t.Fatalf("read %d frames from %q, want %d", len(frames), buf.Bytes(), len(want))
+		}
+
+		for i := range want {
+			diff, diffErr := DiffFrames(want[i], frames[i])
+
+			if nil != diffErr {
+				t.Fatal(diffErr)
+			}
+
+			if 0 != len(diff) {
+				t.Fatalf("frame %d lost synchronization:\n%s", i, diff)
+			}
+		}
+	})
+}
@@ -2,9 +2,11 @@ package stomp
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"go/token"
 	"io"
+	"io/ioutil"
 	"reflect"
 	"strings"
 	"testing"
@@ -179,3 +181,79 @@ func diff(t *testing.T, prefix string, have, want interface{}) {
 		}
 	}
 }
+
+func TestWriteFrameNullHeader(t *testing.T) {
+	f := NewFrame(CmdSend, strings.NewReader("body"))
+	f.Header.Set(HdrDestination, "/queue/a\x00")
+	var buf bytes.Buffer
+
+	if n, writeErr := f.WriteTo(&buf); !errors.Is(writeErr, ErrMalformedFrame) || 0 != n {
+		t.Fatalf("frame written as %d, %v", n, writeErr)
+	}
+
+	if 0 != buf.Len() {
+		t.Errorf("rejected frame left %q", buf.String())
+	}
+}
+
+func TestReadFrameEOF(t *testing.T) {
+	r := strings.NewReader("\nSEND\n\nbody\x00")
+
+	if f, readErr := ReadFrame(r); nil != f || nil != readErr {
+		t.Fatalf("heart-beat read as %v, %v", f, readErr)
+	}
+	f, readErr := ReadFrame(r)
+
+	if nil != readErr || CmdSend != f.Command {
+		t.Fatalf("frame read as %v, %v", f, readErr)
+	}
+	f.Body.Close()
+
+	for i := 0; i < 2; i++ {
+		if f, readErr := ReadFrame(r); nil != f || io.EOF != readErr {
+			t.Fatalf("end of stream read as %v, %v", f, readErr)
+		}
+	}
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, readErr := c.r.Read(p)
+	c.n += n
+	return n, readErr
+}
+
+func TestBufferBodyLimit(t *testing.T) {
+	// A body refused by its content-length header is not read, and
+	// no more than one byte past the limit is read of another.
+	for _, test := range []struct {
+		contentLength string
+		read          int
+	}{{"", 11}, {"1000", 0}} {
+		r := &countingReader{r: strings.NewReader(strings.Repeat("x", 1000))}
+		f := &Frame{Command: CmdSend, Header: make(Header), Body: ioutil.NopCloser(r)}
+
+		if "" != test.contentLength {
+			f.Header.Set(HdrContentLength, test.contentLength)
+		}
+
+		if _, bodyErr := bufferBodyLimit(f, 10); !errors.Is(bodyErr, ErrBodyTooLarge) || test.read != r.n {
+			t.Errorf("content-length %q: %d bytes read, error %v", test.contentLength, r.n, bodyErr)
+		}
+	}
+	f := NewFrame(CmdSend, strings.NewReader("short"))
+	body, bodyErr := bufferBodyLimit(f, 10)
+
+	if nil != bodyErr || "short" != string(body) {
+		t.Fatalf("buffered %q, %v", body, bodyErr)
+	}
+
+	if again, _ := ioutil.ReadAll(f.Body); "short" != string(again) {
+		t.Errorf("body read again as %q", again)
+	}
+}
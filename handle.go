@@ -3,6 +3,7 @@ package stomp
 import (
 	"context"
 	"io"
+	"sync"
 	"time"
 )
 
@@ -18,10 +19,21 @@ type rx struct {
 	done chan struct{}
 }
 
-func newRx(r io.Reader) rx {
+func newRx(r io.Reader, log *loggerRef) rx {
 	ch := make(chan rxpkg)
 	done := make(chan struct{}, 1)
 
+	// deliver blocks until p is received or the rx is stopped,
+	// reporting whether p was received.
+	deliver := func(p rxpkg) bool {
+		select {
+		case ch <- p:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
 	go func() {
 		ticker := time.NewTicker(time.Nanosecond)
 
@@ -32,23 +44,31 @@ func newRx(r io.Reader) rx {
 				f, readErr := ReadFrame(r)
 
 				if nil != readErr {
-					ch <- rxpkg{nil, readErr}
+					if io.EOF == readErr {
+						log.get().Debug("input stream closed")
+					} else {
+						log.get().Error("failed to read frame", "error", readErr)
+					}
+					deliver(rxpkg{nil, readErr})
+					break loop
 				}
 
 				if nil != f {
 					wrc := newWaitingReadCloser(f.Body)
 					f.Body = wrc
-					ch <- rxpkg{f, nil}
+
+					if !deliver(rxpkg{f, nil}) {
+						break loop
+					}
 					wrc.wait()
-				} else {
-					ch <- rxpkg{nil, nil}
+				} else if !deliver(rxpkg{nil, nil}) {
+					break loop
 				}
 			case <-done:
 				break loop
 			}
 		}
 		ticker.Stop()
-		ch <- rxpkg{nil, io.EOF}
 		close(ch)
 	}()
 
@@ -72,7 +92,7 @@ type tx struct {
 	done chan struct{}
 }
 
-func newTx(w io.Writer) tx {
+func newTx(w io.Writer, log *loggerRef) tx {
 	ch := make(chan txpkg)
 	done := make(chan struct{}, 1)
 
@@ -88,6 +108,10 @@ func newTx(w io.Writer) tx {
 				} else {
 					_, writeErr = p.frame.WriteTo(w)
 				}
+
+				if nil != writeErr {
+					log.get().Error("failed to write frame", "error", writeErr)
+				}
 				p.err <- writeErr
 			case <-done:
 				break loop
@@ -109,27 +133,83 @@ func (x tx) stop() {
 // A Handle provides thead safe methods for reading from
 // and writing to a connection stream.
 type Handle struct {
-	tx  tx
-	rx  rx
+	tx           tx
+	rx           rx
+	log          *loggerRef
+	mu           sync.RWMutex
+	interceptors []Interceptor
+	send         SendFunc
+	receive      ReceiveFunc
 }
 
 // Bind binds a new handle to rw. The handle is available
 // for reading and writing immediately.
 func Bind(rw io.ReadWriter) *Handle {
-	return &Handle{
-		tx: newTx(rw),
-		rx: newRx(rw),
+	log := newLoggerRef()
+	h := &Handle{
+		tx:  newTx(rw, log),
+		rx:  newRx(rw, log),
+		log: log,
+	}
+	h.send = h.transmit
+	h.receive = h.read
+	return h
+}
+
+// Use appends interceptors to the handle's interceptor chain. The
+// first interceptor in the chain sees outbound frames first and
+// inbound frames last. Use is thread safe, and takes effect for
+// calls to Send and Receive made after it returns.
+func (s *Handle) Use(interceptors ...Interceptor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.interceptors = append(s.interceptors, interceptors...)
+	s.send, s.receive = chain(s.interceptors, s.transmit, s.read)
+}
+
+// heartBeatMissed tells the handle's interceptors that observe
+// heart-beats that the peer missed one.
+func (s *Handle) heartBeatMissed() {
+	s.mu.RLock()
+	interceptors := s.interceptors
+	s.mu.RUnlock()
+
+	for _, i := range interceptors {
+		if o, ok := i.(HeartBeatObserver); ok {
+			o.HeartBeatMissed()
+		}
 	}
 }
 
+// SetLogger sets the logger the handle reports stream errors to.
+// A nil logger discards all events, which is the default. To log
+// individual frames, see LogFrames.
+func (s *Handle) SetLogger(l Logger) {
+	s.log.set(l)
+}
+
 // Send sends a frame to the output stream and is thread safe.
 // Send will block until the stream is available for writing.
 // To send a heartbeat to the stream, set the frame argument's
-// value to nil.
+// value to nil. The frame passes through the handle's
+// interceptors before being written.
 func (s *Handle) Send(ctx context.Context, frame *Frame) error {
+	s.mu.RLock()
+	send := s.send
+	s.mu.RUnlock()
+	return send(ctx, frame)
+}
+
+// transmit writes frame to the output stream.
+func (s *Handle) transmit(ctx context.Context, frame *Frame) error {
 	chErr := make(chan error, 1)
 
-	s.tx.c <- txpkg{frame, chErr}
+	select {
+	case s.tx.c <- txpkg{frame, chErr}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
 	select {
 	case txErr := <-chErr:
@@ -149,10 +229,24 @@ func (s *Handle) Send(ctx context.Context, frame *Frame) error {
 // heartbeat is encountered, both the *Frame and error return
 // values will be nil. If an EOF is encountered, the *Frame
 // return value will be nil, and the error return value will
-// be equal to io.EOF.
+// be equal to io.EOF. Once a read error has been returned, the
+// handle reads no further frames, and subsequent calls to Receive
+// return io.EOF. The frame passes through the handle's
+// interceptors before being returned.
 func (s *Handle) Receive(ctx context.Context) (*Frame, error) {
+	s.mu.RLock()
+	receive := s.receive
+	s.mu.RUnlock()
+	return receive(ctx)
+}
+
+// read reads the next frame from the input stream.
+func (s *Handle) read(ctx context.Context) (*Frame, error) {
 	select {
-	case p := <-s.rx.c:
+	case p, ok := <-s.rx.c:
+		if !ok {
+			return nil, io.EOF
+		}
 		return p.frame, p.err
 	case <-ctx.Done():
 		return nil, ctx.Err()
@@ -0,0 +1,87 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingReader returns its frames, then err, counting the reads made
+// after it failed.
+type failingReader struct {
+	r     io.Reader
+	err   error
+	after int
+}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	n, readErr := fr.r.Read(p)
+
+	if io.EOF != readErr {
+		return n, readErr
+	}
+
+	if 0 == n {
+		fr.after++
+	}
+	return n, fr.err
+}
+
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func TestHandleReceiveAfterError(t *testing.T) {
+	failure := errors.New("connection reset")
+	r := &failingReader{r: strings.NewReader("SEND\n\n\x00"), err: failure}
+	h := Bind(readWriter{r, ioutil.Discard})
+	defer h.Release()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	f, receiveErr := h.Receive(ctx)
+
+	if nil != receiveErr || CmdSend != f.Command {
+		t.Fatalf("received %v, %v", f, receiveErr)
+	}
+	f.Body.Close()
+
+	if _, receiveErr := h.Receive(ctx); failure != receiveErr {
+		t.Fatalf("expected %v, got %v", failure, receiveErr)
+	}
+
+	// The handle reads nothing more once a read has failed.
+	for i := 0; i < 2; i++ {
+		if _, receiveErr := h.Receive(ctx); io.EOF != receiveErr {
+			t.Fatalf("expected %v, got %v", io.EOF, receiveErr)
+		}
+	}
+
+	if 1 != r.after {
+		t.Errorf("%d reads after the failure, expected 1", r.after)
+	}
+}
+
+func TestHandleSendContext(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	h := Bind(readWriter{strings.NewReader(""), pw})
+	defer h.Release()
+
+	// The first frame blocks the writer, and the second cannot be
+	// queued behind it.
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		sendErr := h.Send(ctx, NewFrame(CmdSend, nil))
+		cancel()
+
+		if context.DeadlineExceeded != sendErr {
+			t.Fatalf("send %d: expected %v, got %v", i, context.DeadlineExceeded, sendErr)
+		}
+	}
+}
@@ -86,12 +86,32 @@ func (m Header) Del(key string) {
 	delete(m, key)
 }
 
+// validate reports a header name or value containing a null
+// character, which cannot be encoded.
+func (m Header) validate() error {
+	for k, v := range m {
+		for _, i := range v {
+			if strings.IndexByte(k, 0) >= 0 || strings.IndexByte(i, 0) >= 0 {
+				return fmt.Errorf("%w. header contains null: %q", ErrMalformedFrame, k+":"+i)
+			}
+		}
+	}
+	return nil
+}
+
 // WriteTo writes the header portion of the STOMP frame. The header
 // name and value are encoded according to STOMP the specification.
 // WriteTo returns the total bytes written or an error, if encountered.
+// A header name or value containing a null character cannot be
+// encoded, as it would end the frame; WriteTo rejects such a header
+// before writing anything.
 func (m Header) WriteTo(w io.Writer) (int64, error) {
 	var written int64
 
+	if validErr := m.validate(); nil != validErr {
+		return written, validErr
+	}
+
 	for k, v := range m {
 		for _, i := range v {
 			b, wrtErr := fmt.Fprintf(w, "%s:%s\n", encode(k), encode(i))
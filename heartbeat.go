@@ -0,0 +1,123 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// heartBeatTolerance is the multiple of the negotiated interval that
+// may pass without hearing from a peer before it is considered lost.
+const heartBeatTolerance = 2
+
+// ErrHeartBeatTimeout is the error a session ends with when the peer
+// stops sending frames or heart-beats within the negotiated interval.
+var ErrHeartBeatTimeout = errors.New("heart-beat timeout")
+
+// parseHeartBeat parses the value of a heart-beat header into the
+// smallest interval at which the sender can send heart-beats, and the
+// interval at which it would like to receive them. An empty value
+// is equivalent to "0,0".
+func parseHeartBeat(v string) (send, receive time.Duration, err error) {
+	if "" == v {
+		return 0, 0, nil
+	}
+	parts := strings.Split(v, ",")
+
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("%w. bad heart-beat: %q", ErrMalformedFrame, v)
+	}
+	cx, cxErr := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+	cy, cyErr := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 32)
+
+	if nil != cxErr || nil != cyErr {
+		return 0, 0, fmt.Errorf("%w. bad heart-beat: %q", ErrMalformedFrame, v)
+	}
+	return time.Duration(cx) * time.Millisecond, time.Duration(cy) * time.Millisecond, nil
+}
+
+// formatHeartBeat formats send and receive intervals as the value
+// of a heart-beat header.
+func formatHeartBeat(send, receive time.Duration) string {
+	return strconv.FormatInt(int64(send/time.Millisecond), 10) + "," +
+		strconv.FormatInt(int64(receive/time.Millisecond), 10)
+}
+
+// heartBeatInterval returns the negotiated interval for heart-beats
+// flowing in one direction, given the smallest interval the sender
+// can manage and the interval the receiver wants. Zero means that no
+// heart-beats flow in that direction.
+func heartBeatInterval(send, want time.Duration) time.Duration {
+	if 0 == send || 0 == want {
+		return 0
+	}
+
+	if send > want {
+		return send
+	}
+	return want
+}
+
+// A heartBeater sends heart-beats with transmit, and watches for the
+// peer falling silent.
+type heartBeater struct {
+	transmit SendFunc
+	send     time.Duration
+	receive  time.Duration
+	last     int64
+}
+
+func newHeartBeater(transmit SendFunc, send, receive time.Duration) *heartBeater {
+	hb := &heartBeater{transmit: transmit, send: send, receive: receive}
+	hb.touch()
+	return hb
+}
+
+// touch records that something was heard from the peer.
+func (hb *heartBeater) touch() {
+	atomic.StoreInt64(&hb.last, time.Now().UnixNano())
+}
+
+// run sends heart-beats and watches the peer until done is closed,
+// or until the peer is lost, in which case lost is called.
+func (hb *heartBeater) run(done <-chan struct{}, lost func()) {
+	var sendC, checkC <-chan time.Time
+
+	if hb.send > 0 {
+		t := time.NewTicker(hb.send)
+		defer t.Stop()
+		sendC = t.C
+	}
+
+	if hb.receive > 0 {
+		t := time.NewTicker(hb.receive)
+		defer t.Stop()
+		checkC = t.C
+	}
+
+	if nil == sendC && nil == checkC {
+		return
+	}
+
+	for {
+		select {
+		case <-sendC:
+			ctx, cancel := context.WithTimeout(context.Background(), hb.send)
+			hb.transmit(ctx, nil)
+			cancel()
+		case <-checkC:
+			last := time.Unix(0, atomic.LoadInt64(&hb.last))
+
+			if time.Since(last) > heartBeatTolerance*hb.receive {
+				lost()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
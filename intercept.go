@@ -0,0 +1,70 @@
+package stomp
+
+import "context"
+
+// A SendFunc sends a frame to a connection stream. A nil frame
+// represents a heart-beat.
+type SendFunc func(ctx context.Context, frame *Frame) error
+
+// A ReceiveFunc receives a frame from a connection stream. A nil
+// frame and nil error represent a heart-beat.
+type ReceiveFunc func(ctx context.Context) (*Frame, error)
+
+// An Interceptor sees every frame sent through, or received from, a
+// Handle. An interceptor wraps the next function in the chain and may
+// inspect or mutate frames, reject them by returning an error, or
+// short-circuit the chain by not calling next at all. Heart-beats
+// pass through interceptors as nil frames.
+//
+// An interceptor that drops an inbound frame must close the frame's
+// body, otherwise the handle will not read any further frames.
+type Interceptor interface {
+	InterceptSend(next SendFunc) SendFunc
+	InterceptReceive(next ReceiveFunc) ReceiveFunc
+}
+
+// A HeartBeatObserver is an Interceptor that is told when the peer of
+// the Client or Server session it is installed on misses a
+// heart-beat.
+type HeartBeatObserver interface {
+	Interceptor
+	HeartBeatMissed()
+}
+
+// The SendInterceptor type is an adapter to allow the use of an
+// ordinary function as an Interceptor of outbound frames only.
+type SendInterceptor func(next SendFunc) SendFunc
+
+// InterceptSend calls i(next).
+func (i SendInterceptor) InterceptSend(next SendFunc) SendFunc {
+	return i(next)
+}
+
+// InterceptReceive returns next unchanged.
+func (i SendInterceptor) InterceptReceive(next ReceiveFunc) ReceiveFunc {
+	return next
+}
+
+// The ReceiveInterceptor type is an adapter to allow the use of an
+// ordinary function as an Interceptor of inbound frames only.
+type ReceiveInterceptor func(next ReceiveFunc) ReceiveFunc
+
+// InterceptSend returns next unchanged.
+func (i ReceiveInterceptor) InterceptSend(next SendFunc) SendFunc {
+	return next
+}
+
+// InterceptReceive calls i(next).
+func (i ReceiveInterceptor) InterceptReceive(next ReceiveFunc) ReceiveFunc {
+	return i(next)
+}
+
+// chain wraps send and receive with interceptors. The first
+// interceptor becomes the outermost link of the chain.
+func chain(interceptors []Interceptor, send SendFunc, receive ReceiveFunc) (SendFunc, ReceiveFunc) {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		send = interceptors[i].InterceptSend(send)
+		receive = interceptors[i].InterceptReceive(receive)
+	}
+	return send, receive
+}
@@ -0,0 +1,115 @@
+package stomp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errForbidden = errors.New("forbidden destination")
+
+func TestInterceptors(t *testing.T) {
+	var out bytes.Buffer
+	in := "\n" +
+		"MESSAGE\n" +
+		"destination:/queue/a\n" +
+		"passcode:secret\n" +
+		"\n" +
+		"\x00"
+
+	handle := Bind(captureStream{strings.NewReader(in), &out})
+	defer handle.Release()
+
+	var order []string
+
+	correlate := SendInterceptor(func(next SendFunc) SendFunc {
+		return func(ctx context.Context, f *Frame) error {
+			order = append(order, "correlate")
+			f.Header.Set("correlation-id", "42")
+			return next(ctx, f)
+		}
+	})
+
+	allow := SendInterceptor(func(next SendFunc) SendFunc {
+		return func(ctx context.Context, f *Frame) error {
+			order = append(order, "allow")
+
+			if d, _ := f.Header.Get(HdrDestination); !strings.HasPrefix(d, "/queue/") {
+				return errForbidden
+			}
+			return next(ctx, f)
+		}
+	})
+
+	skipHeartBeats := ReceiveInterceptor(func(next ReceiveFunc) ReceiveFunc {
+		return func(ctx context.Context) (*Frame, error) {
+			for {
+				f, err := next(ctx)
+
+				if nil != f || nil != err {
+					return f, err
+				}
+			}
+		}
+	})
+
+	redact := ReceiveInterceptor(func(next ReceiveFunc) ReceiveFunc {
+		return func(ctx context.Context) (*Frame, error) {
+			f, err := next(ctx)
+
+			if nil != f {
+				if _, ok := f.Header.Get(HdrPasscode); ok {
+					f.Header.Set(HdrPasscode, "********")
+				}
+			}
+			return f, err
+		}
+	})
+	handle.Use(correlate, allow)
+	handle.Use(redact, skipHeartBeats)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	f := NewFrame(CmdSend, nil)
+	f.Header.Set(HdrDestination, "/topic/a")
+
+	if sendErr := handle.Send(ctx, f); errForbidden != sendErr {
+		t.Fatalf("expected %v, got %v", errForbidden, sendErr)
+	}
+
+	if out.Len() != 0 {
+		t.Fatalf("rejected frame was written: %q", out.String())
+	}
+
+	if strings.Join(order, ",") != "correlate,allow" {
+		t.Errorf("interceptor order = %v", order)
+	}
+	f = NewFrame(CmdSend, nil)
+	f.Header.Set(HdrDestination, "/queue/a")
+
+	if sendErr := handle.Send(ctx, f); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+
+	if !strings.Contains(out.String(), "correlation-id:42\n") {
+		t.Errorf("correlation-id not injected: %q", out.String())
+	}
+	msg, readErr := handle.Receive(ctx)
+
+	if nil != readErr {
+		t.Fatal(readErr)
+	}
+
+	if nil == msg {
+		t.Fatal("heart-beat was not skipped")
+	}
+	msg.Body.Close()
+
+	if v, _ := msg.Header.Get(HdrPasscode); v != "********" {
+		t.Errorf("passcode = %q, want redacted", v)
+	}
+}
@@ -0,0 +1,190 @@
+// Package dial connects the repository's commands to STOMP servers
+// named by URL.
+package dial
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+// DefaultTimeout limits connecting to a server when Options does not
+// set a timeout.
+const DefaultTimeout = 10 * time.Second
+
+// Options configure Connect.
+type Options struct {
+	// URL names the server. The schemes stomp and tcp connect over
+	// TCP, to port 61613 by default, and stomp+ssl, stomps and ssl
+	// over TLS, to port 61614 by default. The URL's user and password
+	// are the login and passcode, and its path the virtual host.
+	URL string
+
+	// Login and Passcode, when set, override the URL's user and
+	// password.
+	Login    string
+	Passcode string
+
+	// VHost, when set, is the CONNECT frame's host header in place of
+	// the URL's path, or its host name.
+	VHost string
+
+	// HeartBeat, when set, is the CONNECT frame's heart-beat header.
+	HeartBeat string
+
+	// TLS connects over TLS whatever the URL's scheme.
+	TLS bool
+
+	// TLSConfig, when set, returns the configuration of a TLS
+	// connection to the named server. Nil verifies the server's
+	// certificate against the system's roots.
+	TLSConfig func(serverName string) (*tls.Config, error)
+
+	// Timeout limits dialing, the TLS handshake and the STOMP
+	// handshake together. Zero selects DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Connect dials the server named by opts.URL and performs the STOMP
+// handshake with it.
+func Connect(ctx context.Context, opts Options) (*stomp.Client, error) {
+	u, parseErr := url.Parse(opts.URL)
+
+	if nil != parseErr {
+		return nil, parseErr
+	}
+	useTLS := opts.TLS
+	port := "61613"
+
+	switch u.Scheme {
+	case "stomp", "tcp":
+	case "stomp+ssl", "stomps", "ssl":
+		useTLS = true
+		port = "61614"
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	addr := u.Host
+
+	if "" == u.Port() {
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	header := make(stomp.Header)
+	login, passcode := opts.Login, opts.Passcode
+
+	if nil != u.User {
+		if "" == login {
+			login = u.User.Username()
+		}
+
+		if p, ok := u.User.Password(); ok && "" == passcode {
+			passcode = p
+		}
+	}
+
+	if "" != login {
+		header.Set(stomp.HdrLogin, login)
+	}
+
+	if "" != passcode {
+		header.Set(stomp.HdrPasscode, passcode)
+	}
+	vhost := opts.VHost
+
+	if "" == vhost {
+		vhost = strings.TrimPrefix(u.Path, "/")
+	}
+
+	if "" == vhost {
+		vhost = u.Hostname()
+	}
+	header.Set(stomp.HdrHost, vhost)
+
+	if "" != opts.HeartBeat {
+		header.Set(stomp.HdrHeartBeat, opts.HeartBeat)
+	}
+	timeout := opts.Timeout
+
+	if 0 == timeout {
+		timeout = DefaultTimeout
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, dialErr := dialer.DialContext(dialCtx, "tcp", addr)
+
+	if nil != dialErr {
+		return nil, dialErr
+	}
+
+	if useTLS {
+		tlsConn, tlsErr := handshake(dialCtx, conn, u.Hostname(), opts.TLSConfig)
+
+		if nil != tlsErr {
+			conn.Close()
+			return nil, tlsErr
+		}
+		conn = tlsConn
+	}
+	client, connectErr := stomp.Connect(dialCtx, conn, header, nil)
+
+	if nil != connectErr {
+		conn.Close()
+		return nil, connectErr
+	}
+	return client, nil
+}
+
+// handshake performs the TLS handshake over conn, giving up when ctx
+// is done.
+func handshake(ctx context.Context, conn net.Conn, serverName string, configure func(string) (*tls.Config, error)) (net.Conn, error) {
+	config := &tls.Config{ServerName: serverName}
+
+	if nil != configure {
+		var configErr error
+		config, configErr = configure(serverName)
+
+		if nil != configErr {
+			return nil, configErr
+		}
+	}
+	tlsConn := tls.Client(conn, config)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		tlsConn.SetDeadline(deadline)
+	}
+
+	// Cancelling ctx interrupts the handshake by expiring the
+	// connection's deadline.
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	handshakeErr := tlsConn.Handshake()
+	close(stop)
+	<-stopped
+
+	if nil != handshakeErr {
+		if nil != ctx.Err() {
+			return nil, ctx.Err()
+		}
+		return nil, handshakeErr
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
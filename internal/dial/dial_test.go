@@ -0,0 +1,86 @@
+package dial
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+func TestConnect(t *testing.T) {
+	l, listenErr := net.Listen("tcp", "127.0.0.1:0")
+
+	if nil != listenErr {
+		t.Fatal(listenErr)
+	}
+	sessions := make(chan *stomp.Session, 1)
+	srv := &stomp.Server{Handler: stomp.HandlerFunc(func(s *stomp.Session, f *stomp.Frame) error {
+		sessions <- s
+		return nil
+	})}
+	defer srv.Close()
+	go srv.Serve(l)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, connectErr := Connect(ctx, Options{URL: "stomp://guest:secret@" + l.Addr().String() + "/tenant", Login: "admin"})
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer client.Close()
+	f := stomp.NewFrame(stomp.CmdSend, nil)
+	f.Header.Set(stomp.HdrReceipt, "sent")
+
+	if sendErr := client.Send(ctx, "/queue/a", f); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+	s := <-sessions
+
+	if "tenant" != s.Host() || "admin" != s.Login() {
+		t.Errorf("host = %q, login = %q", s.Host(), s.Login())
+	}
+
+	if _, connectErr := Connect(ctx, Options{URL: "http://" + l.Addr().String()}); nil == connectErr {
+		t.Error("connected with an unsupported scheme")
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	l, listenErr := net.Listen("tcp", "127.0.0.1:0")
+
+	if nil != listenErr {
+		t.Fatal(listenErr)
+	}
+	defer l.Close()
+
+	// The server accepts connections but never answers the handshake.
+	go func() {
+		for {
+			conn, acceptErr := l.Accept()
+
+			if nil != acceptErr {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	url := "stomps://" + l.Addr().String()
+	start := time.Now()
+
+	if _, connectErr := Connect(context.Background(), Options{URL: url, Timeout: 50 * time.Millisecond}); nil == connectErr {
+		t.Fatal("handshake did not time out")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if _, connectErr := Connect(ctx, Options{URL: url, Timeout: time.Minute}); context.Canceled != connectErr {
+		t.Errorf("expected %v, got %v", context.Canceled, connectErr)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("handshakes took %v", elapsed)
+	}
+}
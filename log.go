@@ -0,0 +1,174 @@
+package stomp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+)
+
+// DefaultLogBodyBytes is the number of body bytes LogFrames includes
+// in a log entry when given a negative limit.
+const DefaultLogBodyBytes = 256
+
+// redacted replaces the value of sensitive headers in log entries.
+const redacted = "[REDACTED]"
+
+// RedactedHeaders lists the headers whose values are never logged.
+var RedactedHeaders = []string{HdrPasscode}
+
+// A Logger records events as a message followed by alternating
+// key/value pairs, in the manner of log/slog. A *slog.Logger
+// satisfies Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NopLogger is a Logger that discards every event. Clients, servers
+// and proxies log to it when given no Logger.
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, args ...interface{}) {}
+func (NopLogger) Info(msg string, args ...interface{})  {}
+func (NopLogger) Warn(msg string, args ...interface{})  {}
+func (NopLogger) Error(msg string, args ...interface{}) {}
+
+// loggerRef holds a Logger that may be replaced while it is in
+// use by other goroutines.
+type loggerRef struct {
+	v atomic.Value
+}
+
+type loggerBox struct {
+	Logger
+}
+
+func newLoggerRef() *loggerRef {
+	ref := &loggerRef{}
+	ref.set(nil)
+	return ref
+}
+
+func (r *loggerRef) set(l Logger) {
+	if nil == l {
+		l = NopLogger{}
+	}
+	r.v.Store(loggerBox{l})
+}
+
+func (r *loggerRef) get() Logger {
+	return r.v.Load().(loggerBox).Logger
+}
+
+// RedactHeader returns a copy of h in which the values of the
+// RedactedHeaders have been replaced.
+func RedactHeader(h Header) Header {
+	c := make(Header, len(h))
+
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+
+	for _, k := range RedactedHeaders {
+		if v, ok := c[k]; ok {
+			for i := range v {
+				v[i] = redacted
+			}
+		}
+	}
+	return c
+}
+
+// LogFrames returns an interceptor that logs every frame, and
+// heart-beat, passing through it at debug level. Sensitive header
+// values are redacted. At most maxBody bytes of each frame body are
+// logged; a negative maxBody selects DefaultLogBodyBytes, and zero
+// omits bodies altogether.
+func LogFrames(l Logger, maxBody int) Interceptor {
+	if maxBody < 0 {
+		maxBody = DefaultLogBodyBytes
+	}
+	return &frameLogger{l, maxBody}
+}
+
+type frameLogger struct {
+	l       Logger
+	maxBody int
+}
+
+func (fl *frameLogger) InterceptSend(next SendFunc) SendFunc {
+	return func(ctx context.Context, f *Frame) error {
+		if nil == f {
+			fl.l.Debug("sending heart-beat")
+		} else {
+			fl.l.Debug("sending frame", fl.args(f)...)
+		}
+		return next(ctx, f)
+	}
+}
+
+func (fl *frameLogger) InterceptReceive(next ReceiveFunc) ReceiveFunc {
+	return func(ctx context.Context) (*Frame, error) {
+		f, readErr := next(ctx)
+
+		if nil != f {
+			fl.l.Debug("received frame", fl.args(f)...)
+		} else if nil == readErr {
+			fl.l.Debug("received heart-beat")
+		}
+		return f, readErr
+	}
+}
+
+func (fl *frameLogger) args(f *Frame) []interface{} {
+	args := []interface{}{
+		"command", f.Command.String(),
+		"header", RedactHeader(f.Header),
+	}
+
+	if fl.maxBody == 0 {
+		return args
+	}
+	preview, truncated := peekBody(f, fl.maxBody)
+	args = append(args, "body", string(preview))
+
+	if truncated {
+		args = append(args, "truncated", true)
+	}
+	return args
+}
+
+// peekBody returns up to n bytes from the start of the frame body
+// without consuming them, and whether the body holds more than n
+// bytes. The frame's body is replaced with one that yields the
+// peeked bytes followed by the rest of the original body.
+func peekBody(f *Frame, n int) ([]byte, bool) {
+	if nil == f.Body {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	_, readErr := io.CopyN(&buf, f.Body, int64(n+1))
+	peeked := buf.Bytes()
+
+	f.Body = &peekedBody{
+		Reader: io.MultiReader(bytes.NewReader(peeked), f.Body),
+		body:   f.Body,
+	}
+
+	if nil == readErr && len(peeked) > n {
+		return peeked[:n], true
+	}
+	return peeked, false
+}
+
+type peekedBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (p *peekedBody) Close() error {
+	return p.body.Close()
+}
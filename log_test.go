@@ -0,0 +1,104 @@
+package stomp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var _ Logger = (*slog.Logger)(nil)
+
+type logEntry struct {
+	level string
+	msg   string
+	args  map[string]interface{}
+}
+
+type testLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *testLogger) log(level, msg string, args []interface{}) {
+	e := logEntry{level, msg, make(map[string]interface{})}
+
+	for i := 0; i+1 < len(args); i += 2 {
+		e.args[fmt.Sprint(args[i])] = args[i+1]
+	}
+	l.mu.Lock()
+	l.entries = append(l.entries, e)
+	l.mu.Unlock()
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.log("debug", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log("info", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log("warn", msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.log("error", msg, args) }
+
+func TestLogFrames(t *testing.T) {
+	var out bytes.Buffer
+	in := "MESSAGE\ncontent-length:x\n\n\x00"
+	handle := Bind(captureStream{strings.NewReader(in), &out})
+	defer handle.Release()
+
+	logger := &testLogger{}
+	handle.SetLogger(logger)
+	handle.Use(LogFrames(logger, 4))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	f := NewFrame(CmdConnect, bytes.NewBufferString("abcdefgh"))
+	f.Header.Set(HdrLogin, "guest")
+	f.Header.Set(HdrPasscode, "secret")
+
+	if sendErr := handle.Send(ctx, f); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+
+	if !strings.Contains(out.String(), "passcode:secret\n") || !strings.HasSuffix(out.String(), "\nabcdefgh\x00") {
+		t.Errorf("logging altered the frame on the wire: %q", out.String())
+	}
+
+	if sendErr := handle.Send(ctx, nil); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+
+	if _, readErr := handle.Receive(ctx); nil == readErr {
+		t.Fatal("expected a read error")
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+
+	entries := make(map[string]logEntry)
+
+	for _, e := range logger.entries {
+		entries[e.msg] = e
+	}
+	sent := entries["sending frame"]
+
+	if sent.level != "debug" || sent.args["command"] != "CONNECT" {
+		t.Fatalf("unexpected entry %v", sent)
+	}
+
+	if h := sent.args["header"].(Header); h[HdrPasscode][0] != redacted || h[HdrLogin][0] != "guest" {
+		t.Errorf("header not redacted: %v", h)
+	}
+
+	if sent.args["body"] != "abcd" || sent.args["truncated"] != true {
+		t.Errorf("body not truncated: %v", sent.args)
+	}
+
+	if _, ok := entries["sending heart-beat"]; !ok {
+		t.Errorf("heart-beat not logged: %v", logger.entries)
+	}
+
+	if e := entries["failed to read frame"]; e.level != "error" || nil == e.args["error"] {
+		t.Errorf("read error not logged: %v", logger.entries)
+	}
+}
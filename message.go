@@ -0,0 +1,168 @@
+package stomp
+
+import (
+	"bytes"
+	"context"
+)
+
+// A Message is a MESSAGE frame received through a Messenger, with
+// its body read in full and decodable with the codec for its content
+// type.
+type Message struct {
+	// Frame is the MESSAGE frame. Pass it to Client.Ack and
+	// Client.Nack to acknowledge the message.
+	Frame *Frame
+
+	// Body is the message body.
+	Body []byte
+
+	codecs *CodecRegistry
+}
+
+// Header returns the header of the message's frame.
+func (m *Message) Header() Header {
+	return m.Frame.Header
+}
+
+// Destination returns the destination the message was sent to.
+func (m *Message) Destination() string {
+	v, _ := m.Frame.Header.Get(HdrDestination)
+	return v
+}
+
+// ContentType returns the message's content type, which is empty
+// when the frame has no content-type header.
+func (m *Message) ContentType() string {
+	v, _ := m.Frame.Header.Get(HdrContentType)
+	return v
+}
+
+// Decode unmarshals the body into v with the codec registered for
+// the message's content type.
+func (m *Message) Decode(v interface{}) error {
+	codec, lookupErr := m.codecs.Lookup(m.ContentType())
+
+	if nil != lookupErr {
+		return lookupErr
+	}
+	return codec.Unmarshal(m.Body, v)
+}
+
+// A Messenger sends and receives values, rather than frames, over a
+// Client, marshalling them with the codecs of its registry. The
+// content-type header of each message selects the codec that
+// decodes it.
+type Messenger struct {
+	// Client is the session messages are sent and received on.
+	Client *Client
+
+	// Codecs holds the codecs the messenger uses. Nil selects
+	// DefaultCodecs.
+	Codecs *CodecRegistry
+
+	// ContentType is the content type of the messages Send sends.
+	// Empty selects JSONCodec's content type.
+	ContentType string
+}
+
+// NewMessenger returns a messenger for c that sends JSON, and
+// decodes with DefaultCodecs.
+func NewMessenger(c *Client) *Messenger {
+	return &Messenger{Client: c}
+}
+
+func (m *Messenger) codecs() *CodecRegistry {
+	if nil == m.Codecs {
+		return DefaultCodecs
+	}
+	return m.Codecs
+}
+
+// Encode returns a SEND frame carrying v, marshalled with the codec
+// for contentType, which sets the frame's content-type header. An
+// empty contentType selects the messenger's ContentType. The frame
+// may be given further headers, then sent with Client.Send.
+func (m *Messenger) Encode(contentType string, v interface{}) (*Frame, error) {
+	if "" == contentType {
+		contentType = m.ContentType
+	}
+
+	if "" == contentType {
+		contentType = JSONCodec.ContentType()
+	}
+	codec, lookupErr := m.codecs().Lookup(contentType)
+
+	if nil != lookupErr {
+		return nil, lookupErr
+	}
+	body, marshalErr := codec.Marshal(v)
+
+	if nil != marshalErr {
+		return nil, marshalErr
+	}
+	f := NewFrame(CmdSend, bytes.NewReader(body))
+	f.Header.Set(HdrContentType, contentType)
+	return f, nil
+}
+
+// Send marshals v with the codec for the messenger's ContentType,
+// and sends it to destination.
+func (m *Messenger) Send(ctx context.Context, destination string, v interface{}) error {
+	f, encodeErr := m.Encode("", v)
+
+	if nil != encodeErr {
+		return encodeErr
+	}
+	return m.Client.Send(ctx, destination, f)
+}
+
+// Message wraps a MESSAGE frame, reading its body in full. The
+// frame's body is replaced with an unread copy.
+func (m *Messenger) Message(f *Frame) (*Message, error) {
+	body, readErr := bufferBody(f)
+
+	if nil != readErr {
+		return nil, readErr
+	}
+	return &Message{Frame: f, Body: body, codecs: m.codecs()}, nil
+}
+
+// Subscribe subscribes to destination, as Client.Subscribe does,
+// returning a subscription that receives messages.
+func (m *Messenger) Subscribe(ctx context.Context, destination string, header Header) (*MessageSubscription, error) {
+	sub, subscribeErr := m.Client.Subscribe(ctx, destination, header)
+
+	if nil != subscribeErr {
+		return nil, subscribeErr
+	}
+	return &MessageSubscription{Subscription: sub, m: m}, nil
+}
+
+// A MessageSubscription is a Subscription whose Receive method
+// returns messages rather than frames.
+type MessageSubscription struct {
+	*Subscription
+	m *Messenger
+}
+
+// Receive returns the next message for the subscription.
+func (s *MessageSubscription) Receive(ctx context.Context) (*Message, error) {
+	f, receiveErr := s.Subscription.Receive(ctx)
+
+	if nil != receiveErr {
+		return nil, receiveErr
+	}
+	return s.m.Message(f)
+}
+
+// ReceiveValue returns the next message for the subscription, having
+// decoded its body into v. The message is returned even if it cannot
+// be decoded, so that it may be rejected.
+func (s *MessageSubscription) ReceiveValue(ctx context.Context, v interface{}) (*Message, error) {
+	msg, receiveErr := s.Receive(ctx)
+
+	if nil != receiveErr {
+		return nil, receiveErr
+	}
+	return msg, msg.Decode(v)
+}
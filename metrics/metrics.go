@@ -0,0 +1,280 @@
+// Package metrics instruments STOMP connections. Measurements are
+// reported to a Collector, and the Registry collector exposes them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+// maxPendingAcks bounds the number of messages awaiting acknowledgement
+// that an interceptor tracks for latency measurements.
+const maxPendingAcks = 1 << 16
+
+// A Collector receives measurements from instrumented connections.
+// Implementations must be safe for concurrent use.
+type Collector interface {
+	// FrameSent records a frame written to the wire, and the
+	// number of bytes it occupied.
+	FrameSent(command stomp.Command, bytes int64)
+
+	// FrameReceived records a frame read from the wire, and the
+	// number of bytes it occupied.
+	FrameReceived(command stomp.Command, bytes int64)
+
+	// AckLatency records the time between the receipt of a message
+	// and its acknowledgement.
+	AckLatency(d time.Duration)
+
+	// HeartBeatMissed records an expected heart-beat that did not
+	// arrive in time, as reported by a Client or Server.
+	HeartBeatMissed()
+
+	// ParseError records a frame that could not be read.
+	ParseError()
+
+	// Reconnected records the re-establishment of a lost connection.
+	Reconnected()
+
+	// SubscriptionsChanged adjusts the number of active subscriptions
+	// by delta.
+	SubscriptionsChanged(delta int)
+}
+
+// Instrument installs an interceptor on h that reports to c.
+func Instrument(h *stomp.Handle, c Collector) {
+	h.Use(Interceptor(c))
+}
+
+// Interceptor returns an interceptor that reports the frames passing
+// through it to c. Frames are counted in either direction, so the same
+// interceptor instruments clients and servers alike. Installed on a
+// Client or Server session, it also reports the heart-beats the peer
+// misses. Installed on each Client a reconnecting application
+// connects, it reports every CONNECTED frame after the first as a
+// reconnection.
+func Interceptor(c Collector) stomp.Interceptor {
+	return &interceptor{
+		c:       c,
+		subs:    make(map[string]string),
+		pending: make(map[string]pendingAck),
+	}
+}
+
+type pendingAck struct {
+	subscription string
+	received     time.Time
+}
+
+type interceptor struct {
+	c         Collector
+	mu        sync.Mutex
+	subs      map[string]string
+	pending   map[string]pendingAck
+	connected bool
+}
+
+// HeartBeatMissed implements stomp.HeartBeatObserver.
+func (i *interceptor) HeartBeatMissed() {
+	i.c.HeartBeatMissed()
+}
+
+func (i *interceptor) InterceptSend(next stomp.SendFunc) stomp.SendFunc {
+	return func(ctx context.Context, f *stomp.Frame) error {
+		if nil == f {
+			return next(ctx, f)
+		}
+		var body *countingReadCloser
+
+		if nil != f.Body {
+			body = &countingReadCloser{ReadCloser: f.Body}
+			f.Body = body
+		}
+		i.observe(f)
+		size := headerSize(f)
+		sendErr := next(ctx, f)
+
+		if nil != sendErr {
+			return sendErr
+		}
+
+		if nil != body {
+			size += body.n
+		}
+		i.c.FrameSent(f.Command, size)
+		return nil
+	}
+}
+
+func (i *interceptor) InterceptReceive(next stomp.ReceiveFunc) stomp.ReceiveFunc {
+	return func(ctx context.Context) (*stomp.Frame, error) {
+		f, readErr := next(ctx)
+
+		if errors.Is(readErr, stomp.ErrMalformedFrame) {
+			i.c.ParseError()
+		}
+
+		if nil == f {
+			return f, readErr
+		}
+
+		if f.Command == stomp.CmdConnected {
+			i.established()
+		}
+		i.observe(f)
+		size := headerSize(f)
+
+		if nil == f.Body {
+			i.c.FrameReceived(f.Command, size)
+			return f, readErr
+		}
+		f.Body = &countingReadCloser{
+			ReadCloser: f.Body,
+			drain:      true,
+			done: func(n int64) {
+				i.c.FrameReceived(f.Command, size+n)
+			},
+		}
+		return f, readErr
+	}
+}
+
+// established records a CONNECTED frame received. Any but the first
+// reconnects a client, whose subscriptions and unacknowledged messages
+// were lost with its earlier connection.
+func (i *interceptor) established() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if !i.connected {
+		i.connected = true
+		return
+	}
+	i.c.Reconnected()
+
+	if 0 != len(i.subs) {
+		i.c.SubscriptionsChanged(-len(i.subs))
+	}
+	i.subs = make(map[string]string)
+	i.pending = make(map[string]pendingAck)
+}
+
+// observe tracks subscriptions and acknowledgements carried by f.
+func (i *interceptor) observe(f *stomp.Frame) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	switch f.Command {
+	case stomp.CmdSubscribe:
+		id, _ := f.Header.Get(stomp.HdrId)
+		ack, ok := f.Header.Get(stomp.HdrAck)
+
+		if !ok {
+			ack = stomp.AckAuto
+		}
+
+		if _, exists := i.subs[id]; !exists {
+			i.c.SubscriptionsChanged(1)
+		}
+		i.subs[id] = ack
+	case stomp.CmdUnsubscribe:
+		id, _ := f.Header.Get(stomp.HdrId)
+
+		if _, exists := i.subs[id]; exists {
+			delete(i.subs, id)
+			i.c.SubscriptionsChanged(-1)
+		}
+	case stomp.CmdMessage:
+		sub, _ := f.Header.Get(stomp.HdrSubscription)
+
+		if i.subs[sub] == stomp.AckAuto || len(i.pending) >= maxPendingAcks {
+			return
+		}
+		i.pending[ackKey(f)] = pendingAck{sub, time.Now()}
+	case stomp.CmdAck, stomp.CmdNack:
+		key := ackKey(f)
+		p, ok := i.pending[key]
+
+		if !ok {
+			return
+		}
+		delete(i.pending, key)
+		now := time.Now()
+		i.c.AckLatency(now.Sub(p.received))
+
+		if i.subs[p.subscription] != stomp.AckClient {
+			return
+		}
+
+		// client acknowledgement is cumulative
+		for k, v := range i.pending {
+			if v.subscription == p.subscription && !v.received.After(p.received) {
+				delete(i.pending, k)
+				i.c.AckLatency(now.Sub(v.received))
+			}
+		}
+	}
+}
+
+// ackKey returns the value that identifies a message in an ACK or
+// NACK frame: the ack header in STOMP 1.2, or the message-id header
+// in earlier versions.
+func ackKey(f *stomp.Frame) string {
+	if f.Command == stomp.CmdMessage {
+		if v, ok := f.Header.Get(stomp.HdrAck); ok {
+			return v
+		}
+		v, _ := f.Header.Get(stomp.HdrMessageId)
+		return v
+	}
+
+	if v, ok := f.Header.Get(stomp.HdrId); ok {
+		return v
+	}
+	v, _ := f.Header.Get(stomp.HdrMessageId)
+	return v
+}
+
+// headerSize returns the number of bytes f occupies on the wire,
+// excluding its body.
+func headerSize(f *stomp.Frame) int64 {
+	n, _ := f.Header.WriteTo(ioutil.Discard)
+	// command, new lines, and the terminating null
+	return n + int64(len(f.Command)) + 3
+}
+
+// countingReadCloser counts the bytes read through it.
+type countingReadCloser struct {
+	io.ReadCloser
+	n     int64
+	drain bool
+	done  func(n int64)
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Close reads what remains of the body, when draining, before
+// closing it and reporting the count.
+func (c *countingReadCloser) Close() error {
+	if c.drain {
+		io.Copy(ioutil.Discard, c)
+	}
+	closeErr := c.ReadCloser.Close()
+
+	if nil != c.done {
+		c.done(c.n)
+		c.done = nil
+	}
+	return closeErr
+}
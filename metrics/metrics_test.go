@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+type stream struct {
+	io.Reader
+	io.Writer
+}
+
+func TestInstrument(t *testing.T) {
+	in := "MESSAGE\n" +
+		"subscription:0\n" +
+		"message-id:007\n" +
+		"ack:a-1\n" +
+		"\n" +
+		"hello\x00" +
+		"MESSAGE\n" +
+		"content-length:x\n" +
+		"\n" +
+		"\x00"
+
+	var out bytes.Buffer
+	handle := stomp.Bind(stream{strings.NewReader(in), &out})
+	defer handle.Release()
+
+	registry := NewRegistry("")
+	Instrument(handle, registry)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub := stomp.NewFrame(stomp.CmdSubscribe, nil)
+	sub.Header.Set(stomp.HdrId, "0")
+	sub.Header.Set(stomp.HdrDestination, "/queue/a")
+	sub.Header.Set(stomp.HdrAck, stomp.AckClientIndividual)
+
+	if sendErr := handle.Send(ctx, sub); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+	msg, readErr := handle.Receive(ctx)
+
+	if nil != readErr {
+		t.Fatal(readErr)
+	}
+	msg.Body.Close()
+
+	ack := stomp.NewFrame(stomp.CmdAck, nil)
+	ack.Header.Set(stomp.HdrId, "a-1")
+
+	if sendErr := handle.Send(ctx, ack); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+
+	if _, readErr = handle.Receive(ctx); nil == readErr {
+		t.Fatal("expected a parse error")
+	}
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	text := string(body)
+
+	for _, want := range []string{
+		`stomp_frames_sent_total{command="ACK"} 1`,
+		`stomp_frames_sent_total{command="SUBSCRIBE"} 1`,
+		`stomp_frames_received_total{command="MESSAGE"} 1`,
+		`stomp_bytes_received_total{command="MESSAGE"} 53`,
+		`stomp_ack_latency_seconds_count 1`,
+		`stomp_ack_latency_seconds_bucket{le="+Inf"} 1`,
+		`stomp_parse_errors_total 1`,
+		`stomp_active_subscriptions 1`,
+		`# TYPE stomp_ack_latency_seconds histogram`,
+	} {
+		if !strings.Contains(text, want+"\n") {
+			t.Errorf("missing %q in:\n%s", want, text)
+		}
+	}
+
+	if sent := registry.bytesSent[stomp.CmdSubscribe]; sent != uint64(out.Len()-len("ACK\nid:a-1\n\n\x00")) {
+		t.Errorf("bytes sent = %d, wire = %d", sent, out.Len())
+	}
+}
+
+func TestHeartBeatMissed(t *testing.T) {
+	registry := NewRegistry("")
+	srv := &stomp.Server{
+		Handler:   stomp.HandlerFunc(func(s *stomp.Session, f *stomp.Frame) error { return nil }),
+		HeartBeat: 20 * time.Millisecond,
+		Interceptors: func() []stomp.Interceptor {
+			return []stomp.Interceptor{Interceptor(registry)}
+		},
+	}
+	defer srv.Close()
+	client, server := net.Pipe()
+	go srv.ServeConn(server)
+	handle := stomp.Bind(client)
+	defer handle.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The client promises heart-beats it never sends.
+	connect := stomp.NewFrame(stomp.CmdConnect, nil)
+	connect.Header.Set(stomp.HdrAcceptVersion, "1.2")
+	connect.Header.Set(stomp.HdrHeartBeat, "20,0")
+
+	if sendErr := handle.Send(ctx, connect); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+
+	for {
+		f, readErr := handle.Receive(ctx)
+
+		if nil != readErr {
+			break
+		}
+
+		if nil != f {
+			f.Body.Close()
+		}
+	}
+	text := exposition(registry)
+
+	if !strings.Contains(text, "stomp_heartbeat_misses_total 1\n") {
+		t.Errorf("heart-beat miss not counted in:\n%s", text)
+	}
+}
+
+// exposition returns the text registry serves.
+func exposition(registry *Registry) string {
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestUnknownCommands(t *testing.T) {
+	handle := stomp.Bind(stream{strings.NewReader("FOO\n\n\x00BAR\n\n\x00"), ioutil.Discard})
+	defer handle.Release()
+	registry := NewRegistry("")
+	Instrument(handle, registry)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		f, readErr := handle.Receive(ctx)
+
+		if nil != readErr {
+			t.Fatal(readErr)
+		}
+		f.Body.Close()
+	}
+	text := exposition(registry)
+
+	if !strings.Contains(text, `stomp_frames_received_total{command="unknown"} 2`+"\n") || strings.Contains(text, "FOO") {
+		t.Errorf("commands not bucketed in:\n%s", text)
+	}
+}
+
+func TestReconnected(t *testing.T) {
+	registry := NewRegistry("")
+	srv := &stomp.Server{
+		Handler: stomp.HandlerFunc(func(s *stomp.Session, f *stomp.Frame) error { return nil }),
+	}
+	defer srv.Close()
+	instrument := Interceptor(registry)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		conn, server := net.Pipe()
+		go srv.ServeConn(server)
+		client, connectErr := stomp.Connect(ctx, conn, nil, &stomp.ClientOptions{
+			Interceptors: []stomp.Interceptor{instrument},
+		})
+
+		if nil != connectErr {
+			t.Fatal(connectErr)
+		}
+
+		if _, subErr := client.Subscribe(ctx, "/queue/a", nil); nil != subErr {
+			t.Fatal(subErr)
+		}
+		client.Close()
+	}
+	text := exposition(registry)
+
+	if !strings.Contains(text, "stomp_reconnects_total 1\n") {
+		t.Errorf("reconnect not counted in:\n%s", text)
+	}
+
+	if !strings.Contains(text, "stomp_active_subscriptions 1\n") {
+		t.Errorf("lost subscriptions not dropped in:\n%s", text)
+	}
+}
@@ -0,0 +1,247 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the
+// acknowledgement latency histogram buckets used by NewRegistry.
+var DefaultLatencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// unknownCommand labels the frames whose command STOMP does not define,
+// so that a peer making commands up cannot add series at will.
+const unknownCommand stomp.Command = "unknown"
+
+// knownCommands are the commands frames are labelled with.
+var knownCommands = map[stomp.Command]bool{
+	stomp.CmdConnect:     true,
+	stomp.CmdStomp:       true,
+	stomp.CmdConnected:   true,
+	stomp.CmdSend:        true,
+	stomp.CmdSubscribe:   true,
+	stomp.CmdUnsubscribe: true,
+	stomp.CmdAck:         true,
+	stomp.CmdNack:        true,
+	stomp.CmdBegin:       true,
+	stomp.CmdCommit:      true,
+	stomp.CmdAbort:       true,
+	stomp.CmdDisconnect:  true,
+	stomp.CmdMessage:     true,
+	stomp.CmdReceipt:     true,
+	stomp.CmdError:       true,
+}
+
+// commandLabel returns the label of the frames carrying command.
+func commandLabel(command stomp.Command) stomp.Command {
+	if knownCommands[command] {
+		return command
+	}
+	return unknownCommand
+}
+
+// A Registry is a Collector that keeps its measurements in memory and
+// serves them over HTTP in the Prometheus text exposition format.
+type Registry struct {
+	namespace string
+	buckets   []float64
+
+	mu              sync.Mutex
+	framesSent      map[stomp.Command]uint64
+	framesReceived  map[stomp.Command]uint64
+	bytesSent       map[stomp.Command]uint64
+	bytesReceived   map[stomp.Command]uint64
+	latencyCounts   []uint64
+	latencySum      float64
+	latencyCount    uint64
+	heartBeatMisses uint64
+	parseErrors     uint64
+	reconnects      uint64
+	subscriptions   int64
+}
+
+// NewRegistry returns an empty Registry. Metric names are prefixed
+// with namespace, which defaults to "stomp" when empty.
+func NewRegistry(namespace string) *Registry {
+	if "" == namespace {
+		namespace = "stomp"
+	}
+
+	return &Registry{
+		namespace:      namespace,
+		buckets:        DefaultLatencyBuckets,
+		framesSent:     make(map[stomp.Command]uint64),
+		framesReceived: make(map[stomp.Command]uint64),
+		bytesSent:      make(map[stomp.Command]uint64),
+		bytesReceived:  make(map[stomp.Command]uint64),
+		latencyCounts:  make([]uint64, len(DefaultLatencyBuckets)),
+	}
+}
+
+// FrameSent implements Collector.
+func (r *Registry) FrameSent(command stomp.Command, bytes int64) {
+	command = commandLabel(command)
+	r.mu.Lock()
+	r.framesSent[command]++
+	r.bytesSent[command] += uint64(bytes)
+	r.mu.Unlock()
+}
+
+// FrameReceived implements Collector.
+func (r *Registry) FrameReceived(command stomp.Command, bytes int64) {
+	command = commandLabel(command)
+	r.mu.Lock()
+	r.framesReceived[command]++
+	r.bytesReceived[command] += uint64(bytes)
+	r.mu.Unlock()
+}
+
+// AckLatency implements Collector.
+func (r *Registry) AckLatency(d time.Duration) {
+	seconds := d.Seconds()
+	r.mu.Lock()
+
+	for i, bound := range r.buckets {
+		if seconds <= bound {
+			r.latencyCounts[i]++
+		}
+	}
+	r.latencySum += seconds
+	r.latencyCount++
+	r.mu.Unlock()
+}
+
+// HeartBeatMissed implements Collector.
+func (r *Registry) HeartBeatMissed() {
+	r.mu.Lock()
+	r.heartBeatMisses++
+	r.mu.Unlock()
+}
+
+// ParseError implements Collector.
+func (r *Registry) ParseError() {
+	r.mu.Lock()
+	r.parseErrors++
+	r.mu.Unlock()
+}
+
+// Reconnected implements Collector.
+func (r *Registry) Reconnected() {
+	r.mu.Lock()
+	r.reconnects++
+	r.mu.Unlock()
+}
+
+// SubscriptionsChanged implements Collector.
+func (r *Registry) SubscriptionsChanged(delta int) {
+	r.mu.Lock()
+	r.subscriptions += int64(delta)
+	r.mu.Unlock()
+}
+
+// ServeHTTP writes the registry's measurements in the Prometheus
+// text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// WriteTo writes the registry's measurements to w in the Prometheus
+// text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	r.writeByCommand(cw, "frames_sent_total", "Frames sent, by command.", r.framesSent)
+	r.writeByCommand(cw, "frames_received_total", "Frames received, by command.", r.framesReceived)
+	r.writeByCommand(cw, "bytes_sent_total", "Bytes sent, by command.", r.bytesSent)
+	r.writeByCommand(cw, "bytes_received_total", "Bytes received, by command.", r.bytesReceived)
+
+	name := r.namespace + "_ack_latency_seconds"
+	r.writeHead(cw, name, "Time from message receipt to acknowledgement.", "histogram")
+
+	for i, bound := range r.buckets {
+		fmt.Fprintf(cw, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), r.latencyCounts[i])
+	}
+	fmt.Fprintf(cw, "%s_bucket{le=\"+Inf\"} %d\n", name, r.latencyCount)
+	fmt.Fprintf(cw, "%s_sum %s\n", name, formatFloat(r.latencySum))
+	fmt.Fprintf(cw, "%s_count %d\n", name, r.latencyCount)
+
+	r.writeSingle(cw, "heartbeat_misses_total", "Expected heart-beats that did not arrive.", "counter", strconv.FormatUint(r.heartBeatMisses, 10))
+	r.writeSingle(cw, "parse_errors_total", "Frames that could not be read.", "counter", strconv.FormatUint(r.parseErrors, 10))
+	r.writeSingle(cw, "reconnects_total", "Re-established connections.", "counter", strconv.FormatUint(r.reconnects, 10))
+	r.writeSingle(cw, "active_subscriptions", "Subscriptions currently active.", "gauge", strconv.FormatInt(r.subscriptions, 10))
+
+	if nil != cw.err {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+func (r *Registry) writeHead(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (r *Registry) writeSingle(w io.Writer, suffix, help, typ, value string) {
+	name := r.namespace + "_" + suffix
+	r.writeHead(w, name, help, typ)
+	fmt.Fprintf(w, "%s %s\n", name, value)
+}
+
+func (r *Registry) writeByCommand(w io.Writer, suffix, help string, values map[stomp.Command]uint64) {
+	name := r.namespace + "_" + suffix
+	r.writeHead(w, name, help, "counter")
+	commands := make([]string, 0, len(values))
+
+	for c := range values {
+		commands = append(commands, string(c))
+	}
+	sort.Strings(commands)
+
+	for _, c := range commands {
+		fmt.Fprintf(w, "%s{command=\"%s\"} %d\n", name, escapeLabel(c), values[stomp.Command(c)])
+	}
+}
+
+var labelReplacer = strings.NewReplacer(
+	"\\", "\\\\",
+	"\"", "\\\"",
+	"\n", "\\n",
+)
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written through it, and
+// remembers the first error encountered.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if nil != c.err {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,351 @@
+package stomp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Params holds the values of the parameters of a destination pattern,
+// by name.
+type Params map[string]string
+
+// A RouteFunc handles a frame routed by a DestinationMux, given the
+// values of the parameters in the pattern that matched it.
+type RouteFunc func(s *Session, f *Frame, params Params) error
+
+// A Middleware wraps a Handler, to act before or after it, or instead
+// of it.
+type Middleware func(next Handler) Handler
+
+// A DestinationMux is a Handler that routes SEND and SUBSCRIBE frames
+// to handlers by their destination, as http.ServeMux routes requests
+// by path.
+//
+// A pattern without wildcards matches the destination equal to it,
+// unless it ends in "/", when it matches any destination beginning
+// with it, as in http.ServeMux. A "*" in a pattern matches any run of
+// characters other than "/" and ".", as in MatchDestination, and a
+// parameter such as "{region}" matches the same, capturing it under
+// its name. A pattern ending in ">" matches any destination beginning
+// with the rest of it. So "/queue/orders.{region}" matches
+// "/queue/orders.emea", with the parameter region set to "emea".
+//
+// When several patterns match a destination, an exact pattern is
+// preferred, then the pattern with the most literal characters, then
+// the pattern registered first.
+//
+// An UNSUBSCRIBE frame is routed to the handler its subscription was
+// routed to. Other frames, which carry no destination, are passed to
+// Fallback. Handlers that implement SessionHandler are told of every
+// session.
+type DestinationMux struct {
+	// NotFound handles frames whose destination matches no pattern.
+	// Nil rejects them with an error.
+	NotFound Handler
+
+	// Fallback handles frames that carry no destination, such as
+	// ACK and COMMIT. Nil accepts them without action.
+	Fallback Handler
+
+	mu         sync.RWMutex
+	routes     []*route
+	middleware []Middleware
+	subs       map[*Session]map[string]Handler
+}
+
+type route struct {
+	pattern  string
+	exact    bool
+	literals int
+	handler  func(params Params) Handler
+}
+
+// NewDestinationMux returns an empty mux. The zero DestinationMux is
+// also ready to use.
+func NewDestinationMux() *DestinationMux {
+	return &DestinationMux{}
+}
+
+// Handle registers h for destinations matching pattern. Handle panics
+// if pattern is empty, malformed or already registered.
+func (m *DestinationMux) Handle(pattern string, h Handler) {
+	m.register(pattern, func(Params) Handler {
+		return h
+	})
+}
+
+// HandleFunc registers fn for destinations matching pattern.
+func (m *DestinationMux) HandleFunc(pattern string, fn func(s *Session, f *Frame) error) {
+	m.Handle(pattern, HandlerFunc(fn))
+}
+
+// HandleRoute registers fn for destinations matching pattern; fn is
+// given the values of the pattern's parameters.
+func (m *DestinationMux) HandleRoute(pattern string, fn RouteFunc) {
+	m.register(pattern, func(params Params) Handler {
+		return HandlerFunc(func(s *Session, f *Frame) error {
+			return fn(s, f, params)
+		})
+	})
+}
+
+// Use adds middleware wrapping every handler of the mux, including
+// NotFound and Fallback. The first middleware added is the outermost.
+func (m *DestinationMux) Use(middleware ...Middleware) {
+	m.mu.Lock()
+	m.middleware = append(m.middleware, middleware...)
+	m.mu.Unlock()
+}
+
+func (m *DestinationMux) register(pattern string, handler func(Params) Handler) {
+	r := &route{pattern: pattern, exact: true, handler: handler}
+
+	if "" == pattern {
+		panic("stomp: empty destination pattern")
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			r.exact = false
+		case '>':
+			if len(pattern)-1 != i {
+				panic("stomp: > not at the end of destination pattern " + pattern)
+			}
+			r.exact = false
+		case '{':
+			end := strings.IndexByte(pattern[i:], '}')
+
+			if end < 2 {
+				panic("stomp: malformed parameter in destination pattern " + pattern)
+			}
+			i += end
+			r.exact = false
+		default:
+			r.literals++
+		}
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		r.exact = false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.routes {
+		if existing.pattern == pattern {
+			panic("stomp: destination pattern " + pattern + " registered twice")
+		}
+	}
+	m.routes = append(m.routes, r)
+}
+
+// match reports whether destination matches pattern, collecting the
+// values of its parameters into params.
+func match(pattern, destination string, params Params) bool {
+	for "" != pattern {
+		switch pattern[0] {
+		case '>':
+			return "" != destination
+		case '/':
+			if 1 == len(pattern) {
+				return strings.HasPrefix(destination, "/")
+			}
+		case '*', '{':
+			var name string
+			rest := pattern[1:]
+
+			if '{' == pattern[0] {
+				end := strings.IndexByte(pattern, '}')
+				name, rest = pattern[1:end], pattern[end+1:]
+			}
+
+			for i := 0; ; i++ {
+				if match(rest, destination[i:], params) {
+					if "" != name {
+						params[name] = destination[:i]
+					}
+					return true
+				}
+
+				if i == len(destination) || '/' == destination[i] || '.' == destination[i] {
+					return false
+				}
+			}
+		}
+
+		if "" == destination || pattern[0] != destination[0] {
+			return false
+		}
+		pattern, destination = pattern[1:], destination[1:]
+	}
+	return "" == destination
+}
+
+// Match returns the handler for destination, and the values of the
+// parameters of the pattern that matched it. The handler is nil if no
+// pattern matches.
+func (m *DestinationMux) Match(destination string) (Handler, Params) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var best *route
+	var bestParams Params
+
+	for _, r := range m.routes {
+		params := make(Params)
+
+		if !match(r.pattern, destination, params) {
+			continue
+		}
+
+		if nil == best || (r.exact && !best.exact) || (r.exact == best.exact && r.literals > best.literals) {
+			best, bestParams = r, params
+		}
+	}
+
+	if nil == best {
+		return nil, nil
+	}
+	return best.handler(bestParams), bestParams
+}
+
+// wrap applies the mux's middleware to h.
+func (m *DestinationMux) wrap(h Handler) Handler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		h = m.middleware[i](h)
+	}
+	return h
+}
+
+// route returns the handler for f.
+func (m *DestinationMux) route(s *Session, f *Frame) Handler {
+	destination, ok := f.Header.Get(HdrDestination)
+
+	switch {
+	case CmdUnsubscribe == f.Command:
+		id, _ := f.Header.Get(HdrId)
+		m.mu.Lock()
+		h := m.subs[s][id]
+		delete(m.subs[s], id)
+		m.mu.Unlock()
+
+		if nil != h {
+			return h
+		}
+	case ok && (CmdSend == f.Command || CmdSubscribe == f.Command):
+		h, _ := m.Match(destination)
+
+		if nil == h {
+			h = m.NotFound
+		}
+
+		if nil == h {
+			return HandlerFunc(func(s *Session, f *Frame) error {
+				return fmt.Errorf("no handler for destination %s", destination)
+			})
+		}
+		return h
+	}
+
+	if nil == m.Fallback {
+		return HandlerFunc(func(s *Session, f *Frame) error {
+			return nil
+		})
+	}
+	return m.Fallback
+}
+
+// ServeFrame routes f to its handler, wrapped in the mux's
+// middleware.
+func (m *DestinationMux) ServeFrame(s *Session, f *Frame) error {
+	h := m.route(s, f)
+	serveErr := m.wrap(h).ServeFrame(s, f)
+
+	if nil == serveErr && CmdSubscribe == f.Command {
+		id, ok := f.Header.Get(HdrId)
+
+		if !ok {
+			id, _ = f.Header.Get(HdrDestination)
+		}
+		m.mu.Lock()
+
+		if subs, ok := m.subs[s]; ok {
+			subs[id] = h
+		}
+		m.mu.Unlock()
+	}
+	return serveErr
+}
+
+// sessionHandlers returns the distinct handlers of the mux that
+// implement SessionHandler.
+func (m *DestinationMux) sessionHandlers() []SessionHandler {
+	m.mu.RLock()
+	candidates := []Handler{m.NotFound, m.Fallback}
+
+	for _, r := range m.routes {
+		candidates = append(candidates, r.handler(nil))
+	}
+	m.mu.RUnlock()
+
+	seen := make(map[SessionHandler]bool)
+	var handlers []SessionHandler
+
+	for _, h := range candidates {
+		sh, ok := h.(SessionHandler)
+
+		if !ok {
+			continue
+		}
+
+		if !reflect.TypeOf(sh).Comparable() {
+			handlers = append(handlers, sh)
+			continue
+		}
+
+		if !seen[sh] {
+			seen[sh] = true
+			handlers = append(handlers, sh)
+		}
+	}
+	return handlers
+}
+
+// OpenSession tells the mux's session handlers of a new session. If
+// one of them fails, those already told are told the session ended.
+func (m *DestinationMux) OpenSession(s *Session) error {
+	handlers := m.sessionHandlers()
+
+	for i, sh := range handlers {
+		if openErr := sh.OpenSession(s); nil != openErr {
+			for j := i - 1; j >= 0; j-- {
+				handlers[j].CloseSession(s)
+			}
+			return openErr
+		}
+	}
+	m.mu.Lock()
+
+	if nil == m.subs {
+		m.subs = make(map[*Session]map[string]Handler)
+	}
+	m.subs[s] = make(map[string]Handler)
+	m.mu.Unlock()
+	return nil
+}
+
+// CloseSession tells the mux's session handlers that a session ended.
+func (m *DestinationMux) CloseSession(s *Session) {
+	m.mu.Lock()
+	delete(m.subs, s)
+	m.mu.Unlock()
+
+	for _, sh := range m.sessionHandlers() {
+		sh.CloseSession(s)
+	}
+}
@@ -0,0 +1,163 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDestinationMuxMatch(t *testing.T) {
+	mux := NewDestinationMux()
+	named := func(name string) Handler {
+		return HandlerFunc(func(s *Session, f *Frame) error {
+			return errors.New(name)
+		})
+	}
+	mux.Handle("/queue/orders", named("exact"))
+	mux.Handle("/queue/orders.{region}", named("region"))
+	mux.Handle("/queue/orders.{region}.{id}", named("order"))
+	mux.Handle("/queue/orders.eu.*", named("eu"))
+	mux.Handle("/queue/>", named("queues"))
+	mux.Handle("/topic/", named("topics"))
+
+	tests := []struct {
+		destination string
+		want        string
+		params      Params
+	}{
+		{"/queue/orders", "exact", Params{}},
+		{"/queue/orders.us", "region", Params{"region": "us"}},
+		{"/queue/orders.us.42", "order", Params{"region": "us", "id": "42"}},
+		{"/queue/orders.eu.42", "eu", Params{}},
+		{"/queue/orders.us/x", "queues", Params{}},
+		{"/queue/other", "queues", Params{}},
+		{"/topic/", "topics", Params{}},
+		{"/topic/prices.eur", "topics", Params{}},
+		{"/queue/", "", nil},
+		{"/topic", "", nil},
+	}
+
+	for _, test := range tests {
+		h, params := mux.Match(test.destination)
+
+		if nil == h {
+			if "" != test.want {
+				t.Errorf("%s: no match, expected %s", test.destination, test.want)
+			}
+			continue
+		}
+
+		if got := h.ServeFrame(nil, nil).Error(); got != test.want {
+			t.Errorf("%s: matched %s, expected %s", test.destination, got, test.want)
+		}
+
+		if len(params) != len(test.params) {
+			t.Errorf("%s: params = %v, expected %v", test.destination, params, test.params)
+			continue
+		}
+
+		for name, value := range test.params {
+			if params[name] != value {
+				t.Errorf("%s: params = %v, expected %v", test.destination, params, test.params)
+			}
+		}
+	}
+
+	for _, bad := range []string{"", "/queue/>.x", "/queue/{}", "/queue/{x", "/queue/orders"} {
+		func() {
+			defer func() {
+				if nil == recover() {
+					t.Errorf("registered %q", bad)
+				}
+			}()
+			mux.Handle(bad, named("bad"))
+		}()
+	}
+}
+
+func TestDestinationMuxServer(t *testing.T) {
+	var mu sync.Mutex
+	var log []string
+	record := func(entry string) {
+		mu.Lock()
+		log = append(log, entry)
+		mu.Unlock()
+	}
+	mux := NewDestinationMux()
+	mux.Use(func(next Handler) Handler {
+		return HandlerFunc(func(s *Session, f *Frame) error {
+			record("mw " + string(f.Command))
+			return next.ServeFrame(s, f)
+		})
+	})
+	mux.HandleRoute("/queue/orders.{region}", func(s *Session, f *Frame, params Params) error {
+		record(string(f.Command) + " " + params["region"])
+		return nil
+	})
+	mux.Fallback = HandlerFunc(func(s *Session, f *Frame) error {
+		record("fallback " + string(f.Command))
+		return nil
+	})
+
+	srv, connect := newTestServer(mux)
+	defer srv.Close()
+	client, connectErr := connect(t, nil)
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, subErr := client.Subscribe(ctx, "/queue/orders.eu", Header{HdrReceipt: {"sub"}})
+
+	if nil != subErr {
+		t.Fatal(subErr)
+	}
+
+	if unsubErr := sub.Unsubscribe(ctx); nil != unsubErr {
+		t.Fatal(unsubErr)
+	}
+	f := NewFrame(CmdSend, nil)
+	f.Header.Set(HdrReceipt, "send")
+
+	if sendErr := client.Send(ctx, "/queue/orders.us", f); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+	f = NewFrame(CmdBegin, nil)
+	f.Header.Set(HdrTransaction, "tx")
+	f.Header.Set(HdrReceipt, "begin")
+
+	if sendErr := client.send(ctx, f); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+	want := []string{
+		"mw SUBSCRIBE", "SUBSCRIBE eu",
+		"mw UNSUBSCRIBE", "UNSUBSCRIBE eu",
+		"mw SEND", "SEND us",
+		"mw BEGIN", "fallback BEGIN",
+	}
+	mu.Lock()
+	got := strings.Join(log, ", ")
+	mu.Unlock()
+
+	if got != strings.Join(want, ", ") {
+		t.Errorf("log = %s", got)
+	}
+	f = NewFrame(CmdSend, nil)
+	f.Header.Set(HdrReceipt, "lost")
+	sendErr := client.Send(ctx, "/queue/unknown", f)
+	var serverErr *ServerError
+
+	if !errors.As(sendErr, &serverErr) {
+		t.Fatalf("expected an ERROR frame, got %v", sendErr)
+	}
+
+	if !strings.Contains(serverErr.Message, "/queue/unknown") {
+		t.Errorf("message = %q", serverErr.Message)
+	}
+}
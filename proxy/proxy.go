@@ -0,0 +1,316 @@
+// Package proxy implements a transparent STOMP proxy that forwards
+// the frames of each client connection to an upstream broker,
+// passing them through a list of rules that may log, filter or
+// rewrite them.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+// DefaultDialTimeout limits how long a Proxy takes to connect to the
+// upstream broker when it has no Dial function.
+const DefaultDialTimeout = 10 * time.Second
+
+// ErrProxyClosed is returned by Serve after the proxy is closed.
+var ErrProxyClosed = errors.New("proxy closed")
+
+// A Proxy accepts STOMP client connections and forwards each to its
+// own connection to the upstream broker. Heart-beats are forwarded
+// as they arrive, so the client and broker negotiate them as if they
+// were directly connected.
+type Proxy struct {
+	// Upstream is the TCP network address of the broker.
+	Upstream string
+
+	// Dial, when set, opens the connection to the broker, for
+	// instance over TLS.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Rules are applied, in order, to every frame passing through.
+	Rules []Rule
+
+	// Logger receives the proxy's events. A nil Logger discards
+	// them.
+	Logger stomp.Logger
+
+	// Interceptors, when set, is called for every new client
+	// connection and the interceptors returned are installed on
+	// the handle of the client side. Installing stomp.LogFrames
+	// logs every frame passing through.
+	Interceptors func() []stomp.Interceptor
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	nextID    uint64
+}
+
+// ListenAndServe listens on the TCP network address addr and then
+// calls Serve.
+func (p *Proxy) ListenAndServe(addr string) error {
+	l, listenErr := net.Listen("tcp", addr)
+
+	if nil != listenErr {
+		return listenErr
+	}
+	return p.Serve(l)
+}
+
+// Serve accepts connections on l, proxying each in a new goroutine.
+// Serve always returns a non-nil error; after Close it returns
+// ErrProxyClosed.
+func (p *Proxy) Serve(l net.Listener) error {
+	p.mu.Lock()
+
+	if p.closed {
+		p.mu.Unlock()
+		return ErrProxyClosed
+	}
+
+	if nil == p.listeners {
+		p.listeners = make(map[net.Listener]struct{})
+	}
+	p.listeners[l] = struct{}{}
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.listeners, l)
+		p.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, acceptErr := l.Accept()
+
+		if nil != acceptErr {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+
+			if closed {
+				return ErrProxyClosed
+			}
+			return acceptErr
+		}
+		go p.ServeConn(conn)
+	}
+}
+
+// Close closes the proxy's listeners and every connection it is
+// proxying.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	listeners := p.listeners
+	conns := p.conns
+	p.listeners = nil
+	p.conns = nil
+	p.mu.Unlock()
+
+	for l := range listeners {
+		l.Close()
+	}
+
+	for c := range conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (p *Proxy) logger() stomp.Logger {
+	if nil == p.Logger {
+		return stomp.NopLogger{}
+	}
+	return p.Logger
+}
+
+// track adds conns to, or removes them from, the connections closed
+// by Close. track reports false when adding to a closed proxy.
+func (p *Proxy) track(add bool, conns ...net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !add {
+		for _, c := range conns {
+			delete(p.conns, c)
+		}
+		return true
+	}
+
+	if p.closed {
+		return false
+	}
+
+	if nil == p.conns {
+		p.conns = make(map[net.Conn]struct{})
+	}
+
+	for _, c := range conns {
+		p.conns[c] = struct{}{}
+	}
+	return true
+}
+
+// ServeConn proxies a single client connection, returning once
+// either side has closed its connection. ServeConn closes conn.
+func (p *Proxy) ServeConn(conn net.Conn) {
+	id := strconv.FormatUint(atomic.AddUint64(&p.nextID, 1), 10)
+	log := p.logger()
+	dial := p.Dial
+
+	if nil == dial {
+		dialer := net.Dialer{Timeout: DefaultDialTimeout}
+		dial = dialer.DialContext
+	}
+	upstream, dialErr := dial(context.Background(), "tcp", p.Upstream)
+
+	if nil != dialErr {
+		log.Error("upstream dial failed", "conn", id, "upstream", p.Upstream, "error", dialErr)
+		conn.Close()
+		return
+	}
+
+	if !p.track(true, conn, upstream) {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	defer p.track(false, conn, upstream)
+
+	client := stomp.Bind(conn)
+	client.SetLogger(p.Logger)
+
+	if nil != p.Interceptors {
+		client.Use(p.Interceptors()...)
+	}
+	broker := stomp.Bind(upstream)
+	log.Info("proxying connection", "conn", id, "client", conn.RemoteAddr().String(), "upstream", p.Upstream)
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			conn.Close()
+			upstream.Close()
+		})
+	}
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		defer closeBoth()
+		p.pump(id, Upstream, client, broker, client)
+	}()
+
+	go func() {
+		defer wg.Done()
+		defer closeBoth()
+		p.pump(id, Downstream, broker, client, client)
+	}()
+	wg.Wait()
+	client.Release()
+	broker.Release()
+	log.Info("connection closed", "conn", id)
+}
+
+// pump forwards frames from src to dst in direction dir until
+// either fails. Replies the proxy makes itself go to client: a
+// dropped frame is answered with a receipt, if it requested one, so
+// that the client does not wait for it forever.
+func (p *Proxy) pump(id string, dir Direction, src, dst, client *stomp.Handle) {
+	ctx := context.Background()
+	log := p.logger()
+
+	for {
+		f, readErr := src.Receive(ctx)
+
+		if nil != readErr {
+			log.Debug("read failed", "conn", id, "direction", dir.String(), "error", readErr)
+			return
+		}
+
+		if nil == f {
+			if sendErr := dst.Send(ctx, nil); nil != sendErr {
+				return
+			}
+			continue
+		}
+		out, ruleErr := p.rewrite(dir, f)
+
+		if nil != ruleErr {
+			f.Body.Close()
+			log.Warn("frame rejected", "conn", id, "direction", dir.String(), "command", f.Command.String(), "error", ruleErr)
+
+			if Upstream == dir {
+				client.Send(ctx, errorFrame(f, ruleErr))
+			}
+			return
+		}
+
+		if nil == out {
+			f.Body.Close()
+			log.Debug("frame dropped", "conn", id, "direction", dir.String(), "command", f.Command.String())
+
+			if receipt, ok := f.Header.Get(stomp.HdrReceipt); ok && Upstream == dir {
+				r := stomp.NewFrame(stomp.CmdReceipt, nil)
+				r.Header.Set(stomp.HdrReceiptId, receipt)
+
+				if sendErr := client.Send(ctx, r); nil != sendErr {
+					return
+				}
+			}
+			continue
+		}
+
+		if sendErr := dst.Send(ctx, out); nil != sendErr {
+			log.Debug("write failed", "conn", id, "direction", dir.String(), "error", sendErr)
+			return
+		}
+	}
+}
+
+// rewrite applies the proxy's rules to f in order, stopping at the
+// first rule that drops or rejects it.
+func (p *Proxy) rewrite(dir Direction, f *stomp.Frame) (*stomp.Frame, error) {
+	out := f
+
+	for _, r := range p.Rules {
+		var ruleErr error
+		out, ruleErr = r.Rewrite(dir, out)
+
+		if nil != ruleErr {
+			return nil, ruleErr
+		}
+
+		if nil == out {
+			return nil, nil
+		}
+	}
+	return out, nil
+}
+
+// errorFrame builds the ERROR frame sent to a client whose frame f
+// was rejected with err.
+func errorFrame(f *stomp.Frame, err error) *stomp.Frame {
+	msg := err.Error()
+	e := stomp.NewFrame(stomp.CmdError, strings.NewReader(msg))
+	e.Header.Set(stomp.HdrMessage, msg)
+	e.Header.Set(stomp.HdrContentType, "text/plain")
+
+	if receipt, ok := f.Header.Get(stomp.HdrReceipt); ok {
+		e.Header.Set(stomp.HdrReceiptId, receipt)
+	}
+	return e
+}
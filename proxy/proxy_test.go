@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+type broker struct {
+	mu     sync.Mutex
+	frames []*stomp.Frame
+}
+
+func (b *broker) ServeFrame(s *stomp.Session, f *stomp.Frame) error {
+	b.mu.Lock()
+	b.frames = append(b.frames, f)
+	b.mu.Unlock()
+
+	if stomp.CmdSend != f.Command {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(f.Body)
+	dest, _ := f.Header.Get(stomp.HdrDestination)
+	msg := stomp.NewFrame(stomp.CmdMessage, strings.NewReader(string(body)))
+	msg.Header.Set(stomp.HdrDestination, dest)
+	msg.Header.Set(stomp.HdrSubscription, "sub-1")
+	msg.Header.Set(stomp.HdrMessageId, "m-1")
+	return s.Send(context.Background(), msg)
+}
+
+func (b *broker) find(cmd stomp.Command) []*stomp.Frame {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var found []*stomp.Frame
+
+	for _, f := range b.frames {
+		if cmd == f.Command {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+func listen(t *testing.T, serve func(net.Listener) error) string {
+	l, listenErr := net.Listen("tcp", "127.0.0.1:0")
+
+	if nil != listenErr {
+		t.Fatal(listenErr)
+	}
+	go serve(l)
+	return l.Addr().String()
+}
+
+func TestProxy(t *testing.T) {
+	b := &broker{}
+	srv := &stomp.Server{Handler: b}
+	defer srv.Close()
+
+	p := &Proxy{
+		Upstream: listen(t, srv.Serve),
+		Rules: []Rule{
+			RenameDestination("/queue/old.", "/queue/new."),
+			SetHeader(Upstream, "x-proxy", "1", stomp.CmdSend),
+			Drop(Upstream, stomp.CmdUnsubscribe),
+			RuleFunc(func(dir Direction, f *stomp.Frame) (*stomp.Frame, error) {
+				if dest, _ := f.Header.Get(stomp.HdrDestination); "/queue/new.void" == dest {
+					return nil, nil
+				}
+				return f, nil
+			}),
+			Block(stomp.CmdBegin),
+		},
+	}
+	defer p.Close()
+
+	addr := listen(t, p.Serve)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, dialErr := net.Dial("tcp", addr)
+
+	if nil != dialErr {
+		t.Fatal(dialErr)
+	}
+	client, connectErr := stomp.Connect(ctx, conn, nil, nil)
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer client.Close()
+
+	sub, subErr := client.Subscribe(ctx, "/queue/old.a", stomp.Header{stomp.HdrId: {"sub-1"}})
+
+	if nil != subErr {
+		t.Fatal(subErr)
+	}
+
+	if sendErr := client.Send(ctx, "/queue/old.a", nil); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+	msg, readErr := sub.Receive(ctx)
+
+	if nil != readErr {
+		t.Fatal(readErr)
+	}
+
+	if dest, _ := msg.Header.Get(stomp.HdrDestination); "/queue/old.a" != dest {
+		t.Errorf("client saw destination %q", dest)
+	}
+	void := stomp.NewFrame(stomp.CmdSend, nil)
+	void.Header.Set(stomp.HdrReceipt, "r-1")
+
+	if sendErr := client.Send(ctx, "/queue/old.void", void); nil != sendErr {
+		t.Fatalf("dropped frame was not answered: %v", sendErr)
+	}
+
+	if unsubErr := sub.Unsubscribe(ctx); nil != unsubErr {
+		t.Fatal(unsubErr)
+	}
+	_, beginErr := client.Begin(ctx)
+
+	if nil == beginErr {
+		<-client.Done()
+		beginErr = client.Err()
+	}
+	var serverErr *stomp.ServerError
+
+	if !errors.As(beginErr, &serverErr) || !strings.Contains(serverErr.Message, ErrBlocked.Error()) {
+		t.Errorf("expected blocked BEGIN, got %v", beginErr)
+	}
+	sends := b.find(stomp.CmdSend)
+
+	if len(sends) != 1 {
+		t.Fatalf("broker received %d SEND frames", len(sends))
+	}
+
+	if dest, _ := sends[0].Header.Get(stomp.HdrDestination); "/queue/new.a" != dest {
+		t.Errorf("broker saw destination %q", dest)
+	}
+
+	if v, _ := sends[0].Header.Get("x-proxy"); "1" != v {
+		t.Errorf("x-proxy = %q", v)
+	}
+
+	if subs := b.find(stomp.CmdSubscribe); len(subs) != 1 {
+		t.Errorf("broker received %d SUBSCRIBE frames", len(subs))
+	}
+
+	for _, cmd := range []stomp.Command{stomp.CmdUnsubscribe, stomp.CmdBegin} {
+		if found := b.find(cmd); len(found) != 0 {
+			t.Errorf("broker received %s", cmd)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jjware/stomp"
+)
+
+// ErrBlocked is returned by rules that refuse to forward a frame.
+var ErrBlocked = errors.New("blocked by proxy")
+
+// Direction identifies the way a frame travels through the proxy.
+type Direction int
+
+const (
+	// Upstream frames travel from the client to the broker.
+	Upstream Direction = iota + 1
+
+	// Downstream frames travel from the broker to the client.
+	Downstream
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Upstream:
+		return "upstream"
+	case Downstream:
+		return "downstream"
+	}
+	return fmt.Sprintf("Direction(%d)", int(d))
+}
+
+// A Rule inspects, and may rewrite, every frame passing through the
+// proxy. Rewrite returns the frame to forward, which may be f itself
+// after modification; a rule returning a different frame must close
+// the body of f, or reuse it. A nil frame drops f. An error ends the
+// connection; for upstream frames, the client is first sent an ERROR
+// frame describing it.
+type Rule interface {
+	Rewrite(dir Direction, f *stomp.Frame) (*stomp.Frame, error)
+}
+
+// The RuleFunc type is an adapter to allow the use of an ordinary
+// function as a Rule.
+type RuleFunc func(dir Direction, f *stomp.Frame) (*stomp.Frame, error)
+
+// Rewrite calls fn(dir, f).
+func (fn RuleFunc) Rewrite(dir Direction, f *stomp.Frame) (*stomp.Frame, error) {
+	return fn(dir, f)
+}
+
+func hasCommand(commands []stomp.Command, cmd stomp.Command) bool {
+	if 0 == len(commands) {
+		return true
+	}
+
+	for _, c := range commands {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// RenameDestination rewrites destination headers beginning with from
+// so that they begin with to instead, in the frames clients send. The
+// destinations of the MESSAGE frames sent back are renamed the other
+// way, so that clients only ever see the names they use.
+func RenameDestination(from, to string) Rule {
+	rename := func(f *stomp.Frame, from, to string) {
+		v, ok := f.Header.Get(stomp.HdrDestination)
+
+		if ok && strings.HasPrefix(v, from) {
+			f.Header.Set(stomp.HdrDestination, to+v[len(from):])
+		}
+	}
+
+	return RuleFunc(func(dir Direction, f *stomp.Frame) (*stomp.Frame, error) {
+		switch {
+		case Upstream == dir:
+			rename(f, from, to)
+		case stomp.CmdMessage == f.Command:
+			rename(f, to, from)
+		}
+		return f, nil
+	})
+}
+
+// SetHeader sets the header name to value on the frames travelling
+// in direction dir. If commands are given, only frames with one of
+// those commands are changed.
+func SetHeader(dir Direction, name, value string, commands ...stomp.Command) Rule {
+	return RuleFunc(func(d Direction, f *stomp.Frame) (*stomp.Frame, error) {
+		if d == dir && hasCommand(commands, f.Command) {
+			f.Header.Set(name, value)
+		}
+		return f, nil
+	})
+}
+
+// DelHeader removes the header name from the frames travelling in
+// direction dir. If commands are given, only frames with one of those
+// commands are changed.
+func DelHeader(dir Direction, name string, commands ...stomp.Command) Rule {
+	return RuleFunc(func(d Direction, f *stomp.Frame) (*stomp.Frame, error) {
+		if d == dir && hasCommand(commands, f.Command) {
+			f.Header.Del(name)
+		}
+		return f, nil
+	})
+}
+
+// Block refuses to forward the given commands from clients, ending
+// the connection with an ERROR frame when a client sends one.
+func Block(commands ...stomp.Command) Rule {
+	return RuleFunc(func(dir Direction, f *stomp.Frame) (*stomp.Frame, error) {
+		if Upstream == dir && 0 != len(commands) && hasCommand(commands, f.Command) {
+			return nil, fmt.Errorf("%w. %s", ErrBlocked, f.Command)
+		}
+		return f, nil
+	})
+}
+
+// Drop silently discards the frames travelling in direction dir
+// with the given commands.
+func Drop(dir Direction, commands ...stomp.Command) Rule {
+	return RuleFunc(func(d Direction, f *stomp.Frame) (*stomp.Frame, error) {
+		if d == dir && hasCommand(commands, f.Command) {
+			return nil, nil
+		}
+		return f, nil
+	})
+}
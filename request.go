@@ -0,0 +1,211 @@
+package stomp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	// HdrReplyTo names the destination a reply to a request is sent
+	// to.
+	HdrReplyTo = "reply-to"
+
+	// HdrCorrelationId is set on a request, and copied to its reply,
+	// to match the two.
+	HdrCorrelationId = "correlation-id"
+
+	// HdrReplyError is set on a reply to the error that the
+	// responder failed with.
+	HdrReplyError = "reply-error"
+)
+
+// DefaultReplyPrefix begins the reply destinations clients choose
+// when ClientOptions does not set one.
+const DefaultReplyPrefix = "/queue/reply."
+
+// A ReplyError is returned by Request when the responder failed to
+// handle the request.
+type ReplyError struct {
+	// Message is the value of the reply's reply-error header.
+	Message string
+
+	// Reply is the reply frame.
+	Reply *Frame
+}
+
+func (e *ReplyError) Error() string {
+	return "request failed: " + e.Message
+}
+
+// replyDestination returns a reply destination private to a client.
+func replyDestination() string {
+	b := make([]byte, 12)
+
+	if _, randErr := rand.Read(b); nil != randErr {
+		panic(randErr)
+	}
+	return DefaultReplyPrefix + hex.EncodeToString(b)
+}
+
+// replies subscribes to the client's reply destination, unless it
+// already has.
+func (c *Client) replies(ctx context.Context) error {
+	c.replyMu.Lock()
+	defer c.replyMu.Unlock()
+
+	if nil != c.replySub {
+		return nil
+	}
+
+	// The receipt ensures that the server knows of the subscription
+	// before any request is sent.
+	sub, subErr := c.Subscribe(ctx, c.replyTo, Header{HdrReceipt: {c.newID("replies-")}})
+
+	if nil != subErr {
+		return subErr
+	}
+	c.replySub = sub
+	go c.routeReplies(sub)
+	return nil
+}
+
+// routeReplies passes the replies received on sub to the requests
+// waiting for them.
+func (c *Client) routeReplies(sub *Subscription) {
+	for {
+		reply, receiveErr := sub.Receive(context.Background())
+
+		if nil != receiveErr {
+			c.replyMu.Lock()
+
+			if c.replySub == sub {
+				c.replySub = nil
+			}
+			c.replyMu.Unlock()
+			return
+		}
+		id, _ := reply.Header.Get(HdrCorrelationId)
+		c.replyMu.Lock()
+		ch, ok := c.waiting[id]
+		delete(c.waiting, id)
+		c.replyMu.Unlock()
+
+		if !ok {
+			c.log.Debug("dropped reply to unknown request", "correlation-id", id)
+			continue
+		}
+		ch <- reply
+	}
+}
+
+// Request sends frame to destination as a request, and returns the
+// reply. The request's reply-to header is set to the client's reply
+// destination, which the client subscribes to on its first request,
+// and its correlation-id header is generated unless frame sets one.
+// Request waits until the reply arrives, ctx is done or the session
+// ends. If the responder reports an error, Request returns the reply
+// with a *ReplyError.
+func (c *Client) Request(ctx context.Context, destination string, frame *Frame) (*Frame, error) {
+	frame = outgoing(frame)
+
+	if subErr := c.replies(ctx); nil != subErr {
+		return nil, subErr
+	}
+	id, ok := frame.Header.Get(HdrCorrelationId)
+
+	if !ok {
+		id = c.newID("request-")
+	}
+	ch := make(chan *Frame, 1)
+	c.replyMu.Lock()
+
+	if _, exists := c.waiting[id]; exists {
+		c.replyMu.Unlock()
+		return nil, fmt.Errorf("request %q already waiting for a reply", id)
+	}
+	c.waiting[id] = ch
+	c.replyMu.Unlock()
+
+	defer func() {
+		c.replyMu.Lock()
+		delete(c.waiting, id)
+		c.replyMu.Unlock()
+	}()
+	frame.Header.Set(HdrReplyTo, c.replyTo)
+	frame.Header.Set(HdrCorrelationId, id)
+
+	if sendErr := c.Send(ctx, destination, frame); nil != sendErr {
+		return nil, sendErr
+	}
+
+	select {
+	case reply := <-ch:
+		if message, failed := reply.Header.Get(HdrReplyError); failed {
+			return reply, &ReplyError{Message: message, Reply: reply}
+		}
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, c.Err()
+	}
+}
+
+// Reply sends reply to the destination named by the reply-to header
+// of req, with the correlation-id of req. A nil reply sends an empty
+// one.
+func (c *Client) Reply(ctx context.Context, req *Frame, reply *Frame) error {
+	replyTo, ok := req.Header.Get(HdrReplyTo)
+
+	if !ok {
+		return fmt.Errorf("request has no %s header", HdrReplyTo)
+	}
+
+	reply = outgoing(reply)
+
+	if id, ok := req.Header.Get(HdrCorrelationId); ok {
+		reply.Header.Set(HdrCorrelationId, id)
+	}
+	return c.Send(ctx, replyTo, reply)
+}
+
+// A RequestHandler handles a request received by Respond, returning
+// the reply to send. If it returns an error, the reply sent holds the
+// error in its reply-error header.
+type RequestHandler func(ctx context.Context, req *Frame) (*Frame, error)
+
+// Respond handles the requests received on sub with h, replying to
+// those with a reply-to header, until ctx is done, sub is closed or
+// a reply cannot be sent. Requests received on a subscription in
+// client or client-individual ack mode are acknowledged once replied
+// to. Respond handles one request at a time; run it on several
+// goroutines to handle requests concurrently.
+func (c *Client) Respond(ctx context.Context, sub *Subscription, h RequestHandler) error {
+	for {
+		req, receiveErr := sub.Receive(ctx)
+
+		if nil != receiveErr {
+			return receiveErr
+		}
+		reply, handleErr := h(ctx, req)
+
+		if nil != handleErr {
+			reply = NewFrame(CmdSend, nil)
+			reply.Header.Set(HdrReplyError, handleErr.Error())
+		}
+
+		if _, ok := req.Header.Get(HdrReplyTo); ok {
+			if replyErr := c.Reply(ctx, req, reply); nil != replyErr {
+				return replyErr
+			}
+		}
+
+		if AckAuto != sub.AckMode() {
+			if ackErr := c.Ack(ctx, req); nil != ackErr {
+				return ackErr
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package stomp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// relayHandler delivers every SEND frame, with its headers, to the
+// subscriptions to its destination of every session.
+type relayHandler struct {
+	mu   sync.Mutex
+	subs map[*Session]map[string]string
+	next int
+}
+
+func (h *relayHandler) OpenSession(s *Session) error {
+	h.mu.Lock()
+	h.subs[s] = make(map[string]string)
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *relayHandler) CloseSession(s *Session) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+func (h *relayHandler) ServeFrame(s *Session, f *Frame) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch f.Command {
+	case CmdSubscribe:
+		id, _ := f.Header.Get(HdrId)
+		dest, _ := f.Header.Get(HdrDestination)
+		h.subs[s][id] = dest
+	case CmdSend:
+		dest, _ := f.Header.Get(HdrDestination)
+		body, _ := ioutil.ReadAll(f.Body)
+
+		for session, subs := range h.subs {
+			for id, d := range subs {
+				if d != dest {
+					continue
+				}
+				h.next++
+				msg := NewFrame(CmdMessage, bytes.NewReader(body))
+
+				for k, v := range f.Header {
+					if HdrReceipt != k && HdrContentLength != k {
+						msg.Header[k] = v
+					}
+				}
+				msg.Header.Set(HdrSubscription, id)
+				msg.Header.Set(HdrMessageId, "m-"+strconv.Itoa(h.next))
+
+				if sendErr := session.Send(context.Background(), msg); nil != sendErr {
+					return sendErr
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func TestRequest(t *testing.T) {
+	srv, connect := newTestServer(&relayHandler{subs: make(map[*Session]map[string]string)})
+	defer srv.Close()
+	requester, connectErr := connect(t, nil)
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer requester.Close()
+	responder, connectErr := connect(t, nil)
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer responder.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, subErr := responder.Subscribe(ctx, "/queue/upper", Header{HdrAck: {AckClientIndividual}, HdrReceipt: {"sub"}})
+
+	if nil != subErr {
+		t.Fatal(subErr)
+	}
+	respondCtx, stop := context.WithCancel(ctx)
+	responded := make(chan error, 1)
+
+	go func() {
+		responded <- responder.Respond(respondCtx, sub, func(ctx context.Context, req *Frame) (*Frame, error) {
+			body, _ := ioutil.ReadAll(req.Body)
+
+			if 0 == len(body) {
+				return nil, errors.New("nothing to convert")
+			}
+			return NewFrame(CmdSend, strings.NewReader(strings.ToUpper(string(body)))), nil
+		})
+	}()
+
+	// Concurrent requests are each given their own reply.
+	var wg sync.WaitGroup
+
+	for _, word := range []string{"alpha", "beta", "gamma"} {
+		wg.Add(1)
+
+		go func(word string) {
+			defer wg.Done()
+			reply, requestErr := requester.Request(ctx, "/queue/upper", NewFrame(CmdSend, strings.NewReader(word)))
+
+			if nil != requestErr {
+				t.Error(requestErr)
+				return
+			}
+			body, _ := ioutil.ReadAll(reply.Body)
+
+			if strings.ToUpper(word) != string(body) {
+				t.Errorf("reply to %q is %q", word, body)
+			}
+		}(word)
+	}
+	wg.Wait()
+
+	f := NewFrame(CmdSend, nil)
+	f.Header.Set(HdrCorrelationId, "empty")
+	reply, requestErr := requester.Request(ctx, "/queue/upper", f)
+	var replyErr *ReplyError
+
+	if !errors.As(requestErr, &replyErr) || "nothing to convert" != replyErr.Message {
+		t.Errorf("expected a reply error, got %v", requestErr)
+	}
+
+	if id, _ := reply.Header.Get(HdrCorrelationId); "empty" != id {
+		t.Errorf("correlation-id = %q", id)
+	}
+
+	// A request nobody answers times out.
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+
+	if _, requestErr := requester.Request(shortCtx, "/queue/nobody", nil); context.DeadlineExceeded != requestErr {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, requestErr)
+	}
+	stop()
+
+	if respondErr := <-responded; context.Canceled != respondErr {
+		t.Errorf("Respond returned %v", respondErr)
+	}
+}
@@ -0,0 +1,120 @@
+package secure
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// ErrNoPrivateKey is returned by a signer that can only verify.
+var ErrNoPrivateKey = errors.New("no private key")
+
+// A KeyProvider supplies the AES keys bodies are encrypted with. Keys
+// are 16, 24 or 32 bytes long, selecting AES-128, AES-192 or AES-256.
+type KeyProvider interface {
+	// CurrentKey returns the key new bodies are encrypted with,
+	// and its id.
+	CurrentKey() (id string, key []byte, err error)
+
+	// Key returns the key with the given id, for decryption.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider holding a fixed set of keys. Keeping
+// retired keys in Keys lets bodies encrypted with them be decrypted
+// after the current key is rotated.
+type StaticKeys struct {
+	// Current is the id of the key new bodies are encrypted with.
+	Current string
+
+	// Keys maps key ids to keys.
+	Keys map[string][]byte
+}
+
+// CurrentKey returns the key named by Current.
+func (k *StaticKeys) CurrentKey() (string, []byte, error) {
+	key, keyErr := k.Key(k.Current)
+	return k.Current, key, keyErr
+}
+
+// Key returns the key with the given id.
+func (k *StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+
+	if !ok {
+		return nil, fmt.Errorf("%w. %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// A Signer signs and verifies messages with a single key.
+type Signer interface {
+	// Algorithm returns the name of the signature algorithm, which
+	// is sent in the signature-algorithm header.
+	Algorithm() string
+
+	// Sign returns the signature of msg.
+	Sign(msg []byte) ([]byte, error)
+
+	// Verify reports whether sig is a valid signature of msg.
+	Verify(msg, sig []byte) bool
+}
+
+type hmacSigner struct {
+	key []byte
+}
+
+// HMAC returns a signer computing HMAC-SHA256 with key, which both
+// the sender and the receiver must hold.
+func HMAC(key []byte) Signer {
+	return &hmacSigner{key: append([]byte(nil), key...)}
+}
+
+func (s *hmacSigner) Algorithm() string {
+	return "hmac-sha256"
+}
+
+func (s *hmacSigner) Sign(msg []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(msg)
+	return mac.Sum(nil), nil
+}
+
+func (s *hmacSigner) Verify(msg, sig []byte) bool {
+	expected, _ := s.Sign(msg)
+	return hmac.Equal(expected, sig)
+}
+
+type ed25519Signer struct {
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// Ed25519 returns a signer that signs with key, and verifies with
+// its public half.
+func Ed25519(key ed25519.PrivateKey) Signer {
+	return &ed25519Signer{private: key, public: key.Public().(ed25519.PublicKey)}
+}
+
+// Ed25519Public returns a signer that verifies signatures made with
+// the private half of key, and cannot sign.
+func Ed25519Public(key ed25519.PublicKey) Signer {
+	return &ed25519Signer{public: key}
+}
+
+func (s *ed25519Signer) Algorithm() string {
+	return "ed25519"
+}
+
+func (s *ed25519Signer) Sign(msg []byte) ([]byte, error) {
+	if nil == s.private {
+		return nil, ErrNoPrivateKey
+	}
+	return ed25519.Sign(s.private, msg), nil
+}
+
+func (s *ed25519Signer) Verify(msg, sig []byte) bool {
+	return ed25519.Verify(s.public, msg, sig)
+}
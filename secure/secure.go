@@ -0,0 +1,446 @@
+// Package secure protects STOMP message bodies end to end, across
+// brokers that are not trusted with their contents. A Sealer encrypts
+// bodies with AES-GCM, and signs bodies and selected headers with
+// HMAC-SHA256 or Ed25519; on receipt it verifies signatures and
+// decrypts bodies, rejecting tampered frames with a *Error.
+//
+// Encryption and signing are described by headers on the frame: the
+// encryption and encryption-key-id headers name the cipher and key;
+// the signature-algorithm, signature-key-id and signed-headers headers
+// name the algorithm, key and covered headers of the base64 signature
+// in the signature header. Bodies are encrypted before they are
+// signed, so a signature covers the ciphertext and the encryption
+// headers.
+package secure
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/jjware/stomp"
+)
+
+const (
+	// HdrEncryption names the cipher a body is encrypted with.
+	HdrEncryption = "encryption"
+
+	// HdrEncryptionKey is the id of the key a body is encrypted
+	// with.
+	HdrEncryptionKey = "encryption-key-id"
+
+	// HdrSignature is the base64 signature of a frame.
+	HdrSignature = "signature"
+
+	// HdrSignatureAlgorithm names the algorithm of a signature.
+	HdrSignatureAlgorithm = "signature-algorithm"
+
+	// HdrSignatureKey is the id of the key a frame is signed with.
+	HdrSignatureKey = "signature-key-id"
+
+	// HdrSignedHeaders lists the headers a signature covers,
+	// separated by commas.
+	HdrSignedHeaders = "signed-headers"
+)
+
+// CipherAESGCM is the value of the encryption header of bodies
+// encrypted by a Sealer.
+const CipherAESGCM = "aes-gcm"
+
+// DefaultSignedHeaders are the headers a Sealer signs when its
+// SignedHeaders are not set.
+var DefaultSignedHeaders = []string{stomp.HdrDestination, stomp.HdrContentType}
+
+// signaturePrefix begins the data that is signed, naming the format
+// of the rest.
+const signaturePrefix = "stomp-signature-v1"
+
+var (
+	// ErrUnknownKey is returned when a frame names a key that is
+	// not available.
+	ErrUnknownKey = errors.New("unknown key")
+
+	// ErrBadSignature is returned when a frame's signature does not
+	// match its contents.
+	ErrBadSignature = errors.New("bad signature")
+
+	// ErrUnsigned is returned when a frame that must be signed is
+	// not.
+	ErrUnsigned = errors.New("frame not signed")
+
+	// ErrUnencrypted is returned when a frame whose body must be
+	// encrypted is not.
+	ErrUnencrypted = errors.New("body not encrypted")
+
+	// ErrDecrypt is returned when a body cannot be decrypted with
+	// the key it names, which means it was altered.
+	ErrDecrypt = errors.New("decryption failed")
+)
+
+// An Error describes a frame a Sealer rejected.
+type Error struct {
+	// Op is the operation that failed: "verify" or "decrypt".
+	Op string
+
+	// KeyID is the id of the key involved, if any.
+	KeyID string
+
+	// Err is the reason, one of the errors of this package or one
+	// returned by a KeyProvider.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if "" == e.KeyID {
+		return "secure: " + e.Op + ": " + e.Err.Error()
+	}
+	return "secure: " + e.Op + " with key " + strconv.Quote(e.KeyID) + ": " + e.Err.Error()
+}
+
+// Unwrap returns e.Err.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Options configures a Sealer.
+type Options struct {
+	// Keys supplies the keys bodies are encrypted with. Nil leaves
+	// bodies unencrypted.
+	Keys KeyProvider
+
+	// Signers maps key ids to the signers that sign and verify
+	// with them.
+	Signers map[string]Signer
+
+	// SigningKey is the id of the key in Signers that frames are
+	// signed with. Empty leaves frames unsigned.
+	SigningKey string
+
+	// SignedHeaders lists the headers a signature covers, besides
+	// the encryption headers, which are always covered. Nil selects
+	// DefaultSignedHeaders. A received frame is rejected unless its
+	// signature covers every one of these headers.
+	SignedHeaders []string
+
+	// RequireSignature rejects received frames that are not signed.
+	RequireSignature bool
+
+	// RequireEncryption rejects received frames whose bodies are not
+	// encrypted.
+	RequireEncryption bool
+}
+
+// A Sealer seals the frames it sends, and opens the frames it
+// receives. The methods of a Sealer are thread safe, provided its
+// KeyProvider is.
+type Sealer struct {
+	opts Options
+}
+
+// New returns a sealer configured by opts.
+func New(opts Options) (*Sealer, error) {
+	if "" != opts.SigningKey {
+		if _, ok := opts.Signers[opts.SigningKey]; !ok {
+			return nil, fmt.Errorf("%w. signing key %q", ErrUnknownKey, opts.SigningKey)
+		}
+	}
+
+	if nil == opts.SignedHeaders {
+		opts.SignedHeaders = DefaultSignedHeaders
+	}
+	return &Sealer{opts: opts}, nil
+}
+
+// readBody reads f's body in full, replacing it with a copy.
+func readBody(f *stomp.Frame) ([]byte, error) {
+	if nil == f.Body {
+		return nil, nil
+	}
+	body, readErr := ioutil.ReadAll(f.Body)
+	closeErr := f.Body.Close()
+
+	if nil == readErr {
+		readErr = closeErr
+	}
+	f.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, readErr
+}
+
+func setBody(f *stomp.Frame, body []byte) {
+	f.Header.Set(stomp.HdrContentLength, strconv.Itoa(len(body)))
+	f.Body = ioutil.NopCloser(bytes.NewReader(body))
+}
+
+// Seal encrypts f's body, if the sealer has a KeyProvider, then signs
+// the frame, if it has a SigningKey.
+func (s *Sealer) Seal(f *stomp.Frame) error {
+	body, readErr := readBody(f)
+
+	if nil != readErr {
+		return readErr
+	}
+
+	if nil != s.opts.Keys {
+		id, key, keyErr := s.opts.Keys.CurrentKey()
+
+		if nil != keyErr {
+			return keyErr
+		}
+		sealed, sealErr := encrypt(key, id, body)
+
+		if nil != sealErr {
+			return sealErr
+		}
+		body = sealed
+		f.Header.Set(HdrEncryption, CipherAESGCM)
+		f.Header.Set(HdrEncryptionKey, id)
+		setBody(f, body)
+	}
+
+	if "" == s.opts.SigningKey {
+		return nil
+	}
+	signer := s.opts.Signers[s.opts.SigningKey]
+	names := s.signedHeaders(f)
+	f.Header.Set(HdrSignatureAlgorithm, signer.Algorithm())
+	f.Header.Set(HdrSignatureKey, s.opts.SigningKey)
+	f.Header.Set(HdrSignedHeaders, strings.Join(names, ","))
+	sig, signErr := signer.Sign(signedData(f, names, body))
+
+	if nil != signErr {
+		return signErr
+	}
+	f.Header.Set(HdrSignature, base64.StdEncoding.EncodeToString(sig))
+	return nil
+}
+
+// signedHeaders returns the headers a signature of f covers: the
+// configured headers, the encryption headers when f has them, and the
+// headers naming the signature's algorithm and key.
+func (s *Sealer) signedHeaders(f *stomp.Frame) []string {
+	names := append([]string(nil), s.opts.SignedHeaders...)
+
+	if _, ok := f.Header.Get(HdrEncryption); ok {
+		names = append(names, HdrEncryption, HdrEncryptionKey)
+	}
+	return append(names, HdrSignatureAlgorithm, HdrSignatureKey)
+}
+
+// signedData returns the data a signature of f covers: the values of
+// the named headers and the body, each preceded by its length so that
+// no two frames produce the same data.
+func signedData(f *stomp.Frame, names []string, body []byte) []byte {
+	var buf bytes.Buffer
+	var n [4]byte
+
+	field := func(p []byte) {
+		binary.BigEndian.PutUint32(n[:], uint32(len(p)))
+		buf.Write(n[:])
+		buf.Write(p)
+	}
+	buf.WriteString(signaturePrefix)
+
+	for _, name := range names {
+		values := f.Header[name]
+		field([]byte(name))
+		binary.BigEndian.PutUint32(n[:], uint32(len(values)))
+		buf.Write(n[:])
+
+		for _, v := range values {
+			field([]byte(v))
+		}
+	}
+	field(body)
+	return buf.Bytes()
+}
+
+// Open verifies f's signature and decrypts its body, removing the
+// headers that described them. A frame that is not signed, or not
+// encrypted, is accepted unless the sealer requires otherwise. A
+// frame that is rejected is left as it was received, and the error is
+// a *Error.
+func (s *Sealer) Open(f *stomp.Frame) error {
+	body, readErr := readBody(f)
+
+	if nil != readErr {
+		return readErr
+	}
+
+	if verifyErr := s.verify(f, body); nil != verifyErr {
+		return verifyErr
+	}
+	cipherName, encrypted := f.Header.Get(HdrEncryption)
+
+	if !encrypted {
+		if s.opts.RequireEncryption {
+			return &Error{Op: "decrypt", Err: ErrUnencrypted}
+		}
+		s.strip(f, false)
+		return nil
+	}
+	id, _ := f.Header.Get(HdrEncryptionKey)
+
+	if CipherAESGCM != cipherName {
+		return &Error{Op: "decrypt", KeyID: id, Err: fmt.Errorf("%w. unsupported cipher %q", ErrDecrypt, cipherName)}
+	}
+
+	if nil == s.opts.Keys {
+		return &Error{Op: "decrypt", KeyID: id, Err: ErrUnknownKey}
+	}
+	key, keyErr := s.opts.Keys.Key(id)
+
+	if nil != keyErr {
+		return &Error{Op: "decrypt", KeyID: id, Err: keyErr}
+	}
+	plain, decryptErr := decrypt(key, id, body)
+
+	if nil != decryptErr {
+		return &Error{Op: "decrypt", KeyID: id, Err: decryptErr}
+	}
+	s.strip(f, true)
+	setBody(f, plain)
+	return nil
+}
+
+func (s *Sealer) verify(f *stomp.Frame, body []byte) error {
+	encoded, signed := f.Header.Get(HdrSignature)
+
+	if !signed {
+		if s.opts.RequireSignature {
+			return &Error{Op: "verify", Err: ErrUnsigned}
+		}
+		return nil
+	}
+	id, _ := f.Header.Get(HdrSignatureKey)
+	signer, ok := s.opts.Signers[id]
+
+	if !ok {
+		return &Error{Op: "verify", KeyID: id, Err: ErrUnknownKey}
+	}
+
+	if algorithm, _ := f.Header.Get(HdrSignatureAlgorithm); signer.Algorithm() != algorithm {
+		return &Error{Op: "verify", KeyID: id, Err: fmt.Errorf("%w. algorithm %q, want %q", ErrBadSignature, algorithm, signer.Algorithm())}
+	}
+	list, _ := f.Header.Get(HdrSignedHeaders)
+	names := strings.Split(list, ",")
+	covered := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		covered[name] = true
+	}
+
+	for _, name := range s.signedHeaders(f) {
+		if !covered[name] {
+			return &Error{Op: "verify", KeyID: id, Err: fmt.Errorf("%w. header %q is not covered", ErrBadSignature, name)}
+		}
+	}
+	sig, decodeErr := base64.StdEncoding.DecodeString(encoded)
+
+	if nil != decodeErr || !signer.Verify(signedData(f, names, body), sig) {
+		return &Error{Op: "verify", KeyID: id, Err: ErrBadSignature}
+	}
+	return nil
+}
+
+// strip removes the headers describing a signature and, when
+// decrypted is set, encryption.
+func (s *Sealer) strip(f *stomp.Frame, decrypted bool) {
+	for _, name := range []string{HdrSignature, HdrSignatureAlgorithm, HdrSignatureKey, HdrSignedHeaders} {
+		f.Header.Del(name)
+	}
+
+	if decrypted {
+		f.Header.Del(HdrEncryption)
+		f.Header.Del(HdrEncryptionKey)
+	}
+}
+
+// encrypt seals body with AES-GCM under key, returning the nonce
+// followed by the ciphertext. The key id is authenticated as
+// additional data, so a body cannot be passed off under another key.
+func encrypt(key []byte, id string, body []byte) ([]byte, error) {
+	aead, aeadErr := newAEAD(key)
+
+	if nil != aeadErr {
+		return nil, aeadErr
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(body)+aead.Overhead())
+
+	if _, randErr := io.ReadFull(rand.Reader, nonce); nil != randErr {
+		return nil, randErr
+	}
+	return aead.Seal(nonce, nonce, body, []byte(id)), nil
+}
+
+func decrypt(key []byte, id string, sealed []byte) ([]byte, error) {
+	aead, aeadErr := newAEAD(key)
+
+	if nil != aeadErr {
+		return nil, aeadErr
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, openErr := aead.Open(nil, nonce, ciphertext, []byte(id))
+
+	if nil != openErr {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, blockErr := aes.NewCipher(key)
+
+	if nil != blockErr {
+		return nil, blockErr
+	}
+	return cipher.NewGCM(block)
+}
+
+// Interceptor returns an interceptor that seals outbound SEND frames
+// and opens inbound MESSAGE frames. A MESSAGE frame that fails to
+// open ends the connection with the error; applications that would
+// rather reject such messages individually should call Open on the
+// frames they receive instead.
+func (s *Sealer) Interceptor() stomp.Interceptor {
+	return &interceptor{s: s}
+}
+
+type interceptor struct {
+	s *Sealer
+}
+
+func (i *interceptor) InterceptSend(next stomp.SendFunc) stomp.SendFunc {
+	return func(ctx context.Context, f *stomp.Frame) error {
+		if nil != f && stomp.CmdSend == f.Command {
+			if sealErr := i.s.Seal(f); nil != sealErr {
+				return sealErr
+			}
+		}
+		return next(ctx, f)
+	}
+}
+
+func (i *interceptor) InterceptReceive(next stomp.ReceiveFunc) stomp.ReceiveFunc {
+	return func(ctx context.Context) (*stomp.Frame, error) {
+		f, readErr := next(ctx)
+
+		if nil != readErr || nil == f || stomp.CmdMessage != f.Command {
+			return f, readErr
+		}
+		return f, i.s.Open(f)
+	}
+}
@@ -0,0 +1,206 @@
+package secure
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjware/stomp"
+	"github.com/jjware/stomp/broker"
+)
+
+func newFrame(body string) *stomp.Frame {
+	f := stomp.NewFrame(stomp.CmdSend, strings.NewReader(body))
+	f.Header.Set(stomp.HdrDestination, "/queue/payroll")
+	f.Header.Set(stomp.HdrContentType, "text/plain")
+	return f
+}
+
+func body(t *testing.T, f *stomp.Frame) string {
+	t.Helper()
+	b, readErr := ioutil.ReadAll(f.Body)
+
+	if nil != readErr {
+		t.Fatal(readErr)
+	}
+	f.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return string(b)
+}
+
+func TestSealOpen(t *testing.T) {
+	public, private, keyErr := ed25519.GenerateKey(nil)
+
+	if nil != keyErr {
+		t.Fatal(keyErr)
+	}
+	keys := &StaticKeys{
+		Current: "k2",
+		Keys: map[string][]byte{
+			"k1": bytes.Repeat([]byte{1}, 16),
+			"k2": bytes.Repeat([]byte{2}, 32),
+		},
+	}
+	tests := []struct {
+		name           string
+		sender, reader Options
+	}{
+		{"Encrypt", Options{Keys: keys}, Options{Keys: keys, RequireEncryption: true}},
+		{"HMAC", Options{Signers: map[string]Signer{"h": HMAC([]byte("secret"))}, SigningKey: "h"},
+			Options{Signers: map[string]Signer{"h": HMAC([]byte("secret"))}, RequireSignature: true}},
+		{"Ed25519", Options{Keys: keys, Signers: map[string]Signer{"e": Ed25519(private)}, SigningKey: "e"},
+			Options{Keys: keys, Signers: map[string]Signer{"e": Ed25519Public(public)}, RequireSignature: true, RequireEncryption: true}},
+	}
+
+	for _, test := range tests {
+		sender, newErr := New(test.sender)
+
+		if nil != newErr {
+			t.Fatal(newErr)
+		}
+		reader, newErr := New(test.reader)
+
+		if nil != newErr {
+			t.Fatal(newErr)
+		}
+		f := newFrame("salary: 100")
+
+		if sealErr := sender.Seal(f); nil != sealErr {
+			t.Fatalf("%s: %v", test.name, sealErr)
+		}
+
+		if nil != test.sender.Keys && strings.Contains(body(t, f), "salary") {
+			t.Errorf("%s: body sent in the clear", test.name)
+		}
+
+		if openErr := reader.Open(f); nil != openErr {
+			t.Fatalf("%s: %v", test.name, openErr)
+		}
+
+		if got := body(t, f); "salary: 100" != got {
+			t.Errorf("%s: opened body %q", test.name, got)
+		}
+
+		for _, name := range []string{HdrEncryption, HdrEncryptionKey, HdrSignature, HdrSignedHeaders} {
+			if _, ok := f.Header.Get(name); ok {
+				t.Errorf("%s: %s header left on opened frame", test.name, name)
+			}
+		}
+	}
+}
+
+func TestTampering(t *testing.T) {
+	keys := &StaticKeys{Current: "k", Keys: map[string][]byte{"k": bytes.Repeat([]byte{7}, 32)}}
+	opts := Options{Keys: keys, Signers: map[string]Signer{"h": HMAC([]byte("secret"))}, SigningKey: "h"}
+	s, newErr := New(opts)
+
+	if nil != newErr {
+		t.Fatal(newErr)
+	}
+	unsigned := opts
+	unsigned.SigningKey = ""
+	unsignedSealer, _ := New(unsigned)
+
+	tests := []struct {
+		name   string
+		sealer *Sealer
+		tamper func(f *stomp.Frame)
+		want   error
+	}{
+		{"Destination", s, func(f *stomp.Frame) {
+			f.Header.Set(stomp.HdrDestination, "/queue/elsewhere")
+		}, ErrBadSignature},
+		{"Body", s, func(f *stomp.Frame) {
+			b := []byte(body(t, f))
+			b[len(b)-1] ^= 1
+			f.Body = ioutil.NopCloser(bytes.NewReader(b))
+		}, ErrBadSignature},
+		{"CoverageNarrowed", s, func(f *stomp.Frame) {
+			f.Header.Set(HdrSignedHeaders, HdrSignatureAlgorithm)
+		}, ErrBadSignature},
+		{"UnknownKey", s, func(f *stomp.Frame) {
+			f.Header.Set(HdrSignatureKey, "stolen")
+		}, ErrUnknownKey},
+		{"Ciphertext", unsignedSealer, func(f *stomp.Frame) {
+			b := []byte(body(t, f))
+			b[len(b)-1] ^= 1
+			f.Body = ioutil.NopCloser(bytes.NewReader(b))
+		}, ErrDecrypt},
+		{"Stripped", s, func(f *stomp.Frame) {
+			f.Header.Del(HdrSignature)
+			f.Header.Del(HdrEncryption)
+		}, nil},
+	}
+
+	for _, test := range tests {
+		f := newFrame("transfer 10")
+
+		if sealErr := test.sealer.Seal(f); nil != sealErr {
+			t.Fatal(sealErr)
+		}
+		test.tamper(f)
+		strict, _ := New(Options{Keys: keys, Signers: opts.Signers, RequireSignature: s == test.sealer, RequireEncryption: true})
+		openErr := strict.Open(f)
+
+		if nil == test.want {
+			if nil == openErr {
+				t.Errorf("%s: tampered frame accepted", test.name)
+			}
+			continue
+		}
+		var secErr *Error
+
+		if !errors.As(openErr, &secErr) || !errors.Is(openErr, test.want) {
+			t.Errorf("%s: expected a *Error wrapping %v, got %v", test.name, test.want, openErr)
+		}
+	}
+
+	if _, newErr = New(Options{SigningKey: "missing"}); !errors.Is(newErr, ErrUnknownKey) {
+		t.Errorf("expected %v, got %v", ErrUnknownKey, newErr)
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	keys := &StaticKeys{Current: "k", Keys: map[string][]byte{"k": bytes.Repeat([]byte{9}, 16)}}
+	s, newErr := New(Options{Keys: keys, Signers: map[string]Signer{"h": HMAC([]byte("secret"))}, SigningKey: "h", RequireSignature: true})
+
+	if nil != newErr {
+		t.Fatal(newErr)
+	}
+	srv := &stomp.Server{Handler: broker.New()}
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverConn, clientConn := net.Pipe()
+	go srv.ServeConn(serverConn)
+	client, connectErr := stomp.Connect(ctx, clientConn, nil, &stomp.ClientOptions{Interceptors: []stomp.Interceptor{s.Interceptor()}})
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer client.Close()
+	sub, subErr := client.Subscribe(ctx, "/queue/payroll", stomp.Header{stomp.HdrReceipt: {"subscribed"}})
+
+	if nil != subErr {
+		t.Fatal(subErr)
+	}
+
+	if sendErr := client.Send(ctx, "/queue/payroll", newFrame("salary: 200")); nil != sendErr {
+		t.Fatal(sendErr)
+	}
+	msg, receiveErr := sub.Receive(ctx)
+
+	if nil != receiveErr {
+		t.Fatal(receiveErr)
+	}
+
+	if got := body(t, msg); "salary: 200" != got {
+		t.Errorf("received %q", got)
+	}
+}
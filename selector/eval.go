@@ -0,0 +1,280 @@
+package selector
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type kind int
+
+const (
+	kindNull kind = iota
+	kindBool
+	kindNumber
+	kindText
+)
+
+// A value is the value of an expression. The null value also stands
+// for the unknown truth value.
+type value struct {
+	kind kind
+	b    bool
+	n    float64
+	s    string
+}
+
+var (
+	trueValue    = value{kind: kindBool, b: true}
+	falseValue   = value{kind: kindBool}
+	unknownValue = value{}
+)
+
+func boolValue(b bool) value {
+	if b {
+		return trueValue
+	}
+	return falseValue
+}
+
+// A node is an expression of a selector.
+type node interface {
+	eval(get func(name string) (string, bool)) value
+}
+
+func (v value) eval(get func(name string) (string, bool)) value {
+	return v
+}
+
+// An identifier is the value of a header, which is null if the header
+// is missing.
+type identifier string
+
+func (id identifier) eval(get func(name string) (string, bool)) value {
+	if s, ok := get(string(id)); ok {
+		return value{kind: kindText, s: s}
+	}
+	return unknownValue
+}
+
+// truth converts v to a truth value: a boolean, or unknown.
+func truth(v value) value {
+	switch v.kind {
+	case kindBool:
+		return v
+	case kindText:
+		switch strings.ToLower(v.s) {
+		case "true":
+			return trueValue
+		case "false":
+			return falseValue
+		}
+	}
+	return unknownValue
+}
+
+// numeric converts v to a number, or null.
+func numeric(v value) value {
+	switch v.kind {
+	case kindNumber:
+		return v
+	case kindText:
+		if n, parseErr := strconv.ParseFloat(strings.TrimSpace(v.s), 64); nil == parseErr {
+			return value{kind: kindNumber, n: n}
+		}
+	}
+	return unknownValue
+}
+
+// compare returns the sign of the difference between a and b, after
+// converting a text value to the kind of the other. It reports false
+// if they cannot be compared.
+func compare(a, b value) (int, bool) {
+	if kindNull == a.kind || kindNull == b.kind {
+		return 0, false
+	}
+
+	if a.kind != b.kind {
+		switch {
+		case kindNumber == a.kind || kindNumber == b.kind:
+			a, b = numeric(a), numeric(b)
+		case kindBool == a.kind || kindBool == b.kind:
+			a, b = truth(a), truth(b)
+		}
+
+		if kindNull == a.kind || kindNull == b.kind {
+			return 0, false
+		}
+	}
+
+	switch a.kind {
+	case kindNumber:
+		switch {
+		case a.n < b.n:
+			return -1, true
+		case a.n > b.n:
+			return 1, true
+		}
+		return 0, true
+	case kindText:
+		return strings.Compare(a.s, b.s), true
+	}
+
+	// Booleans are equal or not.
+	if a.b == b.b {
+		return 0, true
+	}
+	return 1, true
+}
+
+type logical struct {
+	op          string
+	left, right node
+}
+
+// eval applies the three-valued logic of SQL.
+func (n *logical) eval(get func(name string) (string, bool)) value {
+	l := truth(n.left.eval(get))
+
+	if kindBool == l.kind && l.b == ("OR" == n.op) {
+		return l
+	}
+	r := truth(n.right.eval(get))
+
+	if kindBool == r.kind && r.b == ("OR" == n.op) {
+		return r
+	}
+
+	if kindNull == l.kind || kindNull == r.kind {
+		return unknownValue
+	}
+	return boolValue("AND" == n.op)
+}
+
+type negation struct {
+	operand node
+}
+
+func (n *negation) eval(get func(name string) (string, bool)) value {
+	v := truth(n.operand.eval(get))
+
+	if kindNull == v.kind {
+		return v
+	}
+	return boolValue(!v.b)
+}
+
+type comparison struct {
+	op          string
+	left, right node
+}
+
+func (n *comparison) eval(get func(name string) (string, bool)) value {
+	c, ok := compare(n.left.eval(get), n.right.eval(get))
+
+	if !ok {
+		return unknownValue
+	}
+
+	switch n.op {
+	case "=":
+		return boolValue(0 == c)
+	case "<>":
+		return boolValue(0 != c)
+	case "<":
+		return boolValue(c < 0)
+	case "<=":
+		return boolValue(c <= 0)
+	case ">":
+		return boolValue(c > 0)
+	}
+	return boolValue(c >= 0)
+}
+
+type arithmetic struct {
+	op          string
+	left, right node
+}
+
+func (n *arithmetic) eval(get func(name string) (string, bool)) value {
+	l, r := numeric(n.left.eval(get)), numeric(n.right.eval(get))
+
+	if kindNull == l.kind || kindNull == r.kind {
+		return unknownValue
+	}
+
+	switch n.op {
+	case "+":
+		return value{kind: kindNumber, n: l.n + r.n}
+	case "-":
+		return value{kind: kindNumber, n: l.n - r.n}
+	case "*":
+		return value{kind: kindNumber, n: l.n * r.n}
+	}
+
+	if 0 == r.n {
+		return unknownValue
+	}
+	return value{kind: kindNumber, n: l.n / r.n}
+}
+
+type isNull struct {
+	operand node
+	negated bool
+}
+
+func (n *isNull) eval(get func(name string) (string, bool)) value {
+	return boolValue((kindNull == n.operand.eval(get).kind) != n.negated)
+}
+
+type between struct {
+	operand, low, high node
+	negated            bool
+}
+
+func (n *between) eval(get func(name string) (string, bool)) value {
+	v := n.operand.eval(get)
+	lc, lok := compare(v, n.low.eval(get))
+	hc, hok := compare(v, n.high.eval(get))
+
+	if !lok || !hok {
+		return unknownValue
+	}
+	return boolValue((lc >= 0 && hc <= 0) != n.negated)
+}
+
+type in struct {
+	operand node
+	values  []value
+	negated bool
+}
+
+func (n *in) eval(get func(name string) (string, bool)) value {
+	v := n.operand.eval(get)
+
+	if kindNull == v.kind {
+		return unknownValue
+	}
+
+	for _, candidate := range n.values {
+		if c, ok := compare(v, candidate); ok && 0 == c {
+			return boolValue(!n.negated)
+		}
+	}
+	return boolValue(n.negated)
+}
+
+type like struct {
+	operand node
+	re      *regexp.Regexp
+	negated bool
+}
+
+func (n *like) eval(get func(name string) (string, bool)) value {
+	v := n.operand.eval(get)
+
+	if kindText != v.kind {
+		return unknownValue
+	}
+	return boolValue(n.re.MatchString(v.s) != n.negated)
+}
@@ -0,0 +1,497 @@
+// Package selector implements message selectors: expressions over the
+// headers of a message, in the SQL-92 based language of JMS message
+// selectors, that decide whether a subscription receives it.
+//
+// A selector compares headers with literals and with one another:
+//
+//	type = 'order' AND (region IN ('eu', 'us') OR priority > 5)
+//	"content-type" LIKE 'application/%' AND retry IS NULL
+//
+// Identifiers name headers, and are quoted with double quotes when
+// they contain characters other than letters, digits, '_', '$' and
+// '.'. Literals are strings in single quotes, in which a quote is
+// doubled, numbers, and TRUE and FALSE. Expressions combine them with
+// the comparisons =, <>, <, <=, > and >=, the arithmetic operators
+// +, -, * and /, and the predicates [NOT] BETWEEN, [NOT] IN,
+// [NOT] LIKE with an optional ESCAPE and IS [NOT] NULL, joined by
+// AND, OR and NOT. Keywords are not case sensitive.
+//
+// Header values are strings, and are converted to numbers or booleans
+// when compared with them. A missing header, or a value that cannot be
+// converted, is NULL, and makes a comparison unknown, as in SQL; a
+// message matches a selector only when the selector is true of it.
+package selector
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jjware/stomp"
+)
+
+// HdrSelector is the SUBSCRIBE header holding a selector, as
+// understood by the broker package.
+const HdrSelector = "selector"
+
+// ErrSyntax is returned for a selector that cannot be parsed.
+var ErrSyntax = errors.New("selector syntax error")
+
+// A Selector is a parsed selector. A Selector is safe for concurrent
+// use.
+type Selector struct {
+	src  string
+	root node
+}
+
+// Parse parses a selector.
+func Parse(src string) (*Selector, error) {
+	p := &parser{lex: lexer{src: src}}
+	p.next()
+	root := p.or()
+
+	if nil == p.err && tokEOF != p.tok.kind {
+		p.fail("unexpected %s", p.tok)
+	}
+
+	if nil != p.err {
+		return nil, p.err
+	}
+	return &Selector{src: src, root: root}, nil
+}
+
+// MustParse is like Parse, but panics if the selector cannot be
+// parsed.
+func MustParse(src string) *Selector {
+	s, parseErr := Parse(src)
+
+	if nil != parseErr {
+		panic(parseErr)
+	}
+	return s
+}
+
+// String returns the selector's source.
+func (s *Selector) String() string {
+	return s.src
+}
+
+// Match reports whether the selector is true of a message with the
+// given header.
+func (s *Selector) Match(h stomp.Header) bool {
+	return s.MatchFunc(h.Get)
+}
+
+// MatchFunc reports whether the selector is true of a message whose
+// headers are looked up with get.
+func (s *Selector) MatchFunc(get func(name string) (string, bool)) bool {
+	v := truth(s.root.eval(get))
+	return kindBool == v.kind && v.b
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokKeyword
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of selector"
+	case tokString:
+		return "string '" + t.text + "'"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+var keywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "IN": true, "LIKE": true, "ESCAPE": true,
+	"IS": true, "NULL": true, "BETWEEN": true, "TRUE": true, "FALSE": true,
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func isIdentStart(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '_' == c || '$' == c
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || '.' == c
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// scan returns the next token.
+func (l *lexer) scan() (token, error) {
+	for l.pos < len(l.src) && strings.IndexByte(" \t\r\n", l.src[l.pos]) >= 0 {
+		l.pos++
+	}
+	start := l.pos
+
+	if l.pos == len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+
+	switch {
+	case isIdentStart(c):
+		for l.pos < len(l.src) && isIdentPart(l.src[l.pos]) {
+			l.pos++
+		}
+		text := l.src[start:l.pos]
+
+		if upper := strings.ToUpper(text); keywords[upper] {
+			return token{kind: tokKeyword, text: upper, pos: start}, nil
+		}
+		return token{kind: tokIdent, text: text, pos: start}, nil
+	case isDigit(c) || '.' == c && l.pos+1 < len(l.src) && isDigit(l.src[l.pos+1]):
+		for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || '.' == l.src[l.pos]) {
+			l.pos++
+		}
+
+		if l.pos < len(l.src) && ('e' == l.src[l.pos] || 'E' == l.src[l.pos]) {
+			l.pos++
+
+			if l.pos < len(l.src) && ('+' == l.src[l.pos] || '-' == l.src[l.pos]) {
+				l.pos++
+			}
+
+			for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+				l.pos++
+			}
+		}
+		return token{kind: tokNumber, text: l.src[start:l.pos], pos: start}, nil
+	case '\'' == c || '"' == c:
+		var b strings.Builder
+		l.pos++
+
+		for {
+			i := strings.IndexByte(l.src[l.pos:], c)
+
+			if i < 0 {
+				return token{}, fmt.Errorf("%w. unterminated quote at offset %d", ErrSyntax, start)
+			}
+			b.WriteString(l.src[l.pos : l.pos+i])
+			l.pos += i + 1
+
+			// A doubled quote stands for itself.
+			if l.pos < len(l.src) && c == l.src[l.pos] {
+				b.WriteByte(c)
+				l.pos++
+				continue
+			}
+			break
+		}
+
+		if '"' == c {
+			return token{kind: tokIdent, text: b.String(), pos: start}, nil
+		}
+		return token{kind: tokString, text: b.String(), pos: start}, nil
+	}
+
+	for _, op := range []string{"<>", "<=", ">=", "=", "<", ">", "(", ")", ",", "+", "-", "*", "/"} {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	return token{}, fmt.Errorf("%w. unexpected %q at offset %d", ErrSyntax, c, start)
+}
+
+// maxDepth bounds the nesting of selectors, so that parsing one does
+// not exhaust the stack.
+const maxDepth = 100
+
+// A parser is a recursive descent parser of selectors. The first
+// error it meets stops it.
+type parser struct {
+	lex   lexer
+	tok   token
+	err   error
+	depth int
+}
+
+func (p *parser) next() {
+	if nil != p.err {
+		return
+	}
+	tok, scanErr := p.lex.scan()
+
+	if nil != scanErr {
+		p.err = scanErr
+		p.tok = token{kind: tokEOF, pos: p.lex.pos}
+		return
+	}
+	p.tok = tok
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	if nil == p.err {
+		p.err = fmt.Errorf("%w. %s at offset %d", ErrSyntax, fmt.Sprintf(format, args...), p.tok.pos)
+	}
+}
+
+// is reports whether the current token is the given keyword or
+// operator.
+func (p *parser) is(text string) bool {
+	return (tokKeyword == p.tok.kind || tokOp == p.tok.kind) && text == p.tok.text
+}
+
+// accept consumes the current token if it is the given keyword or
+// operator.
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) {
+	if !p.accept(text) {
+		p.fail("expected %s, found %s", text, p.tok)
+	}
+}
+
+func (p *parser) or() node {
+	n := p.and()
+
+	for nil == p.err && p.accept("OR") {
+		n = &logical{op: "OR", left: n, right: p.and()}
+	}
+	return n
+}
+
+func (p *parser) and() node {
+	n := p.not()
+
+	for nil == p.err && p.accept("AND") {
+		n = &logical{op: "AND", left: n, right: p.not()}
+	}
+	return n
+}
+
+func (p *parser) not() node {
+	p.depth++
+	defer func() {
+		p.depth--
+	}()
+
+	if p.depth > maxDepth {
+		p.fail("selector nested too deeply")
+		return value{}
+	}
+
+	if p.accept("NOT") {
+		return &negation{p.not()}
+	}
+	return p.predicate()
+}
+
+func (p *parser) predicate() node {
+	n := p.additive()
+
+	if nil != p.err {
+		return n
+	}
+
+	for _, op := range []string{"=", "<>", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			return &comparison{op: op, left: n, right: p.additive()}
+		}
+	}
+
+	if p.accept("IS") {
+		negated := p.accept("NOT")
+		p.expect("NULL")
+		return &isNull{operand: n, negated: negated}
+	}
+	negated := p.accept("NOT")
+
+	switch {
+	case p.accept("BETWEEN"):
+		low := p.additive()
+		p.expect("AND")
+		return &between{operand: n, low: low, high: p.additive(), negated: negated}
+	case p.accept("IN"):
+		p.expect("(")
+		set := &in{operand: n, negated: negated}
+
+		for nil == p.err {
+			set.values = append(set.values, p.literal())
+
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.expect(")")
+		return set
+	case p.accept("LIKE"):
+		pattern := p.literal()
+		escape := ""
+
+		if p.accept("ESCAPE") {
+			escape = p.literal().s
+
+			if 1 != len(escape) {
+				p.fail("escape must be a single character")
+			}
+		}
+
+		if nil != p.err {
+			return n
+		}
+
+		if kindText != pattern.kind {
+			p.fail("LIKE pattern must be a string")
+			return n
+		}
+		return &like{operand: n, re: likePattern(pattern.s, escape), negated: negated}
+	}
+
+	if negated {
+		p.fail("expected BETWEEN, IN or LIKE, found %s", p.tok)
+	}
+	return n
+}
+
+// likePattern compiles a LIKE pattern, in which % matches any run of
+// characters and _ matches any one character, to a regular expression.
+func likePattern(pattern, escape string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^(?s:")
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+
+		switch {
+		case "" != escape && escape[0] == c && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '%' == c:
+			b.WriteString(".*")
+		case '_' == c:
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString(")$")
+	return regexp.MustCompile(b.String())
+}
+
+// literal parses a string, number or boolean literal, which may be a
+// signed number.
+func (p *parser) literal() value {
+	sign := 1.0
+
+	if p.accept("-") {
+		sign = -1
+	} else {
+		p.accept("+")
+	}
+	tok := p.tok
+
+	switch {
+	case tokNumber == tok.kind:
+		p.next()
+		return p.number(tok, sign)
+	case 1 == sign && tokString == tok.kind:
+		p.next()
+		return value{kind: kindText, s: tok.text}
+	case 1 == sign && p.accept("TRUE"):
+		return value{kind: kindBool, b: true}
+	case 1 == sign && p.accept("FALSE"):
+		return value{kind: kindBool}
+	}
+	p.fail("expected a literal, found %s", tok)
+	return value{}
+}
+
+func (p *parser) number(tok token, sign float64) value {
+	n, parseErr := strconv.ParseFloat(tok.text, 64)
+
+	if nil != parseErr {
+		p.fail("invalid number %s", tok.text)
+	}
+	return value{kind: kindNumber, n: sign * n}
+}
+
+func (p *parser) additive() node {
+	n := p.multiplicative()
+
+	for nil == p.err && (p.is("+") || p.is("-")) {
+		op := p.tok.text
+		p.next()
+		n = &arithmetic{op: op, left: n, right: p.multiplicative()}
+	}
+	return n
+}
+
+func (p *parser) multiplicative() node {
+	n := p.unary()
+
+	for nil == p.err && (p.is("*") || p.is("/")) {
+		op := p.tok.text
+		p.next()
+		n = &arithmetic{op: op, left: n, right: p.unary()}
+	}
+	return n
+}
+
+func (p *parser) unary() node {
+	p.depth++
+	defer func() {
+		p.depth--
+	}()
+
+	if p.depth > maxDepth {
+		p.fail("selector nested too deeply")
+		return value{}
+	}
+
+	if p.accept("-") {
+		return &arithmetic{op: "-", left: value{kind: kindNumber}, right: p.unary()}
+	}
+
+	if p.accept("+") {
+		return &arithmetic{op: "+", left: value{kind: kindNumber}, right: p.unary()}
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() node {
+	tok := p.tok
+
+	switch {
+	case tokIdent == tok.kind:
+		p.next()
+		return identifier(tok.text)
+	case p.accept("("):
+		n := p.or()
+		p.expect(")")
+		return n
+	case p.accept("NULL"):
+		return value{}
+	}
+	return p.literal()
+}
@@ -0,0 +1,111 @@
+package selector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jjware/stomp"
+)
+
+func TestMatch(t *testing.T) {
+	h := stomp.Header{
+		"type":         {"order"},
+		"region":       {"eu"},
+		"priority":     {"7"},
+		"price":        {"12.50"},
+		"urgent":       {"true"},
+		"content-type": {"application/json"},
+		"note":         {"it's 100%"},
+		"app.version":  {"2"},
+	}
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"type = 'order'", true},
+		{"TYPE = 'order'", false},
+		{"type <> 'order'", false},
+		{"type = 'order' and region = 'us'", false},
+		{"type = 'order' OR region = 'us'", true},
+		{"NOT type = 'quote'", true},
+		{"priority > 5", true},
+		{"priority >= 7 AND priority <= 7", true},
+		{"priority < 10.5", true},
+		{"price = 12.5", true},
+		{"price * 2 = 25", true},
+		{"priority + 1 - 3 / 3 = 7", true},
+		{"-priority = -7", true},
+		{"priority / 0 = 1", false},
+		{"priority BETWEEN 5 AND 10", true},
+		{"priority NOT BETWEEN 5 AND 10", false},
+		{"region IN ('eu', 'us')", true},
+		{"region NOT IN ('eu', 'us')", false},
+		{"priority IN (1, 7)", true},
+		{"\"content-type\" LIKE 'application/%'", true},
+		{"\"content-type\" LIKE 'application/_son'", true},
+		{"\"content-type\" LIKE 'application'", false},
+		{"\"content-type\" NOT LIKE 'text/%'", true},
+		{"note LIKE 'it''s 100!%' ESCAPE '!'", true},
+		{"note LIKE 'it''s 1!%' ESCAPE '!'", false},
+		{"type LIKE 'o.der'", false},
+		{"retry IS NULL", true},
+		{"type IS NOT NULL", true},
+		{"urgent", true},
+		{"urgent = TRUE", true},
+		{"urgent = false", false},
+		{"app.version = 2", true},
+		{"type > 'apple'", true},
+
+		// Comparisons with NULL are unknown, and so is their negation.
+		{"retry = 1", false},
+		{"NOT retry = 1", false},
+		{"retry = 1 OR type = 'order'", true},
+		{"NOT (retry = 1 AND type = 'quote')", true},
+		{"retry IN ('a')", false},
+		{"retry NOT IN ('a')", false},
+		{"type > 5", false},
+		{"NOT type > 5", false},
+		{"type", false},
+	}
+
+	for _, test := range tests {
+		s, parseErr := Parse(test.selector)
+
+		if nil != parseErr {
+			t.Errorf("%s: %v", test.selector, parseErr)
+			continue
+		}
+
+		if got := s.Match(h); got != test.want {
+			t.Errorf("%s: matched %v, expected %v", test.selector, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, bad := range []string{
+		"",
+		"type =",
+		"type = 'order",
+		"type == 'order'",
+		"(type = 'order'",
+		"type = 'order')",
+		"type IN ()",
+		"type IN ('a',)",
+		"type NOT 'a'",
+		"type LIKE 5",
+		"type LIKE 'a' ESCAPE 'ab'",
+		"type IS 'a'",
+		"priority BETWEEN 1",
+		"type = 'a' # comment",
+		"1..2 = 1",
+		strings.Repeat("(", 1000) + "a" + strings.Repeat(")", 1000),
+		strings.Repeat("-", 1000) + "1 = 1",
+	} {
+		if _, parseErr := Parse(bad); !errors.Is(parseErr, ErrSyntax) {
+			t.Errorf("%q: expected %v, got %v", bad, ErrSyntax, parseErr)
+		}
+	}
+}
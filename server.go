@@ -0,0 +1,617 @@
+package stomp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultConnectTimeout is how long a Server waits for the CONNECT
+// frame of a new connection when its ConnectTimeout is zero.
+const DefaultConnectTimeout = 30 * time.Second
+
+// supportedVersions lists the protocol versions a Server speaks,
+// from oldest to newest.
+var supportedVersions = []string{"1.0", "1.1", "1.2"}
+
+var (
+	// ErrServerClosed is returned by Serve after the server is
+	// closed.
+	ErrServerClosed = errors.New("server closed")
+
+	// ErrSessionClosed is returned when sending to a session that
+	// has ended.
+	ErrSessionClosed = errors.New("session closed")
+)
+
+// A Handler responds to the frames a client sends to a Server. The
+// server itself handles the CONNECT, STOMP and DISCONNECT frames, and
+// passes every other frame to ServeFrame with its body read in full.
+//
+// ServeFrame is called for a session's frames in the order they were
+// received. If ServeFrame returns an error, the server sends an ERROR
+// frame describing it and closes the connection. Otherwise, if the
+// frame requested a receipt, the server sends a RECEIPT frame.
+type Handler interface {
+	ServeFrame(s *Session, f *Frame) error
+}
+
+// The HandlerFunc type is an adapter to allow the use of an ordinary
+// function as a Handler.
+type HandlerFunc func(s *Session, f *Frame) error
+
+// ServeFrame calls fn(s, f).
+func (fn HandlerFunc) ServeFrame(s *Session, f *Frame) error {
+	return fn(s, f)
+}
+
+// A SessionHandler is a Handler that is told when sessions begin and
+// end. If OpenSession returns an error, the client is sent an ERROR
+// frame instead of a CONNECTED frame. CloseSession is called once
+// for every session that was successfully opened.
+type SessionHandler interface {
+	Handler
+	OpenSession(s *Session) error
+	CloseSession(s *Session)
+}
+
+// A Server accepts STOMP client connections, performs the handshake,
+// and passes the frames of each session to its Handler.
+type Server struct {
+	// Handler handles the frames of every session.
+	Handler Handler
+
+	// Name is sent to clients in the server header, when set.
+	Name string
+
+	// HeartBeat is the interval at which the server is willing to
+	// send heart-beats, and would like to receive them. Zero
+	// disables heart-beats.
+	HeartBeat time.Duration
+
+	// ConnectTimeout limits how long a new connection may take to
+	// send its CONNECT frame. Zero selects DefaultConnectTimeout.
+	ConnectTimeout time.Duration
+
+	// Logger receives the server's events. A nil Logger discards
+	// them.
+	Logger Logger
+
+	// Interceptors, when set, is called for every new connection
+	// and the interceptors returned are installed on its handle.
+	Interceptors func() []Interceptor
+
+	// Authenticator, when set, checks the credentials of every
+	// CONNECT frame. A client it rejects is sent an ERROR frame and
+	// disconnected.
+	Authenticator Authenticator
+
+	// Authorizer, when set, decides which SEND, SUBSCRIBE, ACK,
+	// NACK and BEGIN frames reach the Handler. A session sending a
+	// frame it denies is sent an ERROR frame and closed.
+	Authorizer Authorizer
+
+	// Hosts, when set, maps the values of the CONNECT frame's host
+	// header to the virtual hosts serving them, in place of the
+	// server's Handler, Authenticator and Authorizer. A client naming
+	// no host in Hosts is sent an ERROR frame. Clients of STOMP 1.0
+	// may send no host header, which names the host "".
+	Hosts map[string]*VirtualHost
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*trackedConn]struct{}
+	closed    bool
+	nextID    uint64
+}
+
+type trackedConn struct {
+	io.Closer
+}
+
+// ListenAndServe listens on the TCP network address addr and then
+// calls Serve.
+func (srv *Server) ListenAndServe(addr string) error {
+	l, listenErr := net.Listen("tcp", addr)
+
+	if nil != listenErr {
+		return listenErr
+	}
+	return srv.Serve(l)
+}
+
+// Serve accepts connections on l, serving each in a new goroutine.
+// Serve always returns a non-nil error; after Close it returns
+// ErrServerClosed.
+func (srv *Server) Serve(l net.Listener) error {
+	srv.mu.Lock()
+
+	if srv.closed {
+		srv.mu.Unlock()
+		return ErrServerClosed
+	}
+
+	if nil == srv.listeners {
+		srv.listeners = make(map[net.Listener]struct{})
+	}
+	srv.listeners[l] = struct{}{}
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.listeners, l)
+		srv.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, acceptErr := l.Accept()
+
+		if nil != acceptErr {
+			srv.mu.Lock()
+			closed := srv.closed
+			srv.mu.Unlock()
+
+			if closed {
+				return ErrServerClosed
+			}
+			return acceptErr
+		}
+		go srv.ServeConn(conn)
+	}
+}
+
+// Close closes the server's listeners and every connection it is
+// serving.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	srv.closed = true
+	listeners := srv.listeners
+	conns := srv.conns
+	srv.listeners = nil
+	srv.conns = nil
+	srv.mu.Unlock()
+
+	for l := range listeners {
+		l.Close()
+	}
+
+	for c := range conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (srv *Server) logger() Logger {
+	if nil == srv.Logger {
+		return NopLogger{}
+	}
+	return srv.Logger
+}
+
+// track adds c to, or removes it from, the connections closed by
+// Close. track reports false when adding to a closed server.
+func (srv *Server) track(c *trackedConn, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if !add {
+		delete(srv.conns, c)
+		return true
+	}
+
+	if srv.closed {
+		return false
+	}
+
+	if nil == srv.conns {
+		srv.conns = make(map[*trackedConn]struct{})
+	}
+	srv.conns[c] = struct{}{}
+	return true
+}
+
+// ServeConn serves a single client connection, returning once the
+// session has ended. The connection is closed on return.
+func (srv *Server) ServeConn(conn io.ReadWriteCloser) {
+	tracked := &trackedConn{conn}
+	defer conn.Close()
+
+	if !srv.track(tracked, true) {
+		return
+	}
+	defer srv.track(tracked, false)
+
+	log := srv.logger()
+	handle := Bind(conn)
+	handle.SetLogger(srv.Logger)
+
+	if nil != srv.Interceptors {
+		handle.Use(srv.Interceptors()...)
+	}
+
+	s := &Session{
+		srv:    srv,
+		handle: handle,
+		conn:   conn,
+		done:   make(chan struct{}),
+		id:     "session-" + strconv.FormatUint(atomic.AddUint64(&srv.nextID, 1), 10),
+	}
+	defer s.release()
+
+	timeout := srv.ConnectTimeout
+
+	if 0 == timeout {
+		timeout = DefaultConnectTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	f, readErr := s.receive(ctx)
+	cancel()
+
+	if nil != readErr {
+		log.Debug("connection ended before CONNECT", "error", readErr)
+		return
+	}
+	body, ok := s.readBody(f, srv.connectBodySize())
+
+	if !ok {
+		return
+	}
+
+	if f.Command != CmdConnect && f.Command != CmdStomp {
+		s.sendError(f, fmt.Errorf("expected %s frame, got %s", CmdConnect, f.Command))
+		return
+	}
+	cx, cy, negotiateErr := s.negotiate(f)
+
+	if nil != negotiateErr {
+		s.sendError(f, negotiateErr)
+		return
+	}
+
+	vhost, hostErr := srv.virtualHost(s.host)
+
+	if nil != hostErr {
+		log.Warn("unknown host", "host", s.host)
+		s.sendError(f, hostErr)
+		return
+	}
+	s.vhost = vhost
+
+	if vhost.MaxBodySize > 0 && int64(len(body)) > vhost.MaxBodySize {
+		s.sendError(f, fmt.Errorf("%w. the limit is %d bytes", ErrBodyTooLarge, vhost.MaxBodySize))
+		return
+	}
+
+	if nil != vhost.Authenticator {
+		principal, authErr := srv.authenticate(vhost.Authenticator, conn, f)
+
+		if nil != authErr {
+			log.Warn("authentication failed", "login", s.login, "error", authErr)
+			s.sendError(f, ErrAuthenticationFailed)
+			return
+		}
+		s.principal = principal
+	}
+
+	if acquireErr := vhost.acquire(); nil != acquireErr {
+		log.Warn("session refused", "host", s.host, "login", s.login, "error", acquireErr)
+		s.sendError(f, acquireErr)
+		return
+	}
+	defer vhost.release()
+
+	if sh, ok := vhost.Handler.(SessionHandler); ok {
+		if openErr := sh.OpenSession(s); nil != openErr {
+			s.sendError(f, openErr)
+			return
+		}
+		defer sh.CloseSession(s)
+	}
+	connected := NewFrame(CmdConnected, nil)
+	connected.Header.Set(HdrVersion, s.version)
+	connected.Header.Set(HdrSession, s.id)
+	connected.Header.Set(HdrHeartBeat, formatHeartBeat(srv.HeartBeat, srv.HeartBeat))
+
+	if "" != srv.Name {
+		connected.Header.Set(HdrServer, srv.Name)
+	}
+
+	if sendErr := s.Send(context.Background(), connected); nil != sendErr {
+		log.Debug("failed to send CONNECTED frame", "session", s.id, "error", sendErr)
+		return
+	}
+	log.Info("session opened", "session", s.id, "version", s.version, "host", s.host, "login", s.login)
+
+	beater := newHeartBeater(s.Send, heartBeatInterval(srv.HeartBeat, cy), heartBeatInterval(cx, srv.HeartBeat))
+	go beater.run(s.done, func() {
+		log.Warn("client heart-beat missed", "session", s.id, "interval", beater.receive)
+		handle.heartBeatMissed()
+		s.Close()
+	})
+	s.serve(beater)
+	log.Info("session closed", "session", s.id)
+}
+
+// authenticate checks the credentials of a CONNECT frame received on
+// conn with auth.
+func (srv *Server) authenticate(auth Authenticator, conn io.ReadWriteCloser, f *Frame) (*Principal, error) {
+	req := &AuthRequest{Header: f.Header}
+	req.Login, _ = f.Header.Get(HdrLogin)
+	req.Passcode, _ = f.Header.Get(HdrPasscode)
+
+	if tc, ok := conn.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		req.TLS = &state
+	}
+
+	if nc, ok := conn.(net.Conn); ok {
+		req.RemoteAddr = nc.RemoteAddr()
+	}
+	timeout := srv.ConnectTimeout
+
+	if 0 == timeout {
+		timeout = DefaultConnectTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	principal, authErr := auth.Authenticate(ctx, req)
+
+	if nil == authErr && nil == principal {
+		authErr = fmt.Errorf("%w. no principal", ErrAuthenticationFailed)
+	}
+	return principal, authErr
+}
+
+// A Session is a client's connection to a Server, from its CONNECT
+// frame to its end. The methods of a Session are thread safe.
+type Session struct {
+	srv     *Server
+	vhost   *VirtualHost
+	handle  *Handle
+	conn    io.Closer
+	id      string
+	version string
+	host    string
+	login   string
+	header  Header
+
+	principal *Principal
+
+	sendMu   sync.RWMutex
+	released bool
+	done     chan struct{}
+	once     sync.Once
+}
+
+// ID returns the session identifier sent to the client.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Version returns the protocol version negotiated with the client.
+func (s *Session) Version() string {
+	return s.version
+}
+
+// Host returns the value of the CONNECT frame's host header.
+func (s *Session) Host() string {
+	return s.host
+}
+
+// VirtualHost returns the host serving the session. For a server
+// without Hosts, it is made of the server's own Handler,
+// Authenticator and Authorizer.
+func (s *Session) VirtualHost() *VirtualHost {
+	return s.vhost
+}
+
+// Login returns the value of the CONNECT frame's login header.
+func (s *Session) Login() string {
+	return s.login
+}
+
+// ConnectHeader returns the header of the CONNECT frame that began
+// the session, with the passcode redacted.
+func (s *Session) ConnectHeader() Header {
+	return s.header
+}
+
+// Principal returns the identity the server's Authenticator gave the
+// client, or nil if the server has no Authenticator.
+func (s *Session) Principal() *Principal {
+	return s.principal
+}
+
+// Done returns a channel that is closed when the session ends.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Send sends a frame to the client. A nil frame sends a heart-beat.
+func (s *Session) Send(ctx context.Context, f *Frame) error {
+	s.sendMu.RLock()
+	defer s.sendMu.RUnlock()
+
+	if s.released {
+		return ErrSessionClosed
+	}
+	return s.handle.Send(ctx, f)
+}
+
+// Close ends the session and closes its connection.
+func (s *Session) Close() error {
+	var closeErr error
+
+	s.once.Do(func() {
+		close(s.done)
+		closeErr = s.conn.Close()
+	})
+	return closeErr
+}
+
+// release ends the session and releases its handle.
+func (s *Session) release() {
+	s.Close()
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	if !s.released {
+		s.handle.Release()
+		s.released = true
+	}
+}
+
+// receive returns the next frame, skipping heart-beats.
+func (s *Session) receive(ctx context.Context) (*Frame, error) {
+	for {
+		f, readErr := s.handle.Receive(ctx)
+
+		if nil != readErr {
+			return nil, readErr
+		}
+
+		if nil != f {
+			return f, nil
+		}
+	}
+}
+
+// readBody reads the body of f in full, unless it exceeds limit
+// bytes, when the client is sent an ERROR frame. It reports whether
+// the session may go on.
+func (s *Session) readBody(f *Frame, limit int64) ([]byte, bool) {
+	body, bodyErr := bufferBodyLimit(f, limit)
+
+	if errors.Is(bodyErr, ErrBodyTooLarge) {
+		s.sendError(f, bodyErr)
+
+		// Closing the body drains the rest of it, which ends once the
+		// connection is closed with the session.
+		go f.Body.Close()
+	}
+	return body, nil == bodyErr
+}
+
+// negotiate settles the protocol version from a CONNECT frame, and
+// returns the heart-beat intervals the client asked for.
+func (s *Session) negotiate(f *Frame) (time.Duration, time.Duration, error) {
+	accepted := []string{"1.0"}
+
+	if v, ok := f.Header.Get(HdrAcceptVersion); ok {
+		accepted = strings.Split(v, ",")
+	}
+
+	for i := len(supportedVersions) - 1; i >= 0 && "" == s.version; i-- {
+		for _, v := range accepted {
+			if strings.TrimSpace(v) == supportedVersions[i] {
+				s.version = supportedVersions[i]
+				break
+			}
+		}
+	}
+
+	if "" == s.version {
+		return 0, 0, fmt.Errorf("supported protocol versions are %s", strings.Join(supportedVersions, ","))
+	}
+	s.host, _ = f.Header.Get(HdrHost)
+	s.login, _ = f.Header.Get(HdrLogin)
+	s.header = RedactHeader(f.Header)
+	hb, _ := f.Header.Get(HdrHeartBeat)
+	return parseHeartBeat(hb)
+}
+
+// serve passes the session's frames to the server's handler until
+// the session ends.
+func (s *Session) serve(beater *heartBeater) {
+	log := s.srv.logger()
+	vhost := s.vhost
+
+	for {
+		f, readErr := s.handle.Receive(context.Background())
+
+		if nil != readErr {
+			if io.EOF != readErr {
+				log.Debug("session read failed", "session", s.id, "error", readErr)
+			}
+			return
+		}
+		beater.touch()
+
+		if nil == f {
+			continue
+		}
+
+		if _, ok := s.readBody(f, vhost.MaxBodySize); !ok {
+			return
+		}
+
+		switch f.Command {
+		case CmdDisconnect:
+			s.sendReceipt(f)
+			return
+		case CmdConnect, CmdStomp:
+			s.sendError(f, errors.New("session already established"))
+			return
+		}
+
+		if nil == vhost.Handler {
+			s.sendError(f, fmt.Errorf("unsupported frame %s", f.Command))
+			return
+		}
+
+		if nil != vhost.Authorizer && authorized(f.Command) {
+			if authErr := vhost.Authorizer.Authorize(s, f); nil != authErr {
+				log.Warn("frame not authorized", "session", s.id, "command", f.Command.String(), "error", authErr)
+				s.sendError(f, authErr)
+				return
+			}
+		}
+
+		if serveErr := vhost.Handler.ServeFrame(s, f); nil != serveErr {
+			log.Debug("closing session after error", "session", s.id, "command", f.Command.String(), "error", serveErr)
+			s.sendError(f, serveErr)
+			return
+		}
+		s.sendReceipt(f)
+	}
+}
+
+// sendReceipt acknowledges f, if it requested a receipt.
+func (s *Session) sendReceipt(f *Frame) {
+	receipt, ok := f.Header.Get(HdrReceipt)
+
+	if !ok {
+		return
+	}
+	r := NewFrame(CmdReceipt, nil)
+	r.Header.Set(HdrReceiptId, receipt)
+	s.Send(context.Background(), r)
+}
+
+// sendError tells the client that err occurred while processing f.
+// The session must be closed after an ERROR frame is sent.
+func (s *Session) sendError(f *Frame, err error) {
+	e := NewFrame(CmdError, strings.NewReader(err.Error()))
+	e.Header.Set(HdrMessage, err.Error())
+	e.Header.Set(HdrContentType, "text/plain")
+
+	if receipt, ok := f.Header.Get(HdrReceipt); ok {
+		e.Header.Set(HdrReceiptId, receipt)
+	}
+
+	if "" == s.version {
+		e.Header.Set(HdrVersion, strings.Join(supportedVersions, ","))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	s.Send(ctx, e)
+	cancel()
+}
@@ -0,0 +1,709 @@
+// Package stomptest provides tools for testing STOMP servers: a raw
+// connection that checks the frames a server sends, and a suite of
+// conformance tests for the STOMP 1.0, 1.1 and 1.2 specifications.
+package stomptest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+const (
+	// DefaultTimeout is how long the suite waits for an expected
+	// frame when a Suite's Timeout is zero.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultQuiet is how long the suite waits to conclude that a
+	// frame is not coming when a Suite's Quiet is zero.
+	DefaultQuiet = 250 * time.Millisecond
+
+	// DefaultPrefix begins the destinations the suite uses when a
+	// Suite's Prefix is empty.
+	DefaultPrefix = "/queue/"
+)
+
+// A DialFunc opens a new connection to the server under test.
+type DialFunc func() (io.ReadWriteCloser, error)
+
+// A Suite is a set of conformance tests for a STOMP server.
+//
+// The tests use destinations that begin with Prefix, whose messages
+// must be kept until a subscriber arrives, and delivered to a single
+// subscriber: queue semantics, in most brokers. Each test uses
+// destinations of its own, so a server may be shared with other
+// tests.
+type Suite struct {
+	// Dial opens a connection to the server.
+	Dial DialFunc
+
+	// Header is added to every CONNECT frame, for credentials and
+	// the like.
+	Header stomp.Header
+
+	// Prefix begins the names of destinations. Empty selects
+	// DefaultPrefix.
+	Prefix string
+
+	// Timeout limits how long the suite waits for an expected
+	// frame. Zero selects DefaultTimeout.
+	Timeout time.Duration
+
+	// Quiet is how long the suite waits before concluding that a
+	// frame that should not be sent was not. Zero selects
+	// DefaultQuiet.
+	Quiet time.Duration
+}
+
+// RunConformance runs the conformance suite against the server that
+// dial connects to, with default settings.
+func RunConformance(t *testing.T, dial DialFunc) {
+	(&Suite{Dial: dial}).Run(t)
+}
+
+// Run runs each conformance test as a subtest of t.
+func (s *Suite) Run(t *testing.T) {
+	t.Run("VersionNegotiation", s.testVersionNegotiation)
+	t.Run("FrameBeforeConnect", s.testFrameBeforeConnect)
+	t.Run("HeartBeat", s.testHeartBeat)
+	t.Run("Receipts", s.testReceipts)
+	t.Run("Message", s.testMessage)
+	t.Run("Escaping", s.testEscaping)
+	t.Run("AckAuto", s.testAckAuto)
+	t.Run("AckClient", s.testAckClient)
+	t.Run("AckClientIndividual", s.testAckClientIndividual)
+	t.Run("Nack", s.testNack)
+	t.Run("Transaction", s.testTransaction)
+	t.Run("TransactionalAck", s.testTransactionalAck)
+	t.Run("ErrorThenClose", s.testErrorThenClose)
+}
+
+func (s *Suite) timeout() time.Duration {
+	if 0 == s.Timeout {
+		return DefaultTimeout
+	}
+	return s.Timeout
+}
+
+func (s *Suite) quiet() time.Duration {
+	if 0 == s.Quiet {
+		return DefaultQuiet
+	}
+	return s.Quiet
+}
+
+// destinationSeq keeps the destinations of repeated runs apart.
+var destinationSeq uint64
+
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// destination returns a destination used by no other test.
+func (s *Suite) destination(t *testing.T) string {
+	prefix := s.Prefix
+
+	if "" == prefix {
+		prefix = DefaultPrefix
+	}
+	name := strings.Trim(unsafeName.ReplaceAllString(t.Name(), "."), ".")
+	seq := atomic.AddUint64(&destinationSeq, 1)
+	return prefix + "stomptest." + strings.ToLower(name) + "." + strconv.FormatUint(seq, 10)
+}
+
+// open dials the server and sends it a connection frame with the
+// given accept-version header, which is left out when empty.
+func (s *Suite) open(t *testing.T, cmd stomp.Command, acceptVersion string, header stomp.Header) *Conn {
+	t.Helper()
+	rwc, dialErr := s.Dial()
+
+	if nil != dialErr {
+		t.Fatalf("dialing server: %v", dialErr)
+	}
+	c := NewConn(t, rwc, s.timeout())
+	f := stomp.NewFrame(cmd, nil)
+
+	for k, v := range s.Header {
+		f.Header[k] = append([]string(nil), v...)
+	}
+
+	for k, v := range header {
+		f.Header[k] = append([]string(nil), v...)
+	}
+
+	if "" != acceptVersion {
+		f.Header.Set(stomp.HdrAcceptVersion, acceptVersion)
+	}
+
+	if _, ok := f.Header.Get(stomp.HdrHost); !ok {
+		f.Header.Set(stomp.HdrHost, "/")
+	}
+	c.Send(f)
+	return c
+}
+
+// connect opens a session that speaks the given protocol version.
+func (s *Suite) connect(t *testing.T, version string) *Conn {
+	t.Helper()
+	c := s.open(t, stomp.CmdConnect, version, nil)
+	connected := c.Expect(stomp.CmdConnected)
+
+	if got := negotiated(connected); version != got {
+		c.Close()
+		t.Fatalf("asked for version %s, got %s", version, got)
+	}
+	return c
+}
+
+// negotiated returns the version a CONNECTED frame settles on. A
+// frame without a version header is from a STOMP 1.0 server.
+func negotiated(connected *stomp.Frame) string {
+	if v, ok := connected.Header.Get(stomp.HdrVersion); ok {
+		return v
+	}
+	return "1.0"
+}
+
+// disconnect ends a session gracefully, waiting for the server to
+// confirm that it has processed every frame sent before.
+func disconnect(c *Conn) {
+	c.t.Helper()
+	f := stomp.NewFrame(stomp.CmdDisconnect, nil)
+	f.Header.Set(stomp.HdrReceipt, "disconnect")
+	c.Send(f)
+	c.ExpectReceipt("disconnect")
+	c.Close()
+}
+
+// receiptSeq numbers the receipts the suite asks for.
+var receiptSeq uint64
+
+// request sends f with a receipt header, and waits for the receipt.
+func request(c *Conn, f *stomp.Frame) {
+	c.t.Helper()
+	id := "receipt-" + strconv.FormatUint(atomic.AddUint64(&receiptSeq, 1), 10)
+	f.Header.Set(stomp.HdrReceipt, id)
+	c.Send(f)
+	c.ExpectReceipt(id)
+}
+
+func subscribe(c *Conn, destination, id, ack string) {
+	c.t.Helper()
+	f := stomp.NewFrame(stomp.CmdSubscribe, nil)
+	f.Header.Set(stomp.HdrDestination, destination)
+	f.Header.Set(stomp.HdrId, id)
+	f.Header.Set(stomp.HdrAck, ack)
+	request(c, f)
+}
+
+// send sends a text message, in the given transaction when tx is
+// not empty.
+func send(c *Conn, destination, body, tx string) {
+	c.t.Helper()
+	f := stomp.NewFrame(stomp.CmdSend, strings.NewReader(body))
+	f.Header.Set(stomp.HdrDestination, destination)
+	f.Header.Set(stomp.HdrContentType, "text/plain")
+
+	if "" != tx {
+		f.Header.Set(stomp.HdrTransaction, tx)
+	}
+	request(c, f)
+}
+
+func transact(c *Conn, cmd stomp.Command, tx string) {
+	c.t.Helper()
+	f := stomp.NewFrame(cmd, nil)
+	f.Header.Set(stomp.HdrTransaction, tx)
+	request(c, f)
+}
+
+// receive waits for a MESSAGE frame and checks its body.
+func receive(c *Conn, body string) *stomp.Frame {
+	c.t.Helper()
+	msg := c.Expect(stomp.CmdMessage)
+
+	if got := string(readAll(c.t, msg)); body != got {
+		c.t.Fatalf("received message %q, want %q", got, body)
+	}
+	return msg
+}
+
+// ackFrame builds the ACK or NACK frame for msg, in the form the
+// given protocol version requires.
+func ackFrame(cmd stomp.Command, version string, msg *stomp.Frame) *stomp.Frame {
+	f := stomp.NewFrame(cmd, nil)
+
+	if "1.2" == version {
+		id, _ := msg.Header.Get(stomp.HdrAck)
+		f.Header.Set(stomp.HdrId, id)
+		return f
+	}
+	messageID, _ := msg.Header.Get(stomp.HdrMessageId)
+	f.Header.Set(stomp.HdrMessageId, messageID)
+
+	if "1.1" == version {
+		sub, _ := msg.Header.Get(stomp.HdrSubscription)
+		f.Header.Set(stomp.HdrSubscription, sub)
+	}
+	return f
+}
+
+func readAll(t testing.TB, f *stomp.Frame) []byte {
+	t.Helper()
+
+	if nil == f.Body {
+		return nil
+	}
+	body, readErr := ioutil.ReadAll(f.Body)
+
+	if nil != readErr {
+		t.Fatalf("reading body: %v", readErr)
+	}
+	f.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body
+}
+
+// versions lists the protocol versions the tests run with.
+var versions = []string{"1.0", "1.1", "1.2"}
+
+func (s *Suite) testVersionNegotiation(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    stomp.Command
+		accept string
+		want   string
+	}{
+		{"All", stomp.CmdConnect, "1.0,1.1,1.2", "1.2"},
+		{"Unordered", stomp.CmdConnect, "1.2,1.0", "1.2"},
+		{"1.1", stomp.CmdConnect, "1.1", "1.1"},
+		{"1.0", stomp.CmdConnect, "1.0", "1.0"},
+		{"Absent", stomp.CmdConnect, "", "1.0"},
+		{"Unknown", stomp.CmdConnect, "1.1,9.9", "1.1"},
+		{"StompCommand", stomp.CmdStomp, "1.2", "1.2"},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			c := s.open(t, test.cmd, test.accept, nil)
+			defer c.Close()
+			connected := c.Expect(stomp.CmdConnected)
+
+			if got := negotiated(connected); test.want != got {
+				t.Fatalf("accept-version %q negotiated version %s, want %s", test.accept, got, test.want)
+			}
+			disconnect(c)
+		})
+	}
+
+	t.Run("Unsupported", func(t *testing.T) {
+		c := s.open(t, stomp.CmdConnect, "9.8,9.9", nil)
+		defer c.Close()
+		e := c.Expect(stomp.CmdError)
+
+		if v, _ := e.Header.Get(stomp.HdrVersion); "" == v {
+			t.Errorf("ERROR frame does not list the supported versions:\n%s", e.Format(stomp.VerbosityFull))
+		}
+		c.ExpectClosed()
+	})
+}
+
+func (s *Suite) testFrameBeforeConnect(t *testing.T) {
+	rwc, dialErr := s.Dial()
+
+	if nil != dialErr {
+		t.Fatalf("dialing server: %v", dialErr)
+	}
+	c := NewConn(t, rwc, s.timeout())
+	defer c.Close()
+	f := stomp.NewFrame(stomp.CmdSend, strings.NewReader("too soon"))
+	f.Header.Set(stomp.HdrDestination, s.destination(t))
+	c.Send(f)
+	c.Expect(stomp.CmdError)
+	c.ExpectClosed()
+}
+
+// serverHeartBeat returns the heart-beat header of a CONNECTED frame.
+func serverHeartBeat(t *testing.T, connected *stomp.Frame) (sx, sy time.Duration) {
+	t.Helper()
+	v, _ := connected.Header.Get(stomp.HdrHeartBeat)
+
+	if "" == v {
+		return 0, 0
+	}
+	parts := strings.Split(v, ",")
+
+	if 2 != len(parts) {
+		t.Fatalf("bad heart-beat header %q", v)
+	}
+	x, xErr := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+	y, yErr := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 32)
+
+	if nil != xErr || nil != yErr {
+		t.Fatalf("bad heart-beat header %q", v)
+	}
+	return time.Duration(x) * time.Millisecond, time.Duration(y) * time.Millisecond
+}
+
+func (s *Suite) testHeartBeat(t *testing.T) {
+	const want = 100 * time.Millisecond
+
+	t.Run("ServerSends", func(t *testing.T) {
+		c := s.open(t, stomp.CmdConnect, "1.2", stomp.Header{stomp.HdrHeartBeat: {"0,100"}})
+		defer c.Close()
+		sx, _ := serverHeartBeat(t, c.Expect(stomp.CmdConnected))
+
+		if 0 == sx {
+			t.Skip("server does not send heart-beats")
+		}
+		interval := sx
+
+		if interval < want {
+			interval = want
+		}
+
+		for i := 0; i < 3; i++ {
+			c.ExpectHeartBeat(2 * interval)
+		}
+	})
+
+	t.Run("ServerMonitors", func(t *testing.T) {
+		c := s.open(t, stomp.CmdConnect, "1.2", stomp.Header{stomp.HdrHeartBeat: {"100,0"}})
+		defer c.Close()
+		_, sy := serverHeartBeat(t, c.Expect(stomp.CmdConnected))
+
+		if 0 == sy {
+			t.Skip("server does not expect heart-beats")
+		}
+		interval := sy
+
+		if interval < want {
+			interval = want
+		}
+		deadline := time.Now().Add(4 * interval)
+
+		for time.Now().Before(deadline) {
+			c.Send(nil)
+			time.Sleep(interval / 2)
+		}
+
+		if c.Closed() {
+			t.Fatalf("server closed a connection that was sending heart-beats: %v", c.Err())
+		}
+		c.ExpectClosed()
+	})
+}
+
+func (s *Suite) testReceipts(t *testing.T) {
+	for _, version := range versions {
+		version := version
+
+		t.Run(version, func(t *testing.T) {
+			c := s.connect(t, version)
+			defer c.Close()
+			destination := s.destination(t)
+			subscribe(c, destination, "0", stomp.AckAuto)
+			send(c, destination, "with receipt", "")
+			receive(c, "with receipt")
+
+			unsubscribe := stomp.NewFrame(stomp.CmdUnsubscribe, nil)
+			unsubscribe.Header.Set(stomp.HdrId, "0")
+			request(c, unsubscribe)
+
+			f := stomp.NewFrame(stomp.CmdDisconnect, nil)
+			f.Header.Set(stomp.HdrReceipt, "last")
+			c.Send(f)
+			c.ExpectReceipt("last")
+			c.ExpectClosed()
+		})
+	}
+}
+
+func (s *Suite) testMessage(t *testing.T) {
+	body := "binary\x00body\r\n\x00"
+
+	for _, version := range versions {
+		version := version
+
+		t.Run(version, func(t *testing.T) {
+			c := s.connect(t, version)
+			defer c.Close()
+			destination := s.destination(t)
+			subscribe(c, destination, "sub-0", stomp.AckAuto)
+
+			f := stomp.NewFrame(stomp.CmdSend, strings.NewReader(body))
+			f.Header.Set(stomp.HdrDestination, destination)
+			f.Header.Set(stomp.HdrContentType, "application/octet-stream")
+			f.Header.Set("x-custom", "custom value")
+			request(c, f)
+
+			msg := receive(c, body)
+			checks := map[string]string{
+				stomp.HdrDestination:  destination,
+				stomp.HdrSubscription: "sub-0",
+				stomp.HdrContentType:  "application/octet-stream",
+				"x-custom":            "custom value",
+			}
+
+			for k, want := range checks {
+				if got, _ := msg.Header.Get(k); want != got {
+					t.Errorf("MESSAGE header %s is %q, want %q", k, got, want)
+				}
+			}
+
+			if id, _ := msg.Header.Get(stomp.HdrMessageId); "" == id {
+				t.Errorf("MESSAGE has no %s header", stomp.HdrMessageId)
+			}
+
+			// A body sent without a content-length ends at the
+			// first null.
+			unmeasured := stomp.NewFrame(stomp.CmdSend, ioutil.NopCloser(strings.NewReader("text body")))
+			unmeasured.Header.Set(stomp.HdrDestination, destination)
+			request(c, unmeasured)
+			receive(c, "text body")
+			disconnect(c)
+		})
+	}
+}
+
+func (s *Suite) testEscaping(t *testing.T) {
+	values := map[string]string{
+		"1.1": "colon:newline\nbackslash\\",
+		"1.2": "colon:newline\ncarriage\rreturn\\",
+	}
+
+	for _, version := range []string{"1.1", "1.2"} {
+		version := version
+
+		t.Run(version, func(t *testing.T) {
+			c := s.connect(t, version)
+			defer c.Close()
+			destination := s.destination(t)
+			subscribe(c, destination, "0", stomp.AckAuto)
+
+			f := stomp.NewFrame(stomp.CmdSend, strings.NewReader("escaped"))
+			f.Header.Set(stomp.HdrDestination, destination)
+			f.Header.Set("x-escaped", values[version])
+			f.Header.Set("x:colon", "name")
+			request(c, f)
+
+			msg := receive(c, "escaped")
+
+			if got, _ := msg.Header.Get("x-escaped"); values[version] != got {
+				t.Errorf("header value %q arrived as %q", values[version], got)
+			}
+
+			if got, _ := msg.Header.Get("x:colon"); "name" != got {
+				t.Errorf("header named %q arrived with value %q", "x:colon", got)
+			}
+			disconnect(c)
+		})
+	}
+}
+
+func (s *Suite) testAckAuto(t *testing.T) {
+	destination := s.destination(t)
+	c := s.connect(t, "1.2")
+	defer c.Close()
+	subscribe(c, destination, "0", stomp.AckAuto)
+	send(c, destination, "auto", "")
+	receive(c, "auto")
+	disconnect(c)
+
+	// The message was acknowledged when it was delivered, so a new
+	// subscriber must not receive it again.
+	c = s.connect(t, "1.2")
+	defer c.Close()
+	subscribe(c, destination, "0", stomp.AckAuto)
+	c.ExpectNothing(s.quiet())
+	disconnect(c)
+}
+
+func (s *Suite) testAckClient(t *testing.T) {
+	for _, version := range versions {
+		version := version
+
+		t.Run(version, func(t *testing.T) {
+			destination := s.destination(t)
+			c := s.connect(t, version)
+			defer c.Close()
+			subscribe(c, destination, "0", stomp.AckClient)
+
+			for _, body := range []string{"1", "2", "3"} {
+				send(c, destination, body, "")
+			}
+			receive(c, "1")
+			second := receive(c, "2")
+			receive(c, "3")
+
+			// Acknowledging the second message acknowledges the
+			// first, too.
+			request(c, ackFrame(stomp.CmdAck, version, second))
+			disconnect(c)
+
+			c = s.connect(t, version)
+			defer c.Close()
+			subscribe(c, destination, "0", stomp.AckAuto)
+			receive(c, "3")
+			c.ExpectNothing(s.quiet())
+			disconnect(c)
+		})
+	}
+}
+
+func (s *Suite) testAckClientIndividual(t *testing.T) {
+	for _, version := range []string{"1.1", "1.2"} {
+		version := version
+
+		t.Run(version, func(t *testing.T) {
+			destination := s.destination(t)
+			c := s.connect(t, version)
+			defer c.Close()
+			subscribe(c, destination, "0", stomp.AckClientIndividual)
+			send(c, destination, "1", "")
+			send(c, destination, "2", "")
+			receive(c, "1")
+			second := receive(c, "2")
+
+			// Acknowledging the second message leaves the first
+			// unacknowledged.
+			request(c, ackFrame(stomp.CmdAck, version, second))
+			disconnect(c)
+
+			c = s.connect(t, version)
+			defer c.Close()
+			subscribe(c, destination, "0", stomp.AckAuto)
+			receive(c, "1")
+			c.ExpectNothing(s.quiet())
+			disconnect(c)
+		})
+	}
+}
+
+func (s *Suite) testNack(t *testing.T) {
+	for _, version := range []string{"1.1", "1.2"} {
+		version := version
+
+		t.Run(version, func(t *testing.T) {
+			c := s.connect(t, version)
+			defer c.Close()
+			destination := s.destination(t)
+			subscribe(c, destination, "0", stomp.AckClientIndividual)
+			send(c, destination, "rejected", "")
+			msg := receive(c, "rejected")
+			request(c, ackFrame(stomp.CmdNack, version, msg))
+			disconnect(c)
+		})
+	}
+}
+
+func (s *Suite) testTransaction(t *testing.T) {
+	c := s.connect(t, "1.2")
+	defer c.Close()
+	destination := s.destination(t)
+	subscribe(c, destination, "0", stomp.AckAuto)
+
+	transact(c, stomp.CmdBegin, "tx-commit")
+	send(c, destination, "committed", "tx-commit")
+	c.ExpectNothing(s.quiet())
+	transact(c, stomp.CmdCommit, "tx-commit")
+	receive(c, "committed")
+
+	transact(c, stomp.CmdBegin, "tx-abort")
+	send(c, destination, "aborted", "tx-abort")
+	transact(c, stomp.CmdAbort, "tx-abort")
+	c.ExpectNothing(s.quiet())
+	disconnect(c)
+}
+
+func (s *Suite) testTransactionalAck(t *testing.T) {
+	destination := s.destination(t)
+	c := s.connect(t, "1.2")
+	defer c.Close()
+	subscribe(c, destination, "0", stomp.AckClientIndividual)
+	send(c, destination, "settled", "")
+	msg := receive(c, "settled")
+
+	ack := ackFrame(stomp.CmdAck, "1.2", msg)
+	ack.Header.Set(stomp.HdrTransaction, "tx-abort")
+	transact(c, stomp.CmdBegin, "tx-abort")
+	request(c, ack)
+	transact(c, stomp.CmdAbort, "tx-abort")
+	disconnect(c)
+
+	// The aborted acknowledgement leaves the message to be
+	// delivered again.
+	c = s.connect(t, "1.2")
+	defer c.Close()
+	subscribe(c, destination, "0", stomp.AckClientIndividual)
+	msg = receive(c, "settled")
+
+	ack = ackFrame(stomp.CmdAck, "1.2", msg)
+	ack.Header.Set(stomp.HdrTransaction, "tx-commit")
+	transact(c, stomp.CmdBegin, "tx-commit")
+	request(c, ack)
+	transact(c, stomp.CmdCommit, "tx-commit")
+	disconnect(c)
+
+	c = s.connect(t, "1.2")
+	defer c.Close()
+	subscribe(c, destination, "0", stomp.AckAuto)
+	c.ExpectNothing(s.quiet())
+	disconnect(c)
+}
+
+func (s *Suite) testErrorThenClose(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame func() *stomp.Frame
+	}{
+		{"SendWithoutDestination", func() *stomp.Frame {
+			return stomp.NewFrame(stomp.CmdSend, strings.NewReader("nowhere"))
+		}},
+		{"CommitUnknownTransaction", func() *stomp.Frame {
+			f := stomp.NewFrame(stomp.CmdCommit, nil)
+			f.Header.Set(stomp.HdrTransaction, "no-such-transaction")
+			return f
+		}},
+		{"AckUnknownMessage", func() *stomp.Frame {
+			f := stomp.NewFrame(stomp.CmdAck, nil)
+			f.Header.Set(stomp.HdrId, "no-such-message")
+			return f
+		}},
+		{"SecondConnect", func() *stomp.Frame {
+			f := stomp.NewFrame(stomp.CmdConnect, nil)
+			f.Header.Set(stomp.HdrAcceptVersion, "1.2")
+			f.Header.Set(stomp.HdrHost, "/")
+			return f
+		}},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			c := s.connect(t, "1.2")
+			defer c.Close()
+			f := test.frame()
+			f.Header.Set(stomp.HdrReceipt, "bad-frame")
+			c.Send(f)
+			e := c.ExpectError()
+
+			if id, _ := e.Header.Get(stomp.HdrReceiptId); "bad-frame" != id {
+				t.Errorf("ERROR frame has receipt-id %q, want %q", id, "bad-frame")
+			}
+
+			if msg, _ := e.Header.Get(stomp.HdrMessage); "" == msg {
+				t.Errorf("ERROR frame has no %s header", stomp.HdrMessage)
+			}
+			c.ExpectClosed()
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package stomptest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+// A Conn is a raw STOMP connection for tests. Unlike a stomp.Client,
+// it sends exactly the frames it is given and hides nothing it
+// receives, so tests can check a server's behavior on the wire.
+type Conn struct {
+	t       testing.TB
+	rwc     io.ReadWriteCloser
+	handle  *stomp.Handle
+	frames  chan *stomp.Frame
+	pending []*stomp.Frame
+	timeout time.Duration
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewConn wraps rwc, reading frames from it in the background. The
+// Conn fails t when an expected frame does not arrive within
+// timeout.
+func NewConn(t testing.TB, rwc io.ReadWriteCloser, timeout time.Duration) *Conn {
+	c := &Conn{
+		t:       t,
+		rwc:     rwc,
+		handle:  stomp.Bind(rwc),
+		frames:  make(chan *stomp.Frame, 64),
+		timeout: timeout,
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *Conn) readLoop() {
+	defer close(c.frames)
+
+	for {
+		f, readErr := c.handle.Receive(context.Background())
+
+		if nil != readErr {
+			c.mu.Lock()
+			c.err = readErr
+			c.mu.Unlock()
+			return
+		}
+
+		if nil != f {
+			body, bodyErr := readBody(f)
+
+			if nil != bodyErr {
+				c.mu.Lock()
+				c.err = bodyErr
+				c.mu.Unlock()
+				return
+			}
+			f.Body = body
+		}
+		c.frames <- f
+	}
+}
+
+// Err returns the error that ended the connection's input, once it
+// has ended.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Send sends f, failing the test if it cannot be written. A nil f
+// sends a heart-beat.
+func (c *Conn) Send(f *stomp.Frame) {
+	c.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	if sendErr := c.handle.Send(ctx, f); nil != sendErr {
+		c.t.Fatalf("sending %s: %v", f.Format(stomp.VerbosityBrief), sendErr)
+	}
+}
+
+// Next returns the next frame received within d, skipping heart-
+// beats. It returns nil if no frame arrives, or if the connection
+// ends first. Frames set aside by Await are returned first.
+func (c *Conn) Next(d time.Duration) *stomp.Frame {
+	if len(c.pending) > 0 {
+		f := c.pending[0]
+		c.pending = c.pending[1:]
+		return f
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		select {
+		case f, ok := <-c.frames:
+			if !ok {
+				return nil
+			}
+
+			if nil != f {
+				return f
+			}
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// Expect returns the next frame, skipping heart-beats, and fails the
+// test unless it arrives in time with the given command.
+func (c *Conn) Expect(cmd stomp.Command) *stomp.Frame {
+	c.t.Helper()
+	f := c.Next(c.timeout)
+
+	if nil == f {
+		c.t.Fatalf("expected %s frame, got none (connection error: %v)", cmd, c.Err())
+	}
+
+	if cmd != f.Command {
+		c.t.Fatalf("expected %s frame, got %s", cmd, f.Format(stomp.VerbosityFull))
+	}
+	return f
+}
+
+// Await returns the first frame for which match reports true, and
+// fails the test if none arrives in time. Frames that do not match
+// are set aside, to be returned by later calls in the order they
+// arrived. Await lets a test wait for, say, a RECEIPT without
+// depending on its order relative to MESSAGE frames, which STOMP
+// does not specify.
+func (c *Conn) Await(what string, match func(f *stomp.Frame) bool) *stomp.Frame {
+	c.t.Helper()
+	var skipped []*stomp.Frame
+	deadline := time.Now().Add(c.timeout)
+
+	defer func() {
+		c.pending = append(skipped, c.pending...)
+	}()
+
+	for {
+		f := c.Next(time.Until(deadline))
+
+		if nil == f {
+			c.t.Fatalf("expected %s, got none (connection error: %v)", what, c.Err())
+		}
+
+		if match(f) {
+			return f
+		}
+		skipped = append(skipped, f)
+	}
+}
+
+// ExpectReceipt waits for the RECEIPT frame with the given
+// receipt-id, failing the test if an ERROR frame arrives instead.
+func (c *Conn) ExpectReceipt(id string) *stomp.Frame {
+	c.t.Helper()
+	f := c.Await("RECEIPT "+id, func(f *stomp.Frame) bool {
+		v, _ := f.Header.Get(stomp.HdrReceiptId)
+		return stomp.CmdError == f.Command || (stomp.CmdReceipt == f.Command && v == id)
+	})
+
+	if stomp.CmdError == f.Command {
+		c.t.Fatalf("expected RECEIPT %s, got %s", id, f.Format(stomp.VerbosityFull))
+	}
+	return f
+}
+
+// ExpectError waits for an ERROR frame, setting aside other frames.
+func (c *Conn) ExpectError() *stomp.Frame {
+	c.t.Helper()
+	return c.Await("ERROR", func(f *stomp.Frame) bool {
+		return stomp.CmdError == f.Command
+	})
+}
+
+// Closed reports whether the connection's input has ended.
+func (c *Conn) Closed() bool {
+	return nil != c.Err()
+}
+
+// ExpectNothing fails the test if a frame other than a heart-beat
+// arrives within d.
+func (c *Conn) ExpectNothing(d time.Duration) {
+	c.t.Helper()
+
+	if f := c.Next(d); nil != f {
+		c.t.Fatalf("expected no frame, got %s", f.Format(stomp.VerbosityFull))
+	}
+}
+
+// ExpectHeartBeat fails the test unless a heart-beat arrives within
+// d. A frame received in the meantime also shows the peer to be
+// alive, and is kept for Next.
+func (c *Conn) ExpectHeartBeat(d time.Duration) {
+	c.t.Helper()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case f, ok := <-c.frames:
+		if !ok {
+			c.t.Fatalf("connection ended while waiting for a heart-beat: %v", c.Err())
+		}
+
+		if nil != f {
+			c.pending = append(c.pending, f)
+		}
+	case <-timer.C:
+		c.t.Fatalf("no heart-beat within %v", d)
+	}
+}
+
+// ExpectClosed fails the test unless the server closes the
+// connection in time. Frames and heart-beats received first are
+// discarded.
+func (c *Conn) ExpectClosed() {
+	c.t.Helper()
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case _, ok := <-c.frames:
+			if !ok {
+				return
+			}
+		case <-timer.C:
+			c.t.Fatal("expected the connection to be closed")
+		}
+	}
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	closeErr := c.rwc.Close()
+	c.handle.Release()
+	return closeErr
+}
+
+func readBody(f *stomp.Frame) (io.ReadCloser, error) {
+	body, readErr := ioutil.ReadAll(f.Body)
+	closeErr := f.Body.Close()
+
+	if nil == readErr {
+		readErr = closeErr
+	}
+	return ioutil.NopCloser(bytes.NewReader(body)), readErr
+}
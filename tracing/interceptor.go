@@ -0,0 +1,179 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jjware/stomp"
+)
+
+// Span names used by the interceptor.
+const (
+	SpanSend        = "stomp.send"
+	SpanReceive     = "stomp.receive"
+	SpanAck         = "stomp.ack"
+	SpanNack        = "stomp.nack"
+	SpanTransaction = "stomp.transaction"
+)
+
+// Interceptor returns an interceptor that records spans with t, and
+// injects span context into outbound SEND frames with p. Spans are
+// recorded for SEND, MESSAGE, ACK and NACK frames. A transaction is
+// recorded as a single span, begun by BEGIN and ended by COMMIT or
+// ABORT; an aborted transaction ends with ErrAborted. The spans of
+// transactions still open when a DISCONNECT frame is sent end with
+// ErrAborted, and those open when the stream fails or ends, with
+// ErrSessionEnded.
+func Interceptor(t Tracer, p Propagator) stomp.Interceptor {
+	if nil == t {
+		t = NoopTracer
+	}
+	return &interceptor{
+		t:            t,
+		p:            p,
+		transactions: make(map[string]Span),
+	}
+}
+
+var (
+	// ErrAborted is the error a transaction span ends with when the
+	// transaction is aborted.
+	ErrAborted = errors.New("transaction aborted")
+
+	// ErrSessionEnded is the error a transaction span ends with when
+	// the session ends before the transaction does.
+	ErrSessionEnded = errors.New("session ended")
+)
+
+type interceptor struct {
+	t            Tracer
+	p            Propagator
+	mu           sync.Mutex
+	transactions map[string]Span
+}
+
+func (i *interceptor) InterceptSend(next stomp.SendFunc) stomp.SendFunc {
+	return func(ctx context.Context, f *stomp.Frame) error {
+		if nil == f {
+			return next(ctx, f)
+		}
+
+		switch f.Command {
+		case stomp.CmdSend:
+			spanCtx, span := i.t.Start(ctx, SpanSend)
+			annotate(span, f.Header, stomp.HdrDestination, stomp.HdrTransaction)
+
+			if nil != i.p {
+				if nil == f.Header {
+					f.Header = make(stomp.Header)
+				}
+				i.p.Inject(spanCtx, f.Header)
+			}
+			sendErr := next(ctx, f)
+			span.End(sendErr)
+			return sendErr
+		case stomp.CmdAck, stomp.CmdNack:
+			name := SpanAck
+
+			if f.Command == stomp.CmdNack {
+				name = SpanNack
+			}
+			_, span := i.t.Start(ctx, name)
+			annotate(span, f.Header, stomp.HdrId, stomp.HdrMessageId, stomp.HdrSubscription, stomp.HdrTransaction)
+			sendErr := next(ctx, f)
+			span.End(sendErr)
+			return sendErr
+		case stomp.CmdBegin:
+			tx, _ := f.Header.Get(stomp.HdrTransaction)
+			_, span := i.t.Start(ctx, SpanTransaction)
+			span.SetAttribute(stomp.HdrTransaction, tx)
+			sendErr := next(ctx, f)
+
+			if nil != sendErr {
+				span.End(sendErr)
+				return sendErr
+			}
+			i.mu.Lock()
+			i.transactions[tx] = span
+			i.mu.Unlock()
+			return nil
+		case stomp.CmdCommit, stomp.CmdAbort:
+			tx, _ := f.Header.Get(stomp.HdrTransaction)
+			sendErr := next(ctx, f)
+
+			i.mu.Lock()
+			span, ok := i.transactions[tx]
+			delete(i.transactions, tx)
+			i.mu.Unlock()
+
+			if ok {
+				if nil == sendErr && f.Command == stomp.CmdAbort {
+					span.End(ErrAborted)
+				} else {
+					span.End(sendErr)
+				}
+			}
+			return sendErr
+		case stomp.CmdDisconnect:
+			sendErr := next(ctx, f)
+
+			if nil == sendErr {
+				i.endTransactions(ErrAborted)
+			} else {
+				i.endTransactions(fmt.Errorf("%w. %v", ErrSessionEnded, sendErr))
+			}
+			return sendErr
+		}
+		return next(ctx, f)
+	}
+}
+
+func (i *interceptor) InterceptReceive(next stomp.ReceiveFunc) stomp.ReceiveFunc {
+	return func(ctx context.Context) (*stomp.Frame, error) {
+		f, readErr := next(ctx)
+
+		// An error other than the end of the call's context ends the
+		// stream.
+		if nil != readErr && nil == ctx.Err() {
+			i.endTransactions(fmt.Errorf("%w. %v", ErrSessionEnded, readErr))
+		}
+
+		if nil == f || f.Command != stomp.CmdMessage {
+			return f, readErr
+		}
+		parent := ctx
+
+		if nil != i.p {
+			parent = i.p.Extract(ctx, f.Header)
+		}
+		_, span := i.t.Start(parent, SpanReceive)
+		annotate(span, f.Header, stomp.HdrDestination, stomp.HdrMessageId, stomp.HdrSubscription)
+		span.End(readErr)
+		return f, readErr
+	}
+}
+
+// endTransactions ends the spans of the transactions still open with
+// err.
+func (i *interceptor) endTransactions(err error) {
+	i.mu.Lock()
+	spans := i.transactions
+	i.transactions = make(map[string]Span)
+	i.mu.Unlock()
+
+	for _, span := range spans {
+		span.End(err)
+	}
+}
+
+// annotate copies the values of the named headers, when present,
+// to span attributes.
+func annotate(span Span, h stomp.Header, names ...string) {
+	for _, name := range names {
+		if v, ok := h.Get(name); ok {
+			span.SetAttribute(name, v)
+		}
+	}
+}
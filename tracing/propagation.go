@@ -0,0 +1,158 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	"github.com/jjware/stomp"
+)
+
+const (
+	HdrTraceParent = "traceparent"
+	HdrTraceState  = "tracestate"
+	HdrB3          = "b3"
+)
+
+// A Propagator moves span context into and out of frame headers.
+type Propagator interface {
+	// Inject writes the span context carried by ctx into h.
+	Inject(ctx context.Context, h stomp.Header)
+
+	// Extract returns a copy of ctx carrying the span context
+	// found in h. If h carries no valid span context, ctx is
+	// returned unchanged.
+	Extract(ctx context.Context, h stomp.Header) context.Context
+}
+
+// W3C propagates span context using the W3C Trace Context traceparent
+// and tracestate headers.
+var W3C Propagator = w3c{}
+
+type w3c struct{}
+
+func (w3c) Inject(ctx context.Context, h stomp.Header) {
+	sc, ok := SpanContextFromContext(ctx)
+
+	if !ok {
+		return
+	}
+	flags := "00"
+
+	if sc.Sampled {
+		flags = "01"
+	}
+	h.Set(HdrTraceParent, "00-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+flags)
+
+	if "" != sc.TraceState {
+		h.Set(HdrTraceState, sc.TraceState)
+	}
+}
+
+func (w3c) Extract(ctx context.Context, h stomp.Header) context.Context {
+	v, ok := h.Get(HdrTraceParent)
+
+	if !ok {
+		return ctx
+	}
+	parts := strings.Split(v, "-")
+
+	if len(parts) < 4 || len(parts[0]) != 2 || "ff" == parts[0] || ("00" == parts[0] && len(parts) != 4) {
+		return ctx
+	}
+	var sc SpanContext
+	var flags [1]byte
+
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) {
+		return ctx
+	}
+
+	if !sc.IsValid() {
+		return ctx
+	}
+	sc.Sampled = flags[0]&1 == 1
+	sc.TraceState, _ = h.Get(HdrTraceState)
+	sc.Remote = true
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// B3 propagates span context using the single b3 header of the
+// Zipkin B3 specification.
+var B3 Propagator = b3{}
+
+type b3 struct{}
+
+func (b3) Inject(ctx context.Context, h stomp.Header) {
+	sc, ok := SpanContextFromContext(ctx)
+
+	if !ok {
+		return
+	}
+	sampled := "0"
+
+	if sc.Sampled {
+		sampled = "1"
+	}
+	h.Set(HdrB3, sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+sampled)
+}
+
+func (b3) Extract(ctx context.Context, h stomp.Header) context.Context {
+	v, ok := h.Get(HdrB3)
+
+	if !ok {
+		return ctx
+	}
+	parts := strings.Split(v, "-")
+
+	if len(parts) < 2 {
+		return ctx
+	}
+	var sc SpanContext
+	traceID := parts[0]
+
+	// 64 bit trace ids are left padded
+	if len(traceID) == 16 {
+		traceID = strings.Repeat("0", 16) + traceID
+	}
+
+	if !decodeHex(sc.TraceID[:], traceID) || !decodeHex(sc.SpanID[:], parts[1]) || !sc.IsValid() {
+		return ctx
+	}
+	sc.Sampled = len(parts) > 2 && ("1" == parts[2] || "d" == parts[2])
+	sc.Remote = true
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// Propagators returns a Propagator that injects span context with
+// every one of ps, and extracts it with the first of ps to find a
+// valid span context.
+func Propagators(ps ...Propagator) Propagator {
+	return composite(ps)
+}
+
+type composite []Propagator
+
+func (c composite) Inject(ctx context.Context, h stomp.Header) {
+	for _, p := range c {
+		p.Inject(ctx, h)
+	}
+}
+
+func (c composite) Extract(ctx context.Context, h stomp.Header) context.Context {
+	for _, p := range c {
+		if extracted := p.Extract(ctx, h); extracted != ctx {
+			return extracted
+		}
+	}
+	return ctx
+}
+
+// decodeHex decodes lower case hexadecimal s into dst, reporting
+// whether s was exactly the right length and well formed.
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return nil == err
+}
@@ -0,0 +1,201 @@
+// Package tracing propagates distributed trace context through STOMP
+// headers, and records spans for the frames a Handle sends and
+// receives. Tracers for specific tracing systems are expected to live
+// outside this package and satisfy its Tracer interface.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// A TraceID identifies a trace.
+type TraceID [16]byte
+
+// IsValid reports whether id is non-zero.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// A SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// IsValid reports whether id is non-zero.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// A SpanContext is the portion of a span that propagates between
+// processes.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+
+	// Remote reports whether the span context was extracted from
+	// a frame rather than created locally.
+	Remote bool
+}
+
+// IsValid reports whether sc has both a trace and span id.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+type contextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying sc.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context carried by ctx,
+// if any.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// A Span is a timed operation.
+type Span interface {
+	// SpanContext returns the span's identity.
+	SpanContext() SpanContext
+
+	// SetAttribute annotates the span.
+	SetAttribute(key, value string)
+
+	// End completes the span. A non-nil err marks it as failed.
+	End(err error)
+}
+
+// A Tracer starts spans. The span started is a child of the span
+// context carried by ctx, if there is one, and the returned context
+// carries the new span's context.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// NoopTracer is a Tracer that records nothing. Its spans carry the
+// span context of their parent, so context still propagates.
+var NoopTracer Tracer = noopTracer{}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	sc, _ := SpanContextFromContext(ctx)
+	return ctx, noopSpan{sc}
+}
+
+type noopSpan struct {
+	sc SpanContext
+}
+
+func (s noopSpan) SpanContext() SpanContext       { return s.sc }
+func (s noopSpan) SetAttribute(key, value string) {}
+func (s noopSpan) End(err error)                  {}
+
+// A RecordedSpan is a span kept by a Recorder.
+type RecordedSpan struct {
+	Name        string
+	SpanContext SpanContext
+	Parent      SpanContext
+	Attributes  map[string]string
+	Start       time.Time
+	End         time.Time
+	Err         error
+}
+
+// A Recorder is a Tracer that keeps finished spans in memory. It is
+// intended for tests.
+type Recorder struct {
+	mu    sync.Mutex
+	spans []RecordedSpan
+}
+
+// Start starts a span that is kept by the recorder once it ends.
+func (r *Recorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := SpanContextFromContext(ctx)
+	sc := SpanContext{
+		TraceID:    parent.TraceID,
+		Sampled:    true,
+		TraceState: parent.TraceState,
+	}
+
+	if !sc.TraceID.IsValid() {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+
+	s := &recordedSpan{
+		r: r,
+		span: RecordedSpan{
+			Name:        name,
+			SpanContext: sc,
+			Parent:      parent,
+			Attributes:  make(map[string]string),
+			Start:       time.Now(),
+		},
+	}
+	return ContextWithSpanContext(ctx, sc), s
+}
+
+// Spans returns the spans that have ended, in the order they ended.
+func (r *Recorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedSpan(nil), r.spans...)
+}
+
+// Reset discards all recorded spans.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.spans = nil
+	r.mu.Unlock()
+}
+
+type recordedSpan struct {
+	r    *Recorder
+	mu   sync.Mutex
+	span RecordedSpan
+	done bool
+}
+
+func (s *recordedSpan) SpanContext() SpanContext {
+	return s.span.SpanContext
+}
+
+func (s *recordedSpan) SetAttribute(key, value string) {
+	s.mu.Lock()
+	s.span.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+func (s *recordedSpan) End(err error) {
+	s.mu.Lock()
+
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	s.span.End = time.Now()
+	s.span.Err = err
+	span := s.span
+	s.mu.Unlock()
+
+	s.r.mu.Lock()
+	s.r.spans = append(s.r.spans, span)
+	s.r.mu.Unlock()
+}
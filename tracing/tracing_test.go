@@ -0,0 +1,176 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjware/stomp"
+)
+
+type stream struct {
+	io.Reader
+	io.Writer
+}
+
+func TestPropagation(t *testing.T) {
+	sc := SpanContext{
+		TraceID:    TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		Sampled:    true,
+		TraceState: "congo=t61rcWkgMzE",
+	}
+	ctx := ContextWithSpanContext(context.Background(), sc)
+
+	for _, p := range []Propagator{W3C, B3, Propagators(B3, W3C)} {
+		h := make(stomp.Header)
+		p.Inject(ctx, h)
+		extracted, ok := SpanContextFromContext(p.Extract(context.Background(), h))
+
+		if !ok {
+			t.Errorf("%T: no span context extracted from %v", p, h)
+			continue
+		}
+
+		if extracted.TraceID != sc.TraceID || extracted.SpanID != sc.SpanID || !extracted.Sampled || !extracted.Remote {
+			t.Errorf("%T: extracted %+v want %+v", p, extracted, sc)
+		}
+	}
+	h := make(stomp.Header)
+	W3C.Inject(ctx, h)
+
+	if v, _ := h.Get(HdrTraceParent); v != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("traceparent = %q", v)
+	}
+
+	if v, _ := h.Get(HdrTraceState); v != sc.TraceState {
+		t.Errorf("tracestate = %q", v)
+	}
+
+	for _, bad := range []string{
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		h := stomp.Header{HdrTraceParent: {bad}}
+
+		if _, ok := SpanContextFromContext(W3C.Extract(context.Background(), h)); ok {
+			t.Errorf("extracted span context from %q", bad)
+		}
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	in := "MESSAGE\n" +
+		"destination:/queue/a\n" +
+		"message-id:1\n" +
+		"traceparent:00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\n" +
+		"\n" +
+		"\x00"
+
+	var out bytes.Buffer
+	handle := stomp.Bind(stream{strings.NewReader(in), &out})
+	defer handle.Release()
+
+	recorder := &Recorder{}
+	handle.Use(Interceptor(recorder, W3C))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	send := func(cmd stomp.Command, hdr ...string) {
+		f := stomp.NewFrame(cmd, nil)
+
+		for i := 0; i+1 < len(hdr); i += 2 {
+			f.Header.Set(hdr[i], hdr[i+1])
+		}
+
+		if sendErr := handle.Send(ctx, f); nil != sendErr {
+			t.Fatal(sendErr)
+		}
+	}
+	send(stomp.CmdBegin, stomp.HdrTransaction, "tx1")
+	send(stomp.CmdSend, stomp.HdrDestination, "/queue/b", stomp.HdrTransaction, "tx1")
+	send(stomp.CmdAbort, stomp.HdrTransaction, "tx1")
+
+	msg, readErr := handle.Receive(ctx)
+
+	if nil != readErr {
+		t.Fatal(readErr)
+	}
+	msg.Body.Close()
+	send(stomp.CmdAck, stomp.HdrId, "1")
+
+	spans := recorder.Spans()
+	var names []string
+
+	for _, s := range spans {
+		names = append(names, s.Name)
+	}
+
+	if strings.Join(names, ",") != "stomp.send,stomp.transaction,stomp.receive,stomp.ack" {
+		t.Fatalf("spans = %v", names)
+	}
+
+	if !strings.Contains(out.String(), "traceparent:00-"+spans[0].SpanContext.TraceID.String()+"-"+spans[0].SpanContext.SpanID.String()+"-01\n") {
+		t.Errorf("traceparent not injected: %q", out.String())
+	}
+
+	if ErrAborted != spans[1].Err {
+		t.Errorf("aborted transaction ended with %v", spans[1].Err)
+	}
+	received := spans[2]
+
+	if received.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || received.Parent.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("receive span not parented to producer: %+v", received)
+	}
+
+	if received.Attributes[stomp.HdrDestination] != "/queue/a" {
+		t.Errorf("receive span attributes = %v", received.Attributes)
+	}
+}
+
+func TestInterceptorSessionEnd(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, disconnect := range []bool{false, true} {
+		handle := stomp.Bind(stream{strings.NewReader(""), ioutil.Discard})
+		recorder := &Recorder{}
+		handle.Use(Interceptor(recorder, W3C))
+
+		// A frame built without a header has one made for the trace.
+		if sendErr := handle.Send(ctx, &stomp.Frame{Command: stomp.CmdSend}); nil != sendErr {
+			t.Fatal(sendErr)
+		}
+		begin := stomp.NewFrame(stomp.CmdBegin, nil)
+		begin.Header.Set(stomp.HdrTransaction, "tx")
+
+		if sendErr := handle.Send(ctx, begin); nil != sendErr {
+			t.Fatal(sendErr)
+		}
+		want := ErrSessionEnded
+
+		if disconnect {
+			want = ErrAborted
+
+			if sendErr := handle.Send(ctx, stomp.NewFrame(stomp.CmdDisconnect, nil)); nil != sendErr {
+				t.Fatal(sendErr)
+			}
+		} else if _, readErr := handle.Receive(ctx); io.EOF != readErr {
+			t.Fatalf("expected %v, got %v", io.EOF, readErr)
+		}
+		handle.Release()
+		spans := recorder.Spans()
+
+		if 2 != len(spans) || SpanTransaction != spans[1].Name || !errors.Is(spans[1].Err, want) {
+			t.Errorf("disconnect %v: spans = %+v, expected the transaction to end with %v", disconnect, spans, want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrTransactionDone is returned by the methods of a Transaction that
+// has already been committed or aborted.
+var ErrTransactionDone = errors.New("transaction already committed or aborted")
+
+// A Transaction groups SEND, ACK and NACK frames that the server
+// applies atomically when the transaction is committed. The methods
+// of a Transaction are thread safe.
+type Transaction struct {
+	c    *Client
+	id   string
+	mu   sync.Mutex
+	done bool
+}
+
+// Begin starts a transaction.
+func (c *Client) Begin(ctx context.Context) (*Transaction, error) {
+	tx := &Transaction{c: c, id: c.newID("tx-")}
+	f := NewFrame(CmdBegin, nil)
+	f.Header.Set(HdrTransaction, tx.id)
+
+	if sendErr := c.send(ctx, f); nil != sendErr {
+		return nil, sendErr
+	}
+	return tx, nil
+}
+
+// ID returns the transaction's identifier.
+func (t *Transaction) ID() string {
+	return t.id
+}
+
+// Send sends frame to destination within the transaction. See
+// Client.Send.
+func (t *Transaction) Send(ctx context.Context, destination string, frame *Frame) error {
+	frame = outgoing(frame)
+	frame.Command = CmdSend
+	frame.Header.Set(HdrDestination, destination)
+	return t.send(ctx, frame)
+}
+
+// Ack acknowledges the consumption of msg within the transaction.
+func (t *Transaction) Ack(ctx context.Context, msg *Frame) error {
+	return t.send(ctx, t.c.ackFrame(CmdAck, msg))
+}
+
+// Nack rejects msg within the transaction.
+func (t *Transaction) Nack(ctx context.Context, msg *Frame) error {
+	return t.send(ctx, t.c.ackFrame(CmdNack, msg))
+}
+
+// Commit commits the transaction, waiting for the server to
+// acknowledge it.
+func (t *Transaction) Commit(ctx context.Context) error {
+	return t.end(ctx, CmdCommit)
+}
+
+// Abort rolls back the transaction, waiting for the server to
+// acknowledge it.
+func (t *Transaction) Abort(ctx context.Context) error {
+	return t.end(ctx, CmdAbort)
+}
+
+func (t *Transaction) send(ctx context.Context, f *Frame) error {
+	t.mu.Lock()
+	done := t.done
+	t.mu.Unlock()
+
+	if done {
+		return ErrTransactionDone
+	}
+	f.Header.Set(HdrTransaction, t.id)
+	return t.c.send(ctx, f)
+}
+
+func (t *Transaction) end(ctx context.Context, cmd Command) error {
+	t.mu.Lock()
+
+	if t.done {
+		t.mu.Unlock()
+		return ErrTransactionDone
+	}
+	t.done = true
+	t.mu.Unlock()
+
+	f := NewFrame(cmd, nil)
+	f.Header.Set(HdrTransaction, t.id)
+	f.Header.Set(HdrReceipt, t.c.newID("receipt-"))
+	return t.c.send(ctx, f)
+}
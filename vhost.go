@@ -0,0 +1,117 @@
+package stomp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrUnknownHost is sent to a client whose CONNECT frame names a
+	// host the server does not serve.
+	ErrUnknownHost = errors.New("unknown host")
+
+	// ErrTooManySessions is sent to a client connecting to a host
+	// that has as many sessions as it allows.
+	ErrTooManySessions = errors.New("too many sessions")
+
+	// ErrBodyTooLarge is sent to a client sending a frame whose body
+	// exceeds the MaxBodySize of its host.
+	ErrBodyTooLarge = errors.New("frame body too large")
+)
+
+// A VirtualHost is one of the hosts a Server serves, chosen by the
+// host header of the CONNECT frame. Each host has its own Handler, so
+// the destinations of one are not those of another, and its own
+// Authenticator, Authorizer and limits. A VirtualHost must not be
+// changed while the server is serving it.
+type VirtualHost struct {
+	// Handler handles the frames of the host's sessions.
+	Handler Handler
+
+	// Authenticator, when set, checks the credentials of the CONNECT
+	// frames naming the host.
+	Authenticator Authenticator
+
+	// Authorizer, when set, decides which SEND, SUBSCRIBE, ACK, NACK
+	// and BEGIN frames of the host's sessions reach its Handler.
+	Authorizer Authorizer
+
+	// MaxSessions limits the number of sessions open on the host at
+	// once. A client connecting beyond it is sent an ERROR frame.
+	// Zero leaves it unlimited.
+	MaxSessions int
+
+	// MaxBodySize limits the size, in bytes, of the body of a frame
+	// sent by a client of the host. A session sending a larger one is
+	// sent an ERROR frame and closed, without the body being held in
+	// memory. Zero leaves it unlimited.
+	MaxBodySize int64
+
+	mu       sync.Mutex
+	sessions int
+}
+
+// Sessions returns the number of sessions open on the host.
+func (h *VirtualHost) Sessions() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions
+}
+
+// acquire counts a new session of the host, unless it has as many as
+// it allows.
+func (h *VirtualHost) acquire() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.MaxSessions > 0 && h.sessions >= h.MaxSessions {
+		return fmt.Errorf("%w. the host allows %d", ErrTooManySessions, h.MaxSessions)
+	}
+	h.sessions++
+	return nil
+}
+
+// release ends the count of a session of the host.
+func (h *VirtualHost) release() {
+	h.mu.Lock()
+	h.sessions--
+	h.mu.Unlock()
+}
+
+// virtualHost returns the host named by a CONNECT frame's host
+// header. A server without Hosts serves a single host, made of its
+// own Handler, Authenticator and Authorizer.
+func (srv *Server) virtualHost(name string) (*VirtualHost, error) {
+	if nil == srv.Hosts {
+		return &VirtualHost{
+			Handler:       srv.Handler,
+			Authenticator: srv.Authenticator,
+			Authorizer:    srv.Authorizer,
+		}, nil
+	}
+	h, ok := srv.Hosts[name]
+
+	if !ok {
+		return nil, fmt.Errorf("%w. %q is not served", ErrUnknownHost, name)
+	}
+	return h, nil
+}
+
+// connectBodySize returns the limit on the body of a CONNECT frame,
+// which is read before the frame names its host: the largest of the
+// hosts' limits, or none if a host has none.
+func (srv *Server) connectBodySize() int64 {
+	var limit int64
+
+	for _, h := range srv.Hosts {
+		if h.MaxBodySize <= 0 {
+			return 0
+		}
+
+		if h.MaxBodySize > limit {
+			limit = h.MaxBodySize
+		}
+	}
+	return limit
+}
@@ -0,0 +1,128 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	a := &VirtualHost{
+		Handler:       &relayHandler{subs: make(map[*Session]map[string]string)},
+		Authenticator: StaticUsers{"alice": "secret"},
+		MaxSessions:   1,
+	}
+	b := &VirtualHost{
+		Handler:     &relayHandler{subs: make(map[*Session]map[string]string)},
+		MaxBodySize: 4,
+	}
+	srv, connect := newTestServer(nil)
+	defer srv.Close()
+	srv.Hosts = map[string]*VirtualHost{"a.example": a, "b.example": b}
+
+	expectError := func(connectErr error, want error) {
+		t.Helper()
+		var serverErr *ServerError
+
+		if !errors.As(connectErr, &serverErr) || !strings.HasPrefix(serverErr.Message, want.Error()) {
+			t.Errorf("expected an ERROR frame saying %q, got %v", want, connectErr)
+		}
+	}
+	_, connectErr := connect(t, Header{HdrHost: {"c.example"}})
+	expectError(connectErr, ErrUnknownHost)
+	_, connectErr = connect(t, Header{HdrHost: {"a.example"}, HdrLogin: {"bob"}, HdrPasscode: {"secret"}})
+	expectError(connectErr, ErrAuthenticationFailed)
+
+	alice, connectErr := connect(t, Header{HdrHost: {"a.example"}, HdrLogin: {"alice"}, HdrPasscode: {"secret"}})
+
+	if nil != connectErr {
+		t.Fatal(connectErr)
+	}
+	defer alice.Close()
+	_, connectErr = connect(t, Header{HdrHost: {"a.example"}, HdrLogin: {"alice"}, HdrPasscode: {"secret"}})
+	expectError(connectErr, ErrTooManySessions)
+
+	var clients []*Client
+
+	for i := 0; i < 2; i++ {
+		client, connectErr := connect(t, Header{HdrHost: {"b.example"}})
+
+		if nil != connectErr {
+			t.Fatal(connectErr)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+	}
+
+	if 1 != a.Sessions() || 2 != b.Sessions() {
+		t.Errorf("hosts have %d and %d sessions", a.Sessions(), b.Sessions())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sub, subErr := clients[0].Subscribe(ctx, "/queue/x", Header{HdrReceipt: {"sub"}})
+
+	if nil != subErr {
+		t.Fatal(subErr)
+	}
+
+	// Destinations of the same name on different hosts are apart.
+	// Alice's message would arrive first if it crossed hosts.
+	for i, sender := range []*Client{alice, clients[1]} {
+		f := NewFrame(CmdSend, strings.NewReader("ab"[i:i+1]))
+		f.Header.Set(HdrReceipt, "sent")
+
+		if sendErr := sender.Send(ctx, "/queue/x", f); nil != sendErr {
+			t.Fatal(sendErr)
+		}
+	}
+	msg, receiveErr := sub.Receive(ctx)
+
+	if nil != receiveErr {
+		t.Fatal(receiveErr)
+	}
+	body, _ := ioutil.ReadAll(msg.Body)
+
+	if "b" != string(body) {
+		t.Errorf("received %q", body)
+	}
+
+	f := NewFrame(CmdSend, strings.NewReader("large"))
+	f.Header.Set(HdrReceipt, "sent")
+	var serverErr *ServerError
+
+	// The frame is refused from its content-length header, so the
+	// session may end before the client has written the body.
+	sendErr := clients[1].Send(ctx, "/queue/x", f)
+
+	select {
+	case <-clients[1].Done():
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+
+	if !errors.As(clients[1].Err(), &serverErr) || !strings.HasPrefix(serverErr.Message, ErrBodyTooLarge.Error()) {
+		t.Errorf("expected an ERROR frame saying %q, got %v (sending returned %v)", ErrBodyTooLarge, clients[1].Err(), sendErr)
+	}
+
+	// A CONNECT frame is held to the limit of the host it names.
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go srv.ServeConn(serverConn)
+	cf := NewFrame(CmdConnect, strings.NewReader("large"))
+	cf.Header.Set(HdrAcceptVersion, "1.2")
+	cf.Header.Set(HdrHost, "b.example")
+	go cf.WriteTo(clientConn)
+	reply, readErr := ReadFrame(clientConn)
+
+	if nil != readErr {
+		t.Fatal(readErr)
+	}
+
+	if msg, _ := reply.Header.Get(HdrMessage); CmdError != reply.Command || !strings.HasPrefix(msg, ErrBodyTooLarge.Error()) {
+		t.Errorf("expected an ERROR frame saying %q, got %s", ErrBodyTooLarge, reply.Format(VerbosityBrief))
+	}
+}